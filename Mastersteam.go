@@ -4,13 +4,18 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -29,17 +34,38 @@ var (
 )
 
 var (
-	sOutputBuffer bytes.Buffer
-	sNumServers   int64
-	master        valve.MasterQuerier
+	// Shared sockets for A2S queries. If nil, each query opens its own.
+	udpMux *valve.UdpMux
+
+	// Workers shared by all requests, capping the number of A2S queries in
+	// flight. If nil, each request runs its own goroutines.
+	queryPool *batch.Pool
+
+	// Number of servers queried concurrently per request. The limit starts
+	// at queryWorkers and adapts to loss, up to queryMaxWorkers.
+	queryWorkers    = 20
+	queryMaxWorkers = 100
+
+	// Time allowed for each A2S exchange, retransmissions included.
+	queryTimeout = 3 * time.Second
+
+	// Replies slower than this make the query limit back off.
+	queryLatencyTarget = time.Second
+
+	queryRetry = valve.RetryPolicy{
+		Retries:        2,
+		InitialTimeout: 500 * time.Millisecond,
+	}
 )
 
 /*
 ErrorObject ...
 */
 type ErrorObject struct {
-	IP    string `json:"ip"`
-	Error string `json:"error"`
+	IP       string     `json:"ip"`
+	Error    string     `json:"error"`
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+	Sources  []string   `json:"sources,omitempty"`
 }
 
 /*
@@ -67,31 +93,69 @@ type ServerObject struct {
 	GameID      string      `json:"gameid,omitempty"`
 
 	PlayersOnline []*valve.Player `json:"players_online,omitempty"`
+
+	// When the reply was received, if it was served from the cache.
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+
+	// The server list sources that listed the server, when several were
+	// merged.
+	Sources []string `json:"sources,omitempty"`
 }
 
-func addJSON(hostAndPort string, obj interface{}) {
-	buf, err := json.Marshal(obj)
-	if err != nil {
-		panic(err)
-	}
+// writeResults renders query results as the response body: an object keyed
+// by server address, plus the number of servers.
+func writeResults(w io.Writer, results []batch.Result[*net.TCPAddr, *ServerObject]) error {
+	var out bytes.Buffer
+	out.WriteString("{\n")
+	out.WriteString("\t\"data\" : [{")
 
-	var indented bytes.Buffer
-	json.Indent(&indented, buf, "\t", "\t")
+	for i, result := range results {
+		hostAndPort := result.Item.String()
 
-	if sNumServers != 0 {
-		sOutputBuffer.WriteString(",")
+		var obj interface{} = result.Value
+		if result.Err != nil {
+			obj = newErrorObject(hostAndPort, result.Err)
+		}
+		buf, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+
+		if i != 0 {
+			out.WriteString(",")
+		}
+		fmt.Fprintf(&out, "\n\t\"%s\": ", hostAndPort)
+		json.Indent(&out, buf, "\t", "\t")
 	}
 
-	sOutputBuffer.WriteString(fmt.Sprintf("\n\t\"%s\": ", hostAndPort))
-	sOutputBuffer.WriteString(indented.String())
+	out.WriteString("}],\n")
+	fmt.Fprintf(&out, "\t\"total\":%d\n", len(results))
+	out.WriteString("}\n")
 
-	sNumServers++
+	_, err := w.Write(out.Bytes())
+	return err
 }
 
-func addError(hostAndPort string, err error) {
+// newErrorObject logs a failed query and describes it for the response.
+func newErrorObject(hostAndPort string, err error) *ErrorObject {
+	logQueryError(hostAndPort, err)
+	return buildErrorObject(hostAndPort, err)
+}
+
+// logQueryError logs the details of a failed query.
+func logQueryError(hostAndPort string, err error) {
 	// 记录详细错误到日志
-	log.Printf("⚠️  Server query error [%s]: %s", hostAndPort, err.Error())
+	var panicErr *batch.PanicError
+	if errors.As(err, &panicErr) {
+		log.Printf("⚠️  Server query panicked [%s]: %v\n%s", hostAndPort, panicErr.Value, panicErr.Stack)
+	} else {
+		log.Printf("⚠️  Server query error [%s]: %s", hostAndPort, err.Error())
+	}
+}
 
+// buildErrorObject describes a failed query for the response, without
+// logging it.
+func buildErrorObject(hostAndPort string, err error) *ErrorObject {
 	// 只返回通用错误消息给用户，不暴露敏感信息
 	userFriendlyError := "Query failed"
 	if strings.Contains(err.Error(), "timeout") {
@@ -102,10 +166,19 @@ func addError(hostAndPort string, err error) {
 		userFriendlyError = "Host unreachable"
 	}
 
-	addJSON(hostAndPort, &ErrorObject{
+	out := &ErrorObject{
 		IP:    hostAndPort,
 		Error: userFriendlyError,
-	})
+	}
+	var cached *cachedError
+	if errors.As(err, &cached) {
+		out.CachedAt = &cached.cachedAt
+	}
+	var sourced *sourcedError
+	if errors.As(err, &sourced) {
+		out.Sources = sourced.sources
+	}
+	return out
 }
 
 /*
@@ -122,6 +195,21 @@ func Log(handler http.Handler) http.Handler {
 func handleQueryError(w http.ResponseWriter, err error) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
+	statusCode, userMessage := describeQueryError(err)
+	w.WriteHeader(statusCode)
+
+	// 只返回友好的错误消息给用户，不包含敏感的技术细节
+	errorResponse := map[string]interface{}{
+		"error":  userMessage,
+		"status": statusCode,
+	}
+
+	json.NewEncoder(w).Encode(errorResponse)
+}
+
+// describeQueryError logs a server list failure and picks the status code and
+// message to show users.
+func describeQueryError(err error) (int, string) {
 	errMsg := err.Error()
 	statusCode := http.StatusInternalServerError
 	userMessage := "Failed to query server list"
@@ -143,165 +231,336 @@ func handleQueryError(w http.ResponseWriter, err error) {
 	} else {
 		log.Printf("⚠️  ERROR: Query failed - %s", errMsg)
 	}
+	return statusCode, userMessage
+}
 
-	w.WriteHeader(statusCode)
+// isFresh reports whether the request asked to bypass the reply cache.
+func isFresh(r *http.Request) bool {
+	fresh, _ := strconv.ParseBool(r.URL.Query().Get("fresh"))
+	return fresh
+}
 
-	// 只返回友好的错误消息给用户，不包含敏感的技术细节
-	errorResponse := map[string]interface{}{
-		"error":  userMessage,
-		"status": statusCode,
+func httpMasterSearch(w http.ResponseWriter, r *http.Request) {
+	format, err := requestFormat(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid export: "+err.Error())
+		return
 	}
 
-	json.NewEncoder(w).Encode(errorResponse)
-}
+	sources, err := requestSources(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid source: "+err.Error())
+		return
+	}
 
-func httpMasterSearch(w http.ResponseWriter, r *http.Request) {
-	uriSegments := strings.Split(r.URL.String(), "/")
+	uriSegments := strings.Split(r.URL.EscapedPath(), "/")
 	appID, _ := strconv.Atoi(uriSegments[2])
 	hostname, _ := url.QueryUnescape(uriSegments[3])
 
-	newWebAPIQuerier()
+	master, err := openSources(sources)
+	if err != nil {
+		handleQueryError(w, err)
+		return
+	}
+	defer master.Close()
 
 	// Set up the filter list.
 	master.FilterAppId(valve.AppId(appID))
 	master.FilterName(hostname)
 
-	err := newServerQuerier()
+	results, err := queryServers(master, queryOptions{
+		Priority: batch.PriorityBulk,
+		Fresh:    isFresh(r),
+	})
 	if err != nil {
 		handleQueryError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	fmt.Fprintf(w, "%s", sOutputBuffer.String())
+	format.write(w, results)
 }
 
 func httpServer(w http.ResponseWriter, r *http.Request) {
-	uriSegments := strings.Split(r.URL.String(), "/")
+	format, err := requestFormat(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid export: "+err.Error())
+		return
+	}
+
+	sources, err := requestSources(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid source: "+err.Error())
+		return
+	}
+
+	uriSegments := strings.Split(r.URL.EscapedPath(), "/")
 	host, _ := url.QueryUnescape(uriSegments[2])
 
-	newWebAPIQuerier()
+	master, err := openSources(sources)
+	if err != nil {
+		handleQueryError(w, err)
+		return
+	}
+	defer master.Close()
 
 	master.FilterGameaddr(host)
 
-	err := newServerQuerier()
+	results, err := queryServers(master, queryOptions{
+		Priority: batch.PriorityInteractive,
+		Fresh:    isFresh(r),
+	})
 	if err != nil {
 		handleQueryError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	fmt.Fprintf(w, "%s", sOutputBuffer.String())
+	format.write(w, results)
 }
 
-func newWebAPIQuerier() {
-	// Create Steam Web API querier
-	m, err := valve.NewSteamWebAPIQuerier(valve.SteamAPIKey)
+// openServerQuerier creates a querier, going through the shared sockets when
+// they are enabled.
+func openServerQuerier(hostAndPort string, timeout time.Duration) (*valve.ServerQuerier, error) {
+	var query *valve.ServerQuerier
+	var err error
+	if udpMux != nil {
+		query, err = udpMux.NewServerQuerier(hostAndPort, timeout)
+	} else {
+		query, err = valve.NewServerQuerier(hostAndPort, timeout)
+	}
 	if err != nil {
-		log.Printf("ERROR: Failed to create Steam Web API querier: %s", err.Error())
+		return nil, err
+	}
+	query.SetRetryPolicy(queryRetry)
+	return query, nil
+}
+
+// observeQuery feeds a finished query's stats to the concurrency limiter.
+// Servers that never answered say nothing about the network (they're usually
+// just down), so only answered queries count.
+func observeQuery(limiter *batch.AIMD, query *valve.ServerQuerier) {
+	stats := query.Stats()
+	if limiter == nil || stats.Replies == 0 {
 		return
 	}
-	master = m
+	limiter.Observe(stats.AverageRtt(), stats.Retransmits > 0)
 }
 
-func newServerQuerier() error {
-	flagTimeout := time.Second * 3
-	flagJ := 20
-	sNumServers = 0
+// loadQueryConfig sets up the A2S query pipeline from the environment.
+func loadQueryConfig() {
+	queryWorkers = envInt("A2S_WORKERS", queryWorkers)
+	if queryWorkers < 1 {
+		queryWorkers = 1
+	}
+	queryMaxWorkers = envInt("A2S_MAX_WORKERS", queryMaxWorkers)
+	if queryMaxWorkers < queryWorkers {
+		queryMaxWorkers = queryWorkers
+	}
+	queryLatencyTarget = envDuration("A2S_LATENCY_TARGET", queryLatencyTarget)
+	queryRetry.Retries = envInt("A2S_RETRIES", queryRetry.Retries)
+	queryRetry.InitialTimeout = envDuration("A2S_RETRY_TIMEOUT", queryRetry.InitialTimeout)
+
+	// Source servers' sv_max_queries_sec defaults to 3.
+	if qps := envInt("A2S_RATE_LIMIT", 3); qps > 0 {
+		burst := envInt("A2S_RATE_BURST", 2*qps)
+		valve.DefaultRateLimiter = valve.NewRateLimiter(float64(qps), burst)
+		log.Printf("✓ A2S packets limited to %d/s per server IP (burst %d)", qps, burst)
+	}
 
-	sOutputBuffer.Reset()
+	if inflight := envInt("A2S_MAX_INFLIGHT", 256); inflight > 0 {
+		queryPool = batch.NewPool(inflight)
+		log.Printf("✓ At most %d A2S queries in flight", inflight)
+	}
 
-	bp := batch.NewBatchProcessor(func(item interface{}) {
-		addr := item.(*net.TCPAddr)
-		query, err := valve.NewServerQuerier(addr.String(), flagTimeout)
-		if err != nil {
-			addError(addr.String(), err)
-			return
-		}
-		defer query.Close()
+	sockets := envInt("A2S_SOCKETS", 4)
+	if sockets <= 0 {
+		log.Printf("✓ A2S queries use one socket per server")
+		return
+	}
 
-		info, err := query.QueryInfo()
-		if err != nil {
-			addError(addr.String(), err)
-			return
-		}
+	mux, err := valve.NewUdpMux(sockets)
+	if err != nil {
+		log.Printf("⚠️  Failed to open shared A2S sockets, using one socket per server: %s", err.Error())
+		return
+	}
+	udpMux = mux
+	log.Printf("✓ A2S queries share %d socket(s), %d-%d worker(s) per request", sockets, queryWorkers, queryMaxWorkers)
+}
 
-		log.Printf("%s - %s\n", addr.String(), info.Name)
-
-		out := &ServerObject{
-			Address:    addr.String(),
-			Protocol:   info.Protocol,
-			Name:       info.Name,
-			MapName:    info.MapName,
-			Folder:     info.Folder,
-			Game:       info.Game,
-			Players:    info.Players,
-			MaxPlayers: info.MaxPlayers,
-			Bots:       info.Bots,
-			Type:       info.Type.String(),
-			Os:         info.OS.String(),
-		}
-		if info.Vac == 1 {
-			out.Vac = true
-		}
-		if info.Visibility == 0 {
-			out.Visibility = "public"
-		} else {
-			out.Visibility = "private"
-		}
-		if info.Ext != nil {
-			out.AppID = info.Ext.AppId
-			out.GameVersion = info.Ext.GameVersion
-			out.Port = info.Ext.Port
-			out.SteamID = fmt.Sprintf("%d", info.Ext.SteamId)
-			out.GameMode = info.Ext.GameModeDescription
-			out.GameID = fmt.Sprintf("%d", info.Ext.GameId)
-		}
+// newServerObject converts an A2S_INFO reply.
+func newServerObject(hostAndPort string, info *valve.ServerInfo) *ServerObject {
+	out := &ServerObject{
+		Address:    hostAndPort,
+		Protocol:   info.Protocol,
+		Name:       info.Name,
+		MapName:    info.MapName,
+		Folder:     info.Folder,
+		Game:       info.Game,
+		Players:    info.Players,
+		MaxPlayers: info.MaxPlayers,
+		Bots:       info.Bots,
+		Type:       info.Type.String(),
+		Os:         info.OS.String(),
+	}
+	if info.Vac == 1 {
+		out.Vac = true
+	}
+	if info.Visibility == 0 {
+		out.Visibility = "public"
+	} else {
+		out.Visibility = "private"
+	}
+	if info.Ext != nil {
+		out.AppID = info.Ext.AppId
+		out.GameVersion = info.Ext.GameVersion
+		out.Port = info.Ext.Port
+		out.SteamID = fmt.Sprintf("%d", info.Ext.SteamId)
+		out.GameMode = info.Ext.GameModeDescription
+		out.GameID = fmt.Sprintf("%d", info.Ext.GameId)
+	}
+	return out
+}
 
-		if info.Players > 0 {
-			players, err := query.QueryPlayers()
-			if err != nil {
-				out.PlayersOnline = nil
-			} else {
-				out.PlayersOnline = players
-			}
-		}
+// queryServer asks one server for its info, and its player list if anyone is
+// playing.
+func queryServer(addr *net.TCPAddr, limiter *batch.AIMD, opts queryOptions) (*ServerObject, error) {
+	query := &serverQuery{
+		addr:    addr.String(),
+		opts:    opts,
+		limiter: limiter,
+	}
+	defer query.close()
 
-		addJSON(addr.String(), out)
-	}, flagJ)
+	info, err := query.info()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Match != nil && !opts.Match(addr, info) {
+		return nil, errFilteredOut
+	}
 
-	defer bp.Terminate()
+	log.Printf("%s - %s\n", addr.String(), info.Name)
 
-	// TOP OF JSON FILE
-	sOutputBuffer.WriteString("{\n")
-	sOutputBuffer.WriteString("\t\"data\" : [{")
+	out := newServerObject(addr.String(), info)
+	if info.Players > 0 {
+		players, err := query.players(info)
+		if err != nil {
+			out.PlayersOnline = nil
+		} else {
+			out.PlayersOnline = players
+		}
+	}
 
-	// Query the master.
-	err := master.Query(func(servers valve.ServerList) error {
-		bp.AddBatch(servers)
-		return nil
-	})
+	if !query.cachedAt.IsZero() {
+		out.CachedAt = &query.cachedAt
+	}
+	return out, nil
+}
 
-	if err != nil {
-		log.Printf("Failed to query server list: %s\n", err.Error())
-		return err
+// queryServers fetches the server list from the master and queries every
+// server on it. Results are in the order the master listed the servers.
+// Servers listed more than once by merged sources are merged too.
+//
+// Requests share the query pool. Interactive requests are served ahead of
+// bulk ones, and requests of the same priority take turns.
+func queryServers(master valve.MasterQuerier, opts queryOptions) ([]batch.Result[*net.TCPAddr, *ServerObject], error) {
+	bp, errc := startServerQueries(master, opts, true)
+	if err := <-errc; err != nil {
+		return nil, err
 	}
 
 	// Wait for batch processing to complete.
-	bp.Finish()
+	return mergeResults(master, dropFiltered(bp.Collect())), nil
+}
+
+// startServerQueries fetches the server list in the background and queries
+// each server as soon as the master lists it. Once the list is complete, the
+// processor is closed and the lookup's outcome is sent on the channel. If the
+// lookup fails, the processor is terminated. Servers that don't match the
+// filters of a source that can't apply them are answered with errFilteredOut.
+func startServerQueries(master valve.MasterQuerier, opts queryOptions, ordered bool) (*batch.Processor[*net.TCPAddr, *ServerObject], <-chan error) {
+	bp := newServerProcessor(withInfoFilter(master, opts), ordered)
+	errc := listServers(bp, func() error {
+		return master.Query(func(servers valve.ServerList) error {
+			bp.Add(servers...)
+			return nil
+		})
+	})
+	return bp, errc
+}
 
-	sOutputBuffer.WriteString("}],\n")
-	sOutputBuffer.WriteString(fmt.Sprintf("\t\"total\":%d\n", sNumServers))
-	sOutputBuffer.WriteString("}\n")
-	//BOTTOM OF JSON FILE
+// onQueryPool runs one query on the shared pool and waits for it, so that
+// lookups outside a search count against the in-flight cap and are scheduled
+// by their priority too.
+func onQueryPool[Out any](priority batch.Priority, query func() (Out, error)) (Out, error) {
+	bp := batch.NewProcessor(func(struct{}) (Out, error) {
+		return query()
+	}, batch.Options{
+		Pool:     queryPool,
+		Priority: priority,
+	})
+	bp.Add(struct{}{})
+	bp.Close()
+	result := bp.Collect()[0]
+	return result.Value, result.Err
+}
 
-	return nil
+// queryAddrs queries each of the given servers. Results are in the same
+// order.
+func queryAddrs(addrs []*net.TCPAddr, opts queryOptions) []batch.Result[*net.TCPAddr, *ServerObject] {
+	bp := newServerProcessor(opts, true)
+	bp.Add(addrs...)
+	bp.Close()
+	return bp.Collect()
 }
 
-func init() {
+// newServerProcessor creates a processor that queries servers through the
+// shared pool, with its own concurrency limit.
+func newServerProcessor(opts queryOptions, ordered bool) *batch.Processor[*net.TCPAddr, *ServerObject] {
+	limiter := batch.NewAIMD(queryWorkers, 1, queryMaxWorkers)
+	limiter.LatencyTarget = queryLatencyTarget
+
+	return batch.NewProcessor(func(addr *net.TCPAddr) (*ServerObject, error) {
+		return queryServer(addr, limiter, opts)
+	}, batch.Options{
+		Limiter:  limiter,
+		Ordered:  ordered,
+		Pool:     queryPool,
+		Priority: opts.Priority,
+	})
+}
+
+// listServers runs a master query that adds servers to the processor, in the
+// background. Once it's done, the processor is closed, or terminated if the
+// query failed, and the outcome is sent on the channel.
+func listServers[Out any](bp *batch.Processor[*net.TCPAddr, Out], query func() error) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		err := query()
+		if err != nil {
+			bp.Terminate()
+			log.Printf("Failed to query server list: %s\n", err.Error())
+		} else {
+			bp.Close()
+		}
+		errc <- err
+	}()
+	return errc
+}
+
+// loadSteamAPIKey reads the Steam API key from the environment and exits if it
+// is missing. A UDP master server, or our own, doesn't need one.
+func loadSteamAPIKey() {
 	// Read Steam API Key from environment variable
 	valve.SteamAPIKey = os.Getenv("STEAM_API_KEY")
 
+	if masterServer != "" || masterListen != "" {
+		return
+	}
+	if valve.SteamAPIKey == "" && !slices.Contains(defaultSources, kSourceMaster) {
+		log.Printf("⚠️  STEAM_API_KEY not set, the %s source is unavailable", kSourceMaster)
+		return
+	}
+
 	if valve.SteamAPIKey == "" {
 		log.Printf("⚠️  ERROR: STEAM_API_KEY environment variable not set")
 		log.Printf("")
@@ -324,21 +583,94 @@ func init() {
 }
 
 func main() {
+	os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// handleQueryAPI registers a query endpoint. It's counted in the metrics and
+// requires the API token, if one is set.
+func handleQueryAPI(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, countHTTPRequests(pattern, requireAPIToken(handler)))
+}
+
+// newServeMux routes the HTTP API. It's a mux of its own rather than
+// http.DefaultServeMux, where importing expvar registers /debug/vars with no
+// token check.
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	handleQueryAPI(mux, "/search/", httpMasterSearch)
+	handleQueryAPI(mux, "/server/", httpServer)
+	handleQueryAPI(mux, "/players/search/", httpPlayerSearch)
+	handleQueryAPI(mux, "/graphql", httpGraphQL)
+	// Groups check their own tokens: changes need GROUPS_API_TOKEN.
+	mux.HandleFunc("/groups", countHTTPRequests("/groups", httpGroups))
+	mux.HandleFunc("/groups/", countHTTPRequests("/groups/", httpGroups))
+	// Browsers can't send the token in a header here; see liveAuthorization.
+	mux.HandleFunc("/ws/server/", countHTTPRequests("/ws/server/", requireAPITokenIn(liveAuthorization, httpLiveServer)))
+	if rconConfig.Enabled {
+		mux.HandleFunc("/rcon/", countHTTPRequests("/rcon/", httpRcon))
+	}
+	mux.HandleFunc("/openapi.json", httpOpenAPI)
+	// The metrics include the command line, so they need the API token.
+	mux.HandleFunc("/debug/vars", requireAPIToken(expvar.Handler().ServeHTTP))
+	return mux
+}
+
+// runServe runs the HTTP server. It only returns on failure.
+func runServe(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: Mastersteam serve\n\nRun the HTTP API. Configuration is read from the environment.\n")
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		flags.Usage()
+		return exitUsage
+	}
+
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	loadMasterConfig()
+	loadSourcesConfig()
+	loadSteamAPIKey()
+	startLocalMaster()
+
 	log.Printf("🚀 Mastersteam service starting")
 	log.Printf("   Version: %s", GitTag)
 	log.Printf("   Commit: %s", GitCommit)
 	log.Printf("   Build Time: %s", BuildTime)
-	log.Printf("   Query mode: Steam Web API")
+	log.Printf("   Query mode: %s", masterMode())
 	log.Printf("   Listening on port: 8080")
 	log.Printf("")
+
+	loadQueryConfig()
+	loadCacheConfig()
+	publishMetrics()
+	loadAuthConfig()
+	loadLiveConfig()
+	loadGroupsConfig()
+	loadRconConfig()
+
 	log.Printf("API Endpoints:")
 	log.Printf("   GET /search/[APP_ID]/[NAME]")
 	log.Printf("   GET /server/[IP]")
+	log.Printf("   GET /players/search/[APP_ID]?name=[NAME]")
+	log.Printf("   GET /groups, GET, PUT, DELETE /groups/[NAME]")
+	log.Printf("   GET, POST /graphql")
+	log.Printf("   GET /ws/server/[IP:PORT] (WebSocket)")
+	log.Printf("   GET /openapi.json")
+	log.Printf("   GET /debug/vars")
+	if rconConfig.Enabled {
+		log.Printf("   POST /rcon/[IP:PORT]")
+	}
+	if grpcPort := envInt("GRPC_PORT", 9090); grpcPort > 0 {
+		log.Printf("   gRPC mastersteam.v1.Mastersteam on port %d", grpcPort)
+		go serveGRPC(grpcPort)
+	}
 	log.Printf("")
 
-	http.HandleFunc("/search/", httpMasterSearch)
-	http.HandleFunc("/server/", httpServer)
-	log.Fatal(http.ListenAndServe(":8080", Log(http.DefaultServeMux)))
+	log.Print(http.ListenAndServe(":8080", Log(newServeMux())))
+	return exitFailure
 }
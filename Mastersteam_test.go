@@ -0,0 +1,431 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+	"github.com/cyxc1124/Mastersteam/valve/mastertest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+type searchResponse struct {
+	Data  []map[string]json.RawMessage `json:"data"`
+	Total int                          `json:"total"`
+}
+
+func newTestWebAPI(t *testing.T, entries ...webapitest.Entry) *webapitest.Server {
+	api := webapitest.NewServer(entries...)
+	t.Cleanup(api.Close)
+
+	oldURL, oldKey := valve.SteamWebAPIURL, valve.SteamAPIKey
+	valve.SteamWebAPIURL = api.ServerListURL()
+	valve.SteamAPIKey = "test-key"
+	t.Cleanup(func() {
+		valve.SteamWebAPIURL = oldURL
+		valve.SteamAPIKey = oldKey
+	})
+	return api
+}
+
+func newTestGameServer(t *testing.T, info *valve.ServerInfo, players []*valve.Player) *a2stest.Server {
+	server := a2stest.NewUnstartedServer(info)
+	server.SetPlayers(players)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doRequest(t *testing.T, handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+func decodeSearch(t *testing.T, recorder *httptest.ResponseRecorder) *searchResponse {
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var response searchResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, recorder.Body.String())
+	}
+	if len(response.Data) != 1 {
+		t.Fatalf("got %d data objects, want 1", len(response.Data))
+	}
+	return &response
+}
+
+func decodeServer(t *testing.T, response *searchResponse, addr string) *ServerObject {
+	raw, ok := response.Data[0][addr]
+	if !ok {
+		t.Fatalf("no entry for %s", addr)
+	}
+	var server ServerObject
+	if err := json.Unmarshal(raw, &server); err != nil {
+		t.Fatal(err)
+	}
+	return &server
+}
+
+func TestSearchEndToEnd(t *testing.T) {
+	players := []*valve.Player{
+		{Name: "alice", Score: 10, Duration: 60},
+		{Name: "bob", Score: 3, Duration: 30},
+	}
+	sourceInfo := a2stest.SourceInfo()
+	sourceInfo.Players = 2
+	source := newTestGameServer(t, sourceInfo, players)
+	goldsrc := newTestGameServer(t, a2stest.GoldSrcInfo(), nil)
+
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: source.Addr(), Name: "Uncletopia | Seattle", Appid: 440},
+		webapitest.Entry{Addr: goldsrc.Addr(), Name: "Uncletopia | Chicago", Appid: 440},
+		webapitest.Entry{Addr: "127.0.0.1:1", Name: "Somebody else", Appid: 440},
+	)
+
+	response := decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/Uncletopia*"))
+	if response.Total != 2 {
+		t.Fatalf("got total %d, want 2", response.Total)
+	}
+
+	server := decodeServer(t, response, source.Addr())
+	if server.Name != sourceInfo.Name || server.MapName != sourceInfo.MapName {
+		t.Errorf("got %+v", server)
+	}
+	if server.AppID != valve.App_TF2 || !server.Vac || server.Os != "linux" || server.Type != "dedicated" {
+		t.Errorf("got %+v", server)
+	}
+	if len(server.PlayersOnline) != 2 || server.PlayersOnline[0].Name != "alice" {
+		t.Errorf("got players %+v", server.PlayersOnline)
+	}
+
+	server = decodeServer(t, response, goldsrc.Addr())
+	if server.Name != "a2stest GoldSrc server" || server.Folder != "cstrike" {
+		t.Errorf("got %+v", server)
+	}
+}
+
+func TestSearchUdpMaster(t *testing.T) {
+	source := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	master := mastertest.NewServer(
+		webapitest.Entry{Addr: source.Addr(), Name: "Uncletopia | Seattle", Appid: 440},
+		webapitest.Entry{Addr: "10.0.0.1:27015", Name: "Somebody else", Appid: 440},
+	)
+	defer master.Close()
+
+	masterServer = master.Addr()
+	defer func() { masterServer = "" }()
+
+	response := decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/Uncletopia*"))
+	if response.Total != 1 {
+		t.Fatalf("got total %d, want 1", response.Total)
+	}
+	if server := decodeServer(t, response, source.Addr()); server.Name != a2stest.SourceInfo().Name {
+		t.Errorf("got %+v", server)
+	}
+
+	filters := master.Requests()[0].Filters
+	want := []webapitest.Filter{{Key: "appid", Value: "440"}, {Key: "name_match", Value: "Uncletopia*"}}
+	if len(filters) != 2 || filters[0] != want[0] || filters[1] != want[1] {
+		t.Errorf("got filters %+v", filters)
+	}
+}
+
+func TestSearchLocalMaster(t *testing.T) {
+	source := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	master, err := valve.ListenMasterServer("127.0.0.1:0", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	master.Register(&valve.RegisteredServer{Addr: netip.MustParseAddrPort(source.Addr()), AppId: valve.App_TF2})
+	master.Register(&valve.RegisteredServer{Addr: netip.MustParseAddrPort("10.0.0.1:27015"), AppId: valve.App_CSGO})
+
+	localMaster = master
+	defer func() { localMaster = nil }()
+
+	response := decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/*"))
+	if response.Total != 1 {
+		t.Fatalf("got total %d, want 1", response.Total)
+	}
+	decodeServer(t, response, source.Addr())
+}
+
+func TestServerEndToEnd(t *testing.T) {
+	source := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	other := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	api := newTestWebAPI(t,
+		webapitest.Entry{Addr: source.Addr(), Appid: 440},
+		webapitest.Entry{Addr: other.Addr(), Appid: 440},
+	)
+
+	response := decodeSearch(t, doRequest(t, httpServer, "/server/"+source.Addr()))
+	if response.Total != 1 {
+		t.Fatalf("got total %d, want 1", response.Total)
+	}
+	decodeServer(t, response, source.Addr())
+
+	filters := api.Requests()[0].Filters
+	if len(filters) != 1 || filters[0].Key != "gameaddr" || filters[0].Value != source.Addr() {
+		t.Fatalf("got filters %v", filters)
+	}
+}
+
+func TestSearchUnreachableServer(t *testing.T) {
+	// Grab a free port and release it, so nothing answers there.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	newTestWebAPI(t, webapitest.Entry{Addr: addr, Appid: 440})
+
+	response := decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/*"))
+	var errorObject ErrorObject
+	if err := json.Unmarshal(response.Data[0][addr], &errorObject); err != nil {
+		t.Fatal(err)
+	}
+	if errorObject.IP != addr || errorObject.Error == "" {
+		t.Fatalf("got %+v", errorObject)
+	}
+}
+
+func TestSearchWebAPIErrors(t *testing.T) {
+	tests := []struct {
+		failure webapitest.Failure
+		status  int
+	}{
+		{webapitest.FailUnauthorized, http.StatusUnauthorized},
+		{webapitest.FailForbidden, http.StatusUnauthorized},
+		{webapitest.FailRateLimited, http.StatusInternalServerError},
+		{webapitest.FailServiceUnavailable, http.StatusInternalServerError},
+		{webapitest.FailMalformedJSON, http.StatusInternalServerError},
+	}
+
+	api := newTestWebAPI(t)
+	for _, test := range tests {
+		api.Fail(test.failure)
+
+		recorder := doRequest(t, httpMasterSearch, "/search/440/*")
+		if recorder.Code != test.status {
+			t.Errorf("failure %d: got status %d, want %d", test.failure, recorder.Code, test.status)
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Errorf("failure %d: invalid JSON: %v", test.failure, err)
+		} else if body["error"] == "" || body["status"] != float64(test.status) {
+			t.Errorf("failure %d: got %v", test.failure, body)
+		}
+	}
+}
+
+func TestServerCache(t *testing.T) {
+	replyCache = valve.NewA2SCache(valve.CacheConfig{
+		InfoTTL:    time.Minute,
+		PlayersTTL: time.Minute,
+	})
+	defer func() { replyCache = nil }()
+
+	info := a2stest.SourceInfo()
+	info.Players = 1
+	source := newTestGameServer(t, info, []*valve.Player{{Name: "alice"}})
+	newTestWebAPI(t, webapitest.Entry{Addr: source.Addr(), Appid: 440})
+
+	server := decodeServer(t, decodeSearch(t, doRequest(t, httpServer, "/server/"+source.Addr())), source.Addr())
+	if server.CachedAt != nil {
+		t.Fatalf("first query should not be cached, got %v", server.CachedAt)
+	}
+	queries := source.Queries()
+
+	server = decodeServer(t, decodeSearch(t, doRequest(t, httpServer, "/server/"+source.Addr())), source.Addr())
+	if server.CachedAt == nil || len(server.PlayersOnline) != 1 {
+		t.Fatalf("got %+v", server)
+	}
+	if source.Queries() != queries {
+		t.Fatalf("server was queried again: %d queries, want %d", source.Queries(), queries)
+	}
+
+	server = decodeServer(t, decodeSearch(t, doRequest(t, httpServer, "/server/"+source.Addr()+"?fresh=1")), source.Addr())
+	if server.CachedAt != nil || source.Queries() == queries {
+		t.Fatalf("fresh=1 should bypass the cache, got %+v", server)
+	}
+}
+
+func TestSearchExportFormats(t *testing.T) {
+	info := a2stest.SourceInfo()
+	info.Players = 2
+	source := newTestGameServer(t, info, []*valve.Player{
+		{Name: "alice", Score: 10, Duration: 60},
+		{Name: "bob | the builder", Score: 3, Duration: 30},
+	})
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: source.Addr(), Appid: 440},
+		webapitest.Entry{Addr: "127.0.0.1:1", Appid: 440},
+	)
+
+	request := func(target, accept string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		httpMasterSearch(recorder, r)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", target, recorder.Code, recorder.Body.String())
+		}
+		return recorder
+	}
+
+	recorder := request("/search/440/*", "text/csv")
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Fatalf("got Content-Type %q", got)
+	}
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("got %v, %v", rows, err)
+	}
+	for i, col := range serverColumns {
+		if rows[0][i] != col.name {
+			t.Fatalf("column %d: got %q, want %q", i, rows[0][i], col.name)
+		}
+	}
+	byAddr := map[string][]string{}
+	for _, row := range rows[1:] {
+		byAddr[row[0]] = row
+	}
+	if row := byAddr[source.Addr()]; row == nil || row[2] != info.Name || row[len(row)-2] != "" {
+		t.Fatalf("got %v", row)
+	}
+	if row := byAddr["127.0.0.1:1"]; row == nil || row[2] != "" || row[len(row)-2] == "" {
+		t.Fatalf("got %v", row)
+	}
+
+	// The query parameter wins over Accept.
+	recorder = request("/search/440/*?format=tsv&sheet=players", "text/csv")
+	reader := csv.NewReader(recorder.Body)
+	reader.Comma = '\t'
+	rows, err = reader.ReadAll()
+	if err != nil || len(rows) != 3 || rows[0][0] != "ip" || rows[1][1] != "alice" || rows[1][2] != "10" {
+		t.Fatalf("got %v, %v", rows, err)
+	}
+
+	recorder = request("/search/440/*?format=md&sheet=players", "")
+	if got := recorder.Body.String(); !strings.Contains(got, `| bob \| the builder | 3 | 30 |`) {
+		t.Fatalf("got\n%s", got)
+	}
+
+	// JSON stays the default.
+	recorder = request("/search/440/*", "text/html, */*")
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Fatalf("got Content-Type %q", got)
+	}
+
+	for target, want := range map[string]string{
+		"/search/440/*?format=xls":             `Invalid export: format \"xls\" isn't json, csv, tsv or md`,
+		"/search/440/*?format=csv&sheet=rules": `Invalid export: sheet \"rules\" isn't servers or players`,
+	} {
+		recorder := doRequest(t, httpMasterSearch, target)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", target, recorder.Code)
+		}
+		if body := recorder.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("%s: got %s", target, body)
+		}
+	}
+}
+
+func TestSearchExportLogsFailuresOnce(t *testing.T) {
+	newTestWebAPI(t, webapitest.Entry{Addr: "127.0.0.1:1", Appid: 440})
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, query := range []string{"format=json", "format=csv", "format=csv&sheet=players"} {
+		logged.Reset()
+		doRequest(t, httpMasterSearch, "/search/440/*?"+query)
+		if n := strings.Count(logged.String(), "Server query error [127.0.0.1:1]"); n != 1 {
+			t.Errorf("%s: logged %d times:\n%s", query, n, logged.String())
+		}
+	}
+}
+
+func TestRequestFormatAccept(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", formatJSON},
+		{"text/csv", formatCSV},
+		{"text/csv;q=0.5, text/markdown", formatMarkdown},
+		{"text/tab-separated-values, text/csv", formatTSV},
+		{"text/csv;q=0, application/json;q=0.1", formatJSON},
+		{"image/png", formatJSON},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/search/440/*", nil)
+		r.Header.Set("Accept", test.accept)
+		got, err := requestFormat(r)
+		if err != nil || got.format != test.want {
+			t.Errorf("Accept %q: got %q, %v, want %q", test.accept, got.format, err, test.want)
+		}
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	apiToken = "secret"
+	defer func() { apiToken = "" }()
+
+	handler := requireAPIToken(func(w http.ResponseWriter, r *http.Request) {})
+	for header, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/search/440/*", nil)
+		r.Header.Set("Authorization", header)
+		handler(recorder, r)
+		if recorder.Code != want {
+			t.Errorf("Authorization %q: got status %d, want %d", header, recorder.Code, want)
+		}
+	}
+}
+
+func TestDebugVarsRequireToken(t *testing.T) {
+	apiToken = "secret"
+	defer func() { apiToken = "" }()
+
+	mux := newServeMux()
+	for header, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		r.Header.Set("Authorization", header)
+		mux.ServeHTTP(recorder, r)
+		if recorder.Code != want {
+			t.Errorf("Authorization %q: got status %d, want %d", header, recorder.Code, want)
+		}
+		if want == http.StatusOK && !strings.Contains(recorder.Body.String(), `"memstats"`) {
+			t.Errorf("got %s", recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Bearer token required by the query APIs, HTTP and gRPC alike. If empty,
+// they are open. /rcon has its own token.
+var apiToken string
+
+func loadAuthConfig() {
+	apiToken = envString("API_TOKEN", "")
+	if apiToken != "" {
+		log.Printf("✓ Query APIs require a bearer token")
+	}
+}
+
+// bearerTokenMatches checks an Authorization header value against a token.
+func bearerTokenMatches(header string, token string) bool {
+	presented, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+// isAPIAuthorized checks the Authorization header of a query API request.
+func isAPIAuthorized(header string) bool {
+	return apiToken == "" || bearerTokenMatches(header, apiToken)
+}
+
+// requireAPIToken rejects HTTP requests without the API token, if one is set.
+func requireAPIToken(handler http.HandlerFunc) http.HandlerFunc {
+	return requireAPITokenIn(func(r *http.Request) string {
+		return r.Header.Get("Authorization")
+	}, handler)
+}
+
+// requireAPITokenIn is requireAPIToken for routes that take the token from
+// more than the Authorization header. |authorization| returns the request's
+// Authorization value, wherever it came from.
+func requireAPITokenIn(authorization func(*http.Request) string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAPIAuthorized(authorization(r)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		handler(w, r)
+	}
+}
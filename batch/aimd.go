@@ -0,0 +1,98 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package batch
+
+import (
+	"sync"
+	"time"
+)
+
+// An AIMD limiter adjusts how many tasks may run at once, the way TCP adjusts
+// its congestion window: the limit grows by one task for every window of
+// successful tasks, and is cut by a constant factor when a task reports loss
+// or runs slower than the latency target.
+//
+// Results are reported through Observe, which may be called from any
+// goroutine.
+type AIMD struct {
+	// Bounds on the limit.
+	Min int
+	Max int
+
+	// Factor the limit is multiplied by on loss, between 0 and 1.
+	Backoff float64
+
+	// Tasks slower than this count as loss. Zero disables the check.
+	LatencyTarget time.Duration
+
+	mu    sync.Mutex
+	limit float64
+
+	// Observations to ignore after a decrease. Tasks that were already
+	// running when we backed off saw the same congestion, and shouldn't cut
+	// the limit again.
+	holdoff int
+}
+
+// Create a limiter starting at |initial| tasks, bounded by |min| and |max|.
+func NewAIMD(initial, min, max int) *AIMD {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &AIMD{
+		Min:     min,
+		Max:     max,
+		Backoff: 0.5,
+		limit:   float64(clamp(initial, min, max)),
+	}
+}
+
+// The number of tasks that may currently run at once.
+func (a *AIMD) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return clamp(int(a.limit), a.Min, a.Max)
+}
+
+// Record the outcome of a task: how long it took and whether it saw loss.
+func (a *AIMD) Observe(latency time.Duration, lost bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.LatencyTarget > 0 && latency > a.LatencyTarget {
+		lost = true
+	}
+
+	if a.holdoff > 0 {
+		a.holdoff--
+		if lost {
+			return
+		}
+	}
+
+	if lost {
+		a.limit *= a.Backoff
+		if a.limit < float64(a.Min) {
+			a.limit = float64(a.Min)
+		}
+		a.holdoff = int(a.limit / a.Backoff)
+		return
+	}
+
+	a.limit += 1 / a.limit
+	if a.limit > float64(a.Max) {
+		a.limit = float64(a.Max)
+	}
+}
+
+func clamp(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
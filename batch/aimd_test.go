@@ -0,0 +1,96 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package batch
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAIMDIncreaseAndBackoff(t *testing.T) {
+	limiter := NewAIMD(4, 2, 8)
+
+	// About one window of successes adds one task.
+	for i := 0; i < 5; i++ {
+		limiter.Observe(time.Millisecond, false)
+	}
+	if got := limiter.Limit(); got != 5 {
+		t.Fatalf("got limit %d, want 5", got)
+	}
+
+	limiter.Observe(time.Millisecond, true)
+	if got := limiter.Limit(); got != 2 {
+		t.Fatalf("got limit %d after loss, want 2", got)
+	}
+
+	// Losses reported by tasks that started before the backoff don't cut the
+	// limit again, and it never drops below the minimum.
+	limiter.Observe(time.Millisecond, true)
+	limiter.Observe(time.Millisecond, true)
+	if got := limiter.Limit(); got != 2 {
+		t.Fatalf("got limit %d, want 2", got)
+	}
+
+	for i := 0; i < 1000; i++ {
+		limiter.Observe(time.Millisecond, false)
+	}
+	if got := limiter.Limit(); got != 8 {
+		t.Fatalf("got limit %d, want the maximum of 8", got)
+	}
+}
+
+func TestAIMDLatencyTarget(t *testing.T) {
+	limiter := NewAIMD(10, 1, 10)
+	limiter.LatencyTarget = 100 * time.Millisecond
+
+	limiter.Observe(50*time.Millisecond, false)
+	if got := limiter.Limit(); got != 10 {
+		t.Fatalf("got limit %d, want 10", got)
+	}
+	limiter.Observe(time.Second, false)
+	if got := limiter.Limit(); got != 5 {
+		t.Fatalf("got limit %d after a slow task, want 5", got)
+	}
+}
+
+type intBatch []int
+
+func (b intBatch) Item(index int) interface{} { return b[index] }
+func (b intBatch) Len() int                   { return len(b) }
+
+func TestAdaptiveBatchProcessorRespectsLimit(t *testing.T) {
+	limiter := NewAIMD(3, 1, 3)
+
+	var running, peak, processed int32
+	var mu sync.Mutex
+	bp := NewAdaptiveBatchProcessor(func(item interface{}) {
+		n := atomic.AddInt32(&running, 1)
+		mu.Lock()
+		if n > peak {
+			peak = n
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&processed, 1)
+
+		// Report loss on every item, driving the limit to the minimum.
+		limiter.Observe(time.Millisecond, true)
+	}, limiter)
+
+	items := make(intBatch, 30)
+	bp.AddBatch(items)
+	bp.Finish()
+
+	if processed != 30 {
+		t.Fatalf("processed %d items, want 30", processed)
+	}
+	if peak > 3 {
+		t.Fatalf("%d tasks ran at once, limit is 3", peak)
+	}
+	if got := limiter.Limit(); got != 1 {
+		t.Fatalf("got limit %d, want 1", got)
+	}
+}
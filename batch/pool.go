@@ -0,0 +1,165 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package batch
+
+import "sync"
+
+// Priority classes for work submitted to a Pool. A worker always takes work
+// from the highest class that has any.
+type Priority int
+
+const (
+	// Large scans where latency doesn't matter much.
+	PriorityBulk Priority = iota
+
+	// Small lookups that someone is waiting on.
+	PriorityInteractive
+
+	numPriorities
+)
+
+// A Pool is a fixed set of workers shared by many processors. Its size caps
+// how many tasks run at once across all of them.
+//
+// Each processor gets its own queue. Within a priority class, queues are
+// served in proportion to their weight (stride scheduling), so equal weights
+// give round-robin and a large batch can't starve a small one that arrives
+// later. Higher classes are always served first.
+type Pool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	classes [numPriorities]poolClass
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+type poolClass struct {
+	// Queues with pending work.
+	active []*poolQueue
+
+	// Pass of the most recently served queue. Queues that become active
+	// start here, so they don't get a burst of credit for time spent idle.
+	pass float64
+}
+
+// A poolQueue holds one processor's tasks.
+type poolQueue struct {
+	pool     *Pool
+	priority Priority
+	stride   float64
+	pass     float64
+	tasks    []func()
+	active   bool
+}
+
+// Create a pool with the given number of workers.
+func NewPool(workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	pool := &Pool{}
+	pool.cond = sync.NewCond(&pool.mu)
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go pool.work()
+	}
+	return pool
+}
+
+// Stop the workers once all queued tasks have run.
+func (pool *Pool) Close() {
+	pool.mu.Lock()
+	pool.closed = true
+	pool.cond.Broadcast()
+	pool.mu.Unlock()
+
+	pool.wg.Wait()
+}
+
+// Create a queue. Weights below 1 are treated as 1.
+func (pool *Pool) newQueue(priority Priority, weight int) *poolQueue {
+	if priority < 0 || priority >= numPriorities {
+		priority = PriorityBulk
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	return &poolQueue{
+		pool:     pool,
+		priority: priority,
+		stride:   1 / float64(weight),
+	}
+}
+
+// Queue a task to run on one of the pool's workers. Tasks submitted after
+// Close run on their own goroutine.
+func (q *poolQueue) submit(task func()) {
+	pool := q.pool
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		go task()
+		return
+	}
+
+	q.tasks = append(q.tasks, task)
+	if !q.active {
+		class := &pool.classes[q.priority]
+		if q.pass < class.pass {
+			q.pass = class.pass
+		}
+		q.active = true
+		class.active = append(class.active, q)
+	}
+	pool.cond.Signal()
+	pool.mu.Unlock()
+}
+
+// Pick the next task. The caller holds mu.
+func (pool *Pool) next() func() {
+	for priority := numPriorities - 1; priority >= 0; priority-- {
+		class := &pool.classes[priority]
+		if len(class.active) == 0 {
+			continue
+		}
+
+		best := 0
+		for i, q := range class.active {
+			if q.pass < class.active[best].pass {
+				best = i
+			}
+		}
+		q := class.active[best]
+
+		task := q.tasks[0]
+		q.tasks = q.tasks[1:]
+		class.pass = q.pass
+		q.pass += q.stride
+
+		if len(q.tasks) == 0 {
+			q.active = false
+			class.active = append(class.active[:best], class.active[best+1:]...)
+		}
+		return task
+	}
+	return nil
+}
+
+func (pool *Pool) work() {
+	defer pool.wg.Done()
+
+	for {
+		pool.mu.Lock()
+		task := pool.next()
+		for task == nil && !pool.closed {
+			pool.cond.Wait()
+			task = pool.next()
+		}
+		pool.mu.Unlock()
+
+		if task == nil {
+			return
+		}
+		task()
+	}
+}
@@ -0,0 +1,115 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package batch
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolCapsConcurrency(t *testing.T) {
+	pool := NewPool(3)
+	defer pool.Close()
+
+	var running, peak int32
+	var mu sync.Mutex
+	fn := func(n int) (int, error) {
+		now := atomic.AddInt32(&running, 1)
+		mu.Lock()
+		if now > peak {
+			peak = now
+		}
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return n, nil
+	}
+
+	// Each processor alone would run 10 at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := NewProcessor(fn, Options{MaxTasks: 10, Pool: pool})
+			p.Add(make([]int, 20)...)
+			if results := p.Collect(); len(results) != 20 {
+				t.Errorf("got %d results", len(results))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > 3 {
+		t.Fatalf("%d tasks ran at once on a pool of 3", peak)
+	}
+}
+
+// Runs tasks on a single worker and records which queue each came from. The
+// worker is held until every queue has been filled, so the order reflects
+// the scheduler alone.
+func scheduleOrder(t *testing.T, queues []Options, tasksPerQueue int) []int {
+	pool := NewPool(1)
+	defer pool.Close()
+
+	gate := make(chan struct{})
+	blocker := pool.newQueue(PriorityInteractive, 1)
+	blocker.submit(func() { <-gate })
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for id, opts := range queues {
+		q := pool.newQueue(opts.Priority, opts.Weight)
+		for i := 0; i < tasksPerQueue; i++ {
+			wg.Add(1)
+			id := id
+			q.submit(func() {
+				mu.Lock()
+				order = append(order, id)
+				mu.Unlock()
+				wg.Done()
+			})
+		}
+	}
+	close(gate)
+	wg.Wait()
+	return order
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	order := scheduleOrder(t, []Options{{}, {}, {}}, 3)
+	want := []int{0, 1, 2, 0, 1, 2, 0, 1, 2}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestPoolWeightedFair(t *testing.T) {
+	order := scheduleOrder(t, []Options{{Weight: 3}, {Weight: 1}}, 6)
+
+	// In the first 8 tasks, the heavy queue should get three times the share.
+	counts := [2]int{}
+	for _, id := range order[:8] {
+		counts[id]++
+	}
+	if counts[0] != 6 || counts[1] != 2 {
+		t.Fatalf("got order %v", order)
+	}
+}
+
+func TestPoolPriority(t *testing.T) {
+	order := scheduleOrder(t, []Options{
+		{Priority: PriorityBulk},
+		{Priority: PriorityInteractive},
+	}, 3)
+	want := []int{1, 1, 1, 0, 0, 0}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+}
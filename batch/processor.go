@@ -0,0 +1,411 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package batch
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// A PanicError is reported for an item whose function panicked. The panic is
+// contained to that item; the rest of the batch carries on.
+type PanicError struct {
+	// The value passed to panic.
+	Value interface{}
+
+	// Stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (pe *PanicError) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
+
+// Func processes a single item.
+type Func[In, Out any] func(item In) (Out, error)
+
+// The outcome of processing one item.
+type Result[In, Out any] struct {
+	// Position of the item in the order it was added, starting from 0.
+	Index int
+
+	Item  In
+	Value Out
+	Err   error
+}
+
+// A snapshot of a processor's counters, passed to progress callbacks.
+type Progress struct {
+	// Items added so far.
+	Total int
+
+	// Items processed so far, including failed ones.
+	Done int
+
+	// Items whose function returned an error or panicked.
+	Failed int
+
+	// Items that were never processed, because they were added after Close
+	// or were still queued when Terminate was called.
+	Dropped int
+}
+
+// The counters of every processor added up, for Totals.
+var totals struct {
+	total, done, failed, dropped atomic.Int64
+}
+
+// Totals returns the counters of every processor so far, finished ones
+// included.
+func Totals() Progress {
+	return Progress{
+		Total:   int(totals.total.Load()),
+		Done:    int(totals.done.Load()),
+		Failed:  int(totals.failed.Load()),
+		Dropped: int(totals.dropped.Load()),
+	}
+}
+
+// Options for a Processor.
+type Options struct {
+	// Maximum number of items processed at once. Ignored if Limiter is set.
+	MaxTasks int
+
+	// Adjusts the number of items processed at once. The function is
+	// expected to report each item's outcome to it.
+	Limiter *AIMD
+
+	// Deliver results in the order items were added, rather than as they
+	// complete. A slow item holds back the results behind it.
+	Ordered bool
+
+	// Called after each item is processed. Calls are serialized, and happen
+	// before the item's result is delivered.
+	OnProgress func(Progress)
+
+	// Run items on a shared pool instead of dedicated goroutines. MaxTasks
+	// or Limiter still bound how many of this processor's items are handed
+	// to the pool at once.
+	Pool *Pool
+
+	// Scheduling class and share of the pool, if Pool is set.
+	Priority Priority
+	Weight   int
+}
+
+// A Processor runs a function over a stream of items with bounded
+// concurrency, and delivers the results on a channel.
+//
+// Add items with Add or AddBatch, then call Close once there are no more.
+// The results channel is closed after the last result has been delivered.
+// Results are buffered internally, so the producer never blocks on a slow
+// consumer; a consumer that stops reading must call Terminate.
+type Processor[In, Out any] struct {
+	fn   Func[In, Out]
+	opts Options
+
+	results chan Result[In, Out]
+
+	mu         sync.Mutex
+	cond       *sync.Cond // Signaled when pending results or state change.
+	queue      []Result[In, Out]
+	progress   Progress
+	running    int
+	closed     bool
+	terminated bool
+
+	// Completed results waiting to be sent. When ordered, these are keyed by
+	// index and released in sequence from nextIndex.
+	pending   []Result[In, Out]
+	reorder   map[int]Result[In, Out]
+	nextIndex int
+
+	// Tasks that have started and not yet returned, and whether delivery has
+	// finished. Once both are done, idle is closed.
+	active    int
+	delivered bool
+	idle      chan struct{}
+
+	progressMu sync.Mutex
+	finished   chan struct{}
+	poolQueue  *poolQueue
+}
+
+// Create a processor and start its delivery goroutine.
+func NewProcessor[In, Out any](fn Func[In, Out], opts Options) *Processor[In, Out] {
+	if opts.MaxTasks < 1 {
+		opts.MaxTasks = 1
+	}
+
+	p := &Processor[In, Out]{
+		fn:       fn,
+		opts:     opts,
+		results:  make(chan Result[In, Out]),
+		reorder:  map[int]Result[In, Out]{},
+		idle:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	if opts.Pool != nil {
+		p.poolQueue = opts.Pool.newQueue(opts.Priority, opts.Weight)
+	}
+
+	go p.deliver()
+	return p
+}
+
+// The channel results are delivered on.
+func (p *Processor[In, Out]) Results() <-chan Result[In, Out] {
+	return p.results
+}
+
+// Queue items for processing. Items added after Close or Terminate are
+// dropped.
+func (p *Processor[In, Out]) Add(items ...In) {
+	p.mu.Lock()
+	if p.closed {
+		p.progress.Dropped += len(items)
+		totals.dropped.Add(int64(len(items)))
+		p.mu.Unlock()
+		return
+	}
+	for _, item := range items {
+		p.queue = append(p.queue, Result[In, Out]{
+			Index: p.progress.Total,
+			Item:  item,
+		})
+		p.progress.Total++
+	}
+	totals.total.Add(int64(len(items)))
+	p.startTasks()
+	p.mu.Unlock()
+}
+
+// Queue every item of a legacy batch. This panics if an item is not of type
+// In.
+func (p *Processor[In, Out]) AddBatch(batch Batch) {
+	for i := 0; i < batch.Len(); i++ {
+		p.Add(batch.Item(i).(In))
+	}
+}
+
+// Signal that no more items are coming. The results channel is closed once
+// every queued item has been processed and delivered.
+func (p *Processor[In, Out]) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// Drop all queued items and stop delivering results. Items already being
+// processed run to completion, but their results are discarded; use Wait to
+// know when they are done. The results channel is closed.
+func (p *Processor[In, Out]) Terminate() {
+	p.mu.Lock()
+	p.closed = true
+	p.terminated = true
+	p.progress.Dropped += len(p.queue)
+	totals.dropped.Add(int64(len(p.queue)))
+	p.queue = nil
+	p.pending = nil
+	p.reorder = map[int]Result[In, Out]{}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	// Unblock the delivery goroutine if it is waiting on a send.
+	for {
+		select {
+		case <-p.results:
+		case <-p.finished:
+			return
+		}
+	}
+}
+
+// Wait until every task has returned and every result has been delivered, or
+// until the context is done. This also covers tasks still running after
+// Terminate.
+func (p *Processor[In, Out]) Wait(ctx context.Context) error {
+	select {
+	case <-p.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A snapshot of the processor's counters.
+func (p *Processor[In, Out]) Progress() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.progress
+}
+
+// Close the processor and gather every result. Results are in delivery
+// order, which is index order if the processor is ordered.
+func (p *Processor[In, Out]) Collect() []Result[In, Out] {
+	p.Close()
+
+	var results []Result[In, Out]
+	for result := range p.results {
+		results = append(results, result)
+	}
+	return results
+}
+
+func (p *Processor[In, Out]) taskLimit() int {
+	if p.opts.Limiter != nil {
+		return p.opts.Limiter.Limit()
+	}
+	return p.opts.MaxTasks
+}
+
+// Start as many queued items as the limit allows. The caller holds mu.
+func (p *Processor[In, Out]) startTasks() {
+	for len(p.queue) > 0 && p.running < p.taskLimit() {
+		task := p.queue[0]
+		p.queue = p.queue[1:]
+		p.running++
+		p.active++
+		if p.poolQueue != nil {
+			p.poolQueue.submit(func() {
+				p.run(task)
+			})
+		} else {
+			go p.run(task)
+		}
+	}
+}
+
+// Call the function, turning a panic into a PanicError.
+func (p *Processor[In, Out]) call(item In) (value Out, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+	return p.fn(item)
+}
+
+func (p *Processor[In, Out]) run(task Result[In, Out]) {
+	defer func() {
+		p.mu.Lock()
+		p.active--
+		p.checkIdle()
+		p.mu.Unlock()
+	}()
+
+	// A task can sit in a pool queue for a while. Don't start it if nobody
+	// wants the result anymore.
+	p.mu.Lock()
+	if p.terminated {
+		p.running--
+		p.progress.Dropped++
+		totals.dropped.Add(1)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	task.Value, task.Err = p.call(task.Item)
+
+	p.mu.Lock()
+	p.running--
+	p.progress.Done++
+	totals.done.Add(1)
+	if task.Err != nil {
+		p.progress.Failed++
+		totals.failed.Add(1)
+	}
+	progress := p.progress
+	p.mu.Unlock()
+
+	if p.opts.OnProgress != nil {
+		p.progressMu.Lock()
+		p.opts.OnProgress(progress)
+		p.progressMu.Unlock()
+	}
+
+	p.mu.Lock()
+	if !p.terminated {
+		if p.opts.Ordered {
+			p.reorder[task.Index] = task
+			for {
+				next, ok := p.reorder[p.nextIndex]
+				if !ok {
+					break
+				}
+				delete(p.reorder, p.nextIndex)
+				p.pending = append(p.pending, next)
+				p.nextIndex++
+			}
+		} else {
+			p.pending = append(p.pending, task)
+		}
+	}
+	p.startTasks()
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// Whether every result has been handed to the delivery goroutine. The caller
+// holds mu.
+func (p *Processor[In, Out]) drained() bool {
+	if p.terminated {
+		return true
+	}
+	return p.closed && p.running == 0 && len(p.queue) == 0 && len(p.pending) == 0
+}
+
+// Close idle once delivery has finished and no task is running. No task can
+// start after delivery has finished. The caller holds mu.
+func (p *Processor[In, Out]) checkIdle() {
+	if p.delivered && p.active == 0 {
+		select {
+		case <-p.idle:
+		default:
+			close(p.idle)
+		}
+	}
+}
+
+// Move results from pending onto the results channel. This runs in its own
+// goroutine, so workers never block on the consumer.
+func (p *Processor[In, Out]) deliver() {
+	defer func() {
+		p.mu.Lock()
+		p.delivered = true
+		p.checkIdle()
+		p.mu.Unlock()
+	}()
+	defer close(p.finished)
+	defer close(p.results)
+
+	for {
+		p.mu.Lock()
+		for len(p.pending) == 0 && !p.drained() {
+			p.cond.Wait()
+		}
+		if p.terminated || len(p.pending) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		result := p.pending[0]
+		p.pending = p.pending[1:]
+		p.mu.Unlock()
+
+		p.results <- result
+	}
+}
@@ -0,0 +1,250 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package batch
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errOdd = errors.New("odd")
+
+// Sleeps longer for earlier items, so they complete in reverse order.
+func reverseSquare(n int) (int, error) {
+	time.Sleep(time.Duration(10-n) * 2 * time.Millisecond)
+	if n%2 == 1 {
+		return 0, errOdd
+	}
+	return n * n, nil
+}
+
+func TestProcessorOrdered(t *testing.T) {
+	p := NewProcessor(reverseSquare, Options{MaxTasks: 10, Ordered: true})
+	p.Add(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+
+	results := p.Collect()
+	if len(results) != 10 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, result := range results {
+		if result.Index != i || result.Item != i {
+			t.Fatalf("result %d is %+v", i, result)
+		}
+		if i%2 == 1 {
+			if result.Err != errOdd {
+				t.Fatalf("result %d: got error %v", i, result.Err)
+			}
+		} else if result.Err != nil || result.Value != i*i {
+			t.Fatalf("result %d is %+v", i, result)
+		}
+	}
+}
+
+func TestProcessorUnordered(t *testing.T) {
+	p := NewProcessor(reverseSquare, Options{MaxTasks: 10})
+	p.Add(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+
+	results := p.Collect()
+	if len(results) != 10 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if results[0].Item != 9 {
+		t.Fatalf("got item %d first, want the fastest item 9", results[0].Item)
+	}
+
+	seen := map[int]bool{}
+	for _, result := range results {
+		seen[result.Index] = true
+	}
+	if len(seen) != 10 {
+		t.Fatalf("got indexes %v", seen)
+	}
+}
+
+func TestProcessorProgress(t *testing.T) {
+	var calls []Progress
+	p := NewProcessor(reverseSquare, Options{
+		MaxTasks: 3,
+		OnProgress: func(progress Progress) {
+			calls = append(calls, progress)
+		},
+	})
+	p.Add(0, 1, 2, 3)
+	p.Add(4, 5)
+	p.Collect()
+
+	if len(calls) != 6 {
+		t.Fatalf("got %d progress calls", len(calls))
+	}
+	for i, progress := range calls {
+		if progress.Done != i+1 {
+			t.Fatalf("call %d: got %+v", i, progress)
+		}
+	}
+	if err := p.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	final := p.Progress()
+	if final.Total != 6 || final.Done != 6 || final.Failed != 3 {
+		t.Fatalf("got %+v", final)
+	}
+}
+
+func TestProcessorTerminate(t *testing.T) {
+	var started int32
+	release := make(chan struct{})
+	p := NewProcessor(func(n int) (int, error) {
+		atomic.AddInt32(&started, 1)
+		<-release
+		return n, nil
+	}, Options{MaxTasks: 2})
+
+	p.Add(1, 2, 3, 4, 5)
+	for atomic.LoadInt32(&started) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Terminate()
+	close(release)
+
+	if _, ok := <-p.Results(); ok {
+		t.Fatal("results channel should be closed after Terminate")
+	}
+	p.Add(6)
+	if err := p.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&started); got != 2 {
+		t.Fatalf("%d items started, want 2", got)
+	}
+	if progress := p.Progress(); progress.Done != 2 || progress.Dropped != 4 {
+		t.Fatalf("got %+v", progress)
+	}
+}
+
+func TestTotals(t *testing.T) {
+	before := Totals()
+	p := NewProcessor(reverseSquare, Options{})
+	p.Add(0, 1, 2)
+	p.Collect()
+	p.Add(3)
+
+	got := Totals()
+	want := Progress{
+		Total:   before.Total + 3,
+		Done:    before.Done + 3,
+		Failed:  before.Failed + 1,
+		Dropped: before.Dropped + 1,
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestProcessorWaitTimeout(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	p := NewProcessor(func(n int) (int, error) {
+		close(started)
+		<-release
+		return n, nil
+	}, Options{MaxTasks: 1})
+	p.Add(1)
+	<-started
+	p.Terminate()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want DeadlineExceeded while a task is running", err)
+	}
+
+	// Waits that give up don't leave anything behind.
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if err := p.Wait(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("got %v", err)
+		}
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Fatalf("%d goroutines after waiting, had %d", got, goroutines)
+	}
+
+	release <- struct{}{}
+	if err := p.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessorRecoversPanics(t *testing.T) {
+	p := NewProcessor(func(n int) (int, error) {
+		if n == 2 {
+			var m map[string]int
+			m["boom"] = n
+		}
+		return n, nil
+	}, Options{MaxTasks: 2, Ordered: true})
+	p.Add(1, 2, 3)
+
+	results := p.Collect()
+	if len(results) != 3 || results[0].Err != nil || results[2].Err != nil {
+		t.Fatalf("got %+v", results)
+	}
+
+	var pe *PanicError
+	if !errors.As(results[1].Err, &pe) {
+		t.Fatalf("got %v, want a *PanicError", results[1].Err)
+	}
+	if !strings.Contains(string(pe.Stack), "TestProcessorRecoversPanics") {
+		t.Fatalf("stack does not include the panicking function:\n%s", pe.Stack)
+	}
+	if progress := p.Progress(); progress.Failed != 1 || progress.Done != 3 {
+		t.Fatalf("got %+v", progress)
+	}
+}
+
+func TestBatchProcessorFailures(t *testing.T) {
+	var processed int32
+	bp := NewBatchProcessor(func(item interface{}) {
+		if item.(string) == "bad" {
+			panic("bad item")
+		}
+		atomic.AddInt32(&processed, 1)
+	}, 2)
+	bp.AddBatch(stringBatch{"a", "bad", "b"})
+	bp.Finish()
+
+	if processed != 2 {
+		t.Fatalf("processed %d items, want 2", processed)
+	}
+	failures := bp.Failures()
+	if len(failures) != 1 || failures[0].Item != "bad" {
+		t.Fatalf("got %+v", failures)
+	}
+	if pe, ok := failures[0].Err.(*PanicError); !ok || pe.Value != "bad item" {
+		t.Fatalf("got %v", failures[0].Err)
+	}
+	if progress := bp.Progress(); progress.Failed != 1 || progress.Done != 3 {
+		t.Fatalf("got %+v", progress)
+	}
+}
+
+type stringBatch []string
+
+func (b stringBatch) Item(index int) interface{} { return b[index] }
+func (b stringBatch) Len() int                   { return len(b) }
+
+func TestProcessorAddBatch(t *testing.T) {
+	p := NewProcessor(func(s string) (int, error) {
+		return len(s), nil
+	}, Options{MaxTasks: 2, Ordered: true})
+	p.AddBatch(stringBatch{"a", "bb", "ccc"})
+
+	results := p.Collect()
+	if len(results) != 3 || results[2].Value != 3 {
+		t.Fatalf("got %+v", results)
+	}
+}
@@ -0,0 +1,406 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Exit codes.
+const (
+	exitOK      = 0
+	exitFailure = 1 // The query, or the server list lookup, failed.
+	exitUsage   = 2 // Bad arguments.
+	exitPartial = 3 // A search succeeded, but some servers didn't answer.
+)
+
+// A subcommand of the binary.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) int
+}
+
+var commands = []command{
+	{"serve", "Run the HTTP API", runServe},
+	{"query", "Query one server's info and players", runQuery},
+	{"search", "Search the Steam server list and query each server", runSearch},
+	{"rules", "Query one server's rules (cvars)", runRules},
+}
+
+// runCommand dispatches to a subcommand and returns the exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	name := args[0]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args[1:], stdout, stderr)
+		}
+	}
+
+	switch name {
+	case "help", "-h", "-help", "--help":
+		printUsage(stdout)
+		return exitOK
+	case "version", "-version", "--version":
+		fmt.Fprintf(stdout, "Mastersteam %s (%s, built %s)\n", GitTag, GitCommit, BuildTime)
+		return exitOK
+	}
+
+	fmt.Fprintf(stderr, "Unknown command %q\n\n", name)
+	printUsage(stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: Mastersteam <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'Mastersteam <command> -h' for a command's flags.\n")
+}
+
+// Human-readable output, only offered by the query commands. The other
+// formats are in export.go.
+const formatTable = "table"
+
+// Flags shared by the query commands.
+type cliFlags struct {
+	*flag.FlagSet
+	format     string
+	timeout    time.Duration
+	verbose    bool
+	positional []string
+}
+
+func newCLIFlags(name, usage string, stderr io.Writer) *cliFlags {
+	flags := &cliFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	flags.SetOutput(stderr)
+	flags.StringVar(&flags.format, "format", formatTable, "Output format: table, json, csv, tsv or md")
+	flags.DurationVar(&flags.timeout, "timeout", queryTimeout, "Timeout for each A2S exchange")
+	flags.BoolVar(&flags.verbose, "v", false, "Log progress to stderr")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: Mastersteam %s\n\nFlags:\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parse the flags and check that exactly |nargs| arguments are given. On
+// failure, this returns the exit code to use.
+func (flags *cliFlags) parse(args []string, nargs int) (int, bool) {
+	// Allow flags after the arguments, as in "query 1.2.3.4 -format json".
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return exitOK, false
+			}
+			return exitUsage, false
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	flags.positional = positional
+
+	switch flags.format {
+	case formatTable, formatJSON, formatCSV, formatTSV, formatMarkdown:
+	default:
+		fmt.Fprintf(flags.Output(), "Unknown format %q\n", flags.format)
+		return exitUsage, false
+	}
+	if len(flags.positional) != nargs {
+		flags.Usage()
+		return exitUsage, false
+	}
+
+	// The query code logs as it goes, which is only noise on a terminal.
+	if !flags.verbose {
+		log.SetOutput(io.Discard)
+	}
+	queryTimeout = flags.timeout
+	return exitOK, true
+}
+
+// Resolve a server address, defaulting to the standard game port.
+func resolveServerAddr(addr string) (*net.TCPAddr, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "27015")
+	}
+	return net.ResolveTCPAddr("tcp", addr)
+}
+
+func runQuery(args []string, stdout, stderr io.Writer) int {
+	flags := newCLIFlags("query", "query [flags] HOST[:PORT]", stderr)
+	if code, ok := flags.parse(args, 1); !ok {
+		return code
+	}
+
+	addr, err := resolveServerAddr(flags.positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitUsage
+	}
+
+	server, err := queryServer(addr, nil, queryOptions{Fresh: true})
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s: %s\n", addr, err)
+		return exitFailure
+	}
+
+	switch flags.format {
+	case formatJSON:
+		err = writeJSON(stdout, server)
+	case formatTable:
+		err = writeServerTable(stdout, server)
+	default:
+		header, rows := serverRows([]batch.Result[*net.TCPAddr, *ServerObject]{{Item: addr, Value: server}})
+		err = writeTable(stdout, flags.format, header, rows)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runSearch(args []string, stdout, stderr io.Writer) int {
+	flags := newCLIFlags("search", "search [flags] -app APPID [-name NAME]", stderr)
+	appID := flags.Int("app", 0, "Steam app ID to search for (required unless -addr is given)")
+	name := flags.String("name", "", "Server name to match; * is a wildcard")
+	gameaddr := flags.String("addr", "", "Only list servers at this IP or IP:port")
+	master := flags.String("master", os.Getenv("MASTER_SERVER"), "List servers from this UDP master server (host:port) instead of the Steam Web API")
+	region := flags.String("region", envString("MASTER_REGION", valve.RegionAll.String()), "Region to ask the UDP master server for")
+	if code, ok := flags.parse(args, 0); !ok {
+		return code
+	}
+	if *appID == 0 && *gameaddr == "" {
+		fmt.Fprintf(stderr, "Either -app or -addr is required\n")
+		flags.Usage()
+		return exitUsage
+	}
+
+	masterServer = *master
+	var err error
+	if masterRegion, err = valve.ParseMasterRegion(*region); err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitUsage
+	}
+
+	if valve.SteamAPIKey == "" {
+		valve.SteamAPIKey = os.Getenv("STEAM_API_KEY")
+	}
+	if valve.SteamAPIKey == "" && masterServer == "" {
+		fmt.Fprintf(stderr, "Error: STEAM_API_KEY is not set. Get a key from https://steamcommunity.com/dev/apikey\n")
+		return exitFailure
+	}
+
+	querier, err := newMasterQuerier()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitFailure
+	}
+	defer querier.Close()
+	if *appID != 0 {
+		querier.FilterAppId(valve.AppId(*appID))
+	}
+	if *name != "" {
+		querier.FilterName(*name)
+	}
+	if *gameaddr != "" {
+		querier.FilterGameaddr(*gameaddr)
+	}
+
+	results, err := queryServers(querier, queryOptions{Fresh: true})
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to query the server list: %s\n", err)
+		return exitFailure
+	}
+
+	switch flags.format {
+	case formatJSON:
+		err = writeResults(stdout, results)
+	case formatTable:
+		err = writeServersTable(stdout, results)
+	default:
+		header, rows := serverRows(results)
+		err = writeTable(stdout, flags.format, header, rows)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitFailure
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if len(results) == 0 {
+		fmt.Fprintf(stderr, "No servers found\n")
+	} else if failed > 0 {
+		fmt.Fprintf(stderr, "%d of %d servers did not answer\n", failed, len(results))
+		return exitPartial
+	}
+	return exitOK
+}
+
+func runRules(args []string, stdout, stderr io.Writer) int {
+	flags := newCLIFlags("rules", "rules [flags] HOST[:PORT]", stderr)
+	if code, ok := flags.parse(args, 1); !ok {
+		return code
+	}
+
+	addr, err := resolveServerAddr(flags.positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitUsage
+	}
+
+	rules, err := queryRules(addr.String())
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s: %s\n", addr, err)
+		return exitFailure
+	}
+
+	switch flags.format {
+	case formatJSON:
+		err = writeJSON(stdout, rules)
+	case formatTable:
+		err = writeRulesTable(stdout, rules)
+	default:
+		header, rows := rulesRows(rules)
+		err = writeTable(stdout, flags.format, header, rows)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// queryRules asks a server for its rules. The info query comes first, since
+// it tells us how to decode a split reply.
+func queryRules(hostAndPort string) (map[string]string, error) {
+	query := &serverQuery{addr: hostAndPort, opts: queryOptions{Fresh: true}}
+	defer query.close()
+
+	info, err := query.info()
+	if err != nil {
+		return nil, err
+	}
+	return query.rules(info)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// Reason a server failed, as shown to users.
+func resultError(result batch.Result[*net.TCPAddr, *ServerObject]) string {
+	if result.Err == nil {
+		return ""
+	}
+	return newErrorObject(result.Item.String(), result.Err).Error
+}
+
+func writeServersTable(w io.Writer, results []batch.Result[*net.TCPAddr, *ServerObject]) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ADDRESS\tNAME\tMAP\tPLAYERS\tGAME\n")
+	for _, result := range results {
+		server := result.Value
+		if result.Err != nil || server == nil {
+			fmt.Fprintf(tw, "%s\t(%s)\t\t\t\n", result.Item, resultError(result))
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d/%d\t%s\n",
+			server.Address, server.Name, server.MapName,
+			server.Players, server.MaxPlayers, server.Game)
+	}
+	return tw.Flush()
+}
+
+func writeServerTable(w io.Writer, server *ServerObject) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Address:\t%s\n", server.Address)
+	fmt.Fprintf(tw, "Name:\t%s\n", server.Name)
+	fmt.Fprintf(tw, "Map:\t%s\n", server.MapName)
+	fmt.Fprintf(tw, "Game:\t%s (%s)\n", server.Game, server.Folder)
+	if server.AppID != 0 {
+		fmt.Fprintf(tw, "App ID:\t%d\n", server.AppID)
+	}
+	fmt.Fprintf(tw, "Players:\t%d/%d (%d bots)\n", server.Players, server.MaxPlayers, server.Bots)
+	fmt.Fprintf(tw, "Type:\t%s, %s, %s\n", server.Type, server.Os, server.Visibility)
+	fmt.Fprintf(tw, "VAC:\t%v\n", server.Vac)
+	if server.GameVersion != "" {
+		fmt.Fprintf(tw, "Version:\t%s\n", server.GameVersion)
+	}
+	if server.GameMode != "" {
+		fmt.Fprintf(tw, "Keywords:\t%s\n", server.GameMode)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(server.PlayersOnline) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "PLAYER\tSCORE\tTIME\n")
+	for _, player := range server.PlayersOnline {
+		duration := time.Duration(player.Duration) * time.Second
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", player.Name, player.Score, duration)
+	}
+	return tw.Flush()
+}
+
+func sortedRuleNames(rules map[string]string) []string {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func rulesRows(rules map[string]string) ([]string, [][]string) {
+	var rows [][]string
+	for _, name := range sortedRuleNames(rules) {
+		rows = append(rows, []string{name, rules[name]})
+	}
+	return []string{"name", "value"}, rows
+}
+
+func writeRulesTable(w io.Writer, rules map[string]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tVALUE\n")
+	for _, name := range sortedRuleNames(rules) {
+		// Keep multi-line values on one row.
+		value := strings.ReplaceAll(rules[name], "\n", `\n`)
+		fmt.Fprintf(tw, "%s\t%s\n", name, value)
+	}
+	return tw.Flush()
+}
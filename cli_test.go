@@ -0,0 +1,143 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+	"github.com/cyxc1124/Mastersteam/valve/mastertest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLIUsage(t *testing.T) {
+	if code, _, _ := runCLI(t); code != exitUsage {
+		t.Fatalf("no arguments: got exit code %d", code)
+	}
+	if code, _, stderr := runCLI(t, "frobnicate"); code != exitUsage || !strings.Contains(stderr, "Unknown command") {
+		t.Fatalf("got exit code %d: %s", code, stderr)
+	}
+	if code, _, _ := runCLI(t, "query"); code != exitUsage {
+		t.Fatalf("query without an address: got exit code %d", code)
+	}
+	if code, _, _ := runCLI(t, "query", "127.0.0.1", "-format", "xml"); code != exitUsage {
+		t.Fatalf("bad format: got exit code %d", code)
+	}
+}
+
+func TestCLIQuery(t *testing.T) {
+	server := a2stest.NewServer(a2stest.SourceInfo())
+	defer server.Close()
+
+	code, stdout, stderr := runCLI(t, "query", server.Addr())
+	if code != exitOK || !strings.Contains(stdout, "Name:") || !strings.Contains(stdout, "cp_badlands") {
+		t.Fatalf("got exit code %d\n%s%s", code, stdout, stderr)
+	}
+
+	code, stdout, _ = runCLI(t, "query", server.Addr(), "-format", "json")
+	var object ServerObject
+	if code != exitOK || json.Unmarshal([]byte(stdout), &object) != nil || object.Address != server.Addr() {
+		t.Fatalf("got exit code %d\n%s", code, stdout)
+	}
+
+	code, stdout, _ = runCLI(t, "query", "-format", "csv", server.Addr())
+	rows, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	if code != exitOK || err != nil || len(rows) != 2 || rows[1][0] != server.Addr() {
+		t.Fatalf("got exit code %d, %v\n%s", code, err, stdout)
+	}
+
+	if code, _, _ := runCLI(t, "query", "-timeout", "200ms", "127.0.0.1:1"); code != exitFailure {
+		t.Fatalf("unreachable server: got exit code %d", code)
+	}
+}
+
+func TestCLIRules(t *testing.T) {
+	server := a2stest.NewUnstartedServer(a2stest.SourceInfo())
+	server.SetRules(map[string]string{"sv_gravity": "800", "mp_timelimit": "30"})
+	server.Start()
+	defer server.Close()
+
+	code, stdout, _ := runCLI(t, "rules", server.Addr())
+	if code != exitOK || !strings.Contains(stdout, "sv_gravity") {
+		t.Fatalf("got exit code %d\n%s", code, stdout)
+	}
+	// Rules are sorted by name.
+	if strings.Index(stdout, "mp_timelimit") > strings.Index(stdout, "sv_gravity") {
+		t.Fatalf("rules are not sorted:\n%s", stdout)
+	}
+}
+
+func TestCLISearch(t *testing.T) {
+	server := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: server.Addr(), Name: "Uncletopia | Seattle", Appid: 440},
+	)
+
+	code, stdout, stderr := runCLI(t, "search", "-app", "440", "-name", "uncletopia*")
+	if code != exitOK || !strings.Contains(stdout, server.Addr()) {
+		t.Fatalf("got exit code %d\n%s%s", code, stdout, stderr)
+	}
+
+	if code, _, stderr := runCLI(t, "search", "-name", "x"); code != exitUsage {
+		t.Fatalf("search without -app: got exit code %d: %s", code, stderr)
+	}
+}
+
+func TestCLISearchUdpMaster(t *testing.T) {
+	t.Cleanup(func() { masterServer, masterRegion = "", valve.RegionAll })
+
+	server := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	master := mastertest.NewServer(
+		webapitest.Entry{Addr: server.Addr(), Appid: 440, Region: int(valve.RegionEurope)},
+	)
+	defer master.Close()
+
+	// No Steam API key is needed.
+	oldKey := valve.SteamAPIKey
+	valve.SteamAPIKey = ""
+	t.Setenv("STEAM_API_KEY", "")
+	t.Cleanup(func() { valve.SteamAPIKey = oldKey })
+
+	code, stdout, stderr := runCLI(t, "search", "-master", master.Addr(), "-region", "europe", "-app", "440")
+	if code != exitOK || !strings.Contains(stdout, server.Addr()) {
+		t.Fatalf("got exit code %d\n%s%s", code, stdout, stderr)
+	}
+	if requests := master.Requests(); len(requests) != 1 || requests[0].Region != valve.RegionEurope {
+		t.Fatalf("got requests %+v", requests)
+	}
+
+	if code, _, _ := runCLI(t, "search", "-master", master.Addr(), "-region", "mars", "-app", "440"); code != exitUsage {
+		t.Fatalf("bad region: got exit code %d", code)
+	}
+}
+
+func TestCLISearchPartialFailure(t *testing.T) {
+	server := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: server.Addr(), Appid: 440},
+		webapitest.Entry{Addr: "127.0.0.1:1", Appid: 440},
+	)
+
+	code, stdout, _ := runCLI(t, "search", "-app", "440", "-timeout", "300ms", "-format", "csv")
+	if code != exitPartial {
+		t.Fatalf("got exit code %d, want %d\n%s", code, exitPartial, stdout)
+	}
+	rows, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	if err != nil || len(rows) != 3 || rows[2][len(rows[2])-2] == "" {
+		t.Fatalf("got %v, %v", rows, err)
+	}
+}
@@ -0,0 +1,265 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Package client is a Go client for the Mastersteam HTTP API, as described by
+// its /openapi.json. It is written by hand; the service's tests check that its
+// requests and types still match the spec.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Client talks to one Mastersteam instance. It is safe for concurrent use.
+type Client struct {
+	// Base URL of the service, e.g. "http://localhost:8080".
+	BaseURL string
+
+	// Client used for requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Bearer token for Search and Server, the service's API_TOKEN. Leave
+	// empty if the service doesn't require one.
+	APIToken string
+
+	// Bearer token for Rcon, the service's RCON_API_TOKEN.
+	RconToken string
+}
+
+// Create a client for the service at |baseURL|.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// Options for Search and Server.
+type Options struct {
+	// Query the servers again instead of accepting cached replies.
+	Fresh bool
+}
+
+// A server's A2S_INFO reply and players.
+type Server struct {
+	Address     string `json:"ip"`
+	Protocol    uint8  `json:"protocol"`
+	Name        string `json:"name"`
+	MapName     string `json:"map"`
+	Folder      string `json:"folder"`
+	Game        string `json:"game"`
+	Players     uint8  `json:"players"`
+	MaxPlayers  uint8  `json:"max_players"`
+	Bots        uint8  `json:"bots"`
+	Type        string `json:"type"`
+	Os          string `json:"os"`
+	Visibility  string `json:"visibility"`
+	Vac         bool   `json:"vac"`
+	AppID       uint32 `json:"appid,omitempty"`
+	GameVersion string `json:"game_version,omitempty"`
+	Port        uint16 `json:"port,omitempty"`
+	SteamID     string `json:"steamid,omitempty"`
+	GameMode    string `json:"game_mode,omitempty"`
+	GameID      string `json:"gameid,omitempty"`
+
+	PlayersOnline []*Player `json:"players_online,omitempty"`
+
+	// When the reply was received, if it was served from the cache.
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+
+	// The server list sources that listed the server, when several were
+	// merged.
+	Sources []string `json:"sources,omitempty"`
+}
+
+type Player struct {
+	Name  string `json:"Name"`
+	Score uint32 `json:"Score"`
+
+	// Seconds connected.
+	Duration float32 `json:"Duration"`
+}
+
+// Why a server couldn't be queried.
+type ServerError struct {
+	Address string `json:"ip"`
+
+	// A short reason, e.g. "Connection timeout".
+	Message string `json:"error"`
+
+	// When the failure was seen, if it was served from the cache.
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+
+	// The server list sources that listed the server, when several were
+	// merged.
+	Sources []string `json:"sources,omitempty"`
+}
+
+func (e *ServerError) Error() string {
+	return e.Address + ": " + e.Message
+}
+
+// The outcome for one server: either Server or Err is set.
+type Result struct {
+	Address string
+	Server  *Server
+	Err     *ServerError
+}
+
+// An error response from the service.
+type Error struct {
+	StatusCode int    `json:"status"`
+	Message    string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mastersteam: %d %s", e.StatusCode, e.Message)
+}
+
+// Search the server list for servers of an app whose name matches |name|, in
+// which * is a wildcard, and query each of them. Results are in the order the
+// service returned them.
+func (c *Client) Search(ctx context.Context, appID uint32, name string, opts *Options) ([]Result, error) {
+	path := "/search/" + strconv.FormatUint(uint64(appID), 10) + "/" + url.QueryEscape(name)
+	return c.results(ctx, path, opts)
+}
+
+// Query the servers at |addr|, an IP or IP:port.
+func (c *Client) Server(ctx context.Context, addr string, opts *Options) ([]Result, error) {
+	return c.results(ctx, "/server/"+url.QueryEscape(addr), opts)
+}
+
+// Run an RCON command on the server at |addr| (host:port) and return its
+// output.
+func (c *Client) Rcon(ctx context.Context, addr, command string) (string, error) {
+	body, err := json.Marshal(map[string]string{"command": command})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/rcon/"+url.PathEscape(addr)), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.RconToken)
+
+	var response struct {
+		Response string `json:"response"`
+	}
+	if err := c.do(req, &response); err != nil {
+		return "", err
+	}
+	return response.Response, nil
+}
+
+func (c *Client) results(ctx context.Context, path string, opts *Options) ([]Result, error) {
+	if opts != nil && opts.Fresh {
+		path += "?fresh=1"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIToken)
+	}
+
+	var response struct {
+		Data  []orderedResults `json:"data"`
+		Total int              `json:"total"`
+	}
+	if err := c.do(req, &response); err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, data := range response.Data {
+		results = append(results, data...)
+	}
+	return results, nil
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
+
+// Send a request and decode a JSON reply into |out|.
+func (c *Client) do(req *http.Request, out interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// The object of results keyed by server address, kept in order.
+type orderedResults []Result
+
+func (r *orderedResults) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('{') {
+		return fmt.Errorf("mastersteam: expected an object of results, got %v", token)
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		addr, _ := token.(string)
+
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return err
+		}
+		result, err := decodeResult(addr, raw)
+		if err != nil {
+			return err
+		}
+		*r = append(*r, result)
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+// Decode a server, or an error object if the server couldn't be queried.
+func decodeResult(addr string, raw json.RawMessage) (Result, error) {
+	var probe struct {
+		Error *string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Address: addr}
+	if probe.Error != nil {
+		result.Err = &ServerError{}
+		return result, json.Unmarshal(raw, result.Err)
+	}
+	result.Server = &Server{}
+	return result, json.Unmarshal(raw, result.Server)
+}
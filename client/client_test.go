@@ -0,0 +1,87 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyxc1124/Mastersteam/client"
+)
+
+func newTestService(t *testing.T, handler http.HandlerFunc) *client.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return client.New(server.URL + "/")
+}
+
+func TestSearchKeepsOrder(t *testing.T) {
+	c := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/search/440/Uncletopia+%7C+%2A" || r.URL.RawQuery != "fresh=1" ||
+			r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("got %s", r.URL)
+		}
+		w.Write([]byte(`{
+	"data" : [{
+	"10.0.0.2:27015": {"ip": "10.0.0.2:27015", "name": "b", "players_online": [{"Name": "alice", "Score": 3, "Duration": 12.5}]},
+	"10.0.0.1:27015": {"ip": "10.0.0.1:27015", "error": "Connection timeout", "cached_at": "2024-01-02T03:04:05Z"},
+	"10.0.0.3:27015": {"ip": "10.0.0.3:27015", "name": "c"}}],
+	"total":3
+}`))
+	})
+
+	c.APIToken = "token"
+	results, err := c.Search(context.Background(), 440, "Uncletopia | *", &client.Options{Fresh: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results", len(results))
+	}
+
+	if r := results[0]; r.Address != "10.0.0.2:27015" || r.Server == nil || r.Server.Name != "b" ||
+		len(r.Server.PlayersOnline) != 1 || r.Server.PlayersOnline[0].Score != 3 {
+		t.Errorf("got %+v", r)
+	}
+	if r := results[1]; r.Server != nil || r.Err == nil || r.Err.Message != "Connection timeout" || r.Err.CachedAt == nil {
+		t.Errorf("got %+v", r)
+	}
+	if r := results[2]; r.Address != "10.0.0.3:27015" || r.Server == nil {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	c := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Invalid Steam API Key", "status": 401})
+	})
+
+	_, err := c.Server(context.Background(), "10.0.0.1", nil)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Invalid Steam API Key" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestRcon(t *testing.T) {
+	c := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		var request struct{ Command string }
+		json.NewDecoder(r.Body).Decode(&request)
+		if r.Method != http.MethodPost || r.URL.Path != "/rcon/10.0.0.1:27015" ||
+			r.Header.Get("Authorization") != "Bearer secret" || request.Command != "status" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"ip": "10.0.0.1:27015", "command": "status", "response": "hostname: x"})
+	})
+	c.RconToken = "secret"
+
+	output, err := c.Rcon(context.Background(), "10.0.0.1:27015", "status")
+	if err != nil || output != "hostname: x" {
+		t.Fatalf("got %q, %v", output, err)
+	}
+}
@@ -0,0 +1,90 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString returns the value of an environment variable, or def if unset.
+func envString(name string, def string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return def
+}
+
+// envBool parses a boolean environment variable. Invalid values are logged and
+// replaced with the default.
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid value for %s: %q, using default %v", name, value, def)
+		return def
+	}
+	return b
+}
+
+// envInt parses an integer environment variable. Invalid values are logged and
+// replaced with the default.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid value for %s: %q, using default %d", name, value, def)
+		return def
+	}
+	return i
+}
+
+// envDuration parses a duration environment variable such as "3s". Invalid
+// values are logged and replaced with the default.
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid value for %s: %q, using default %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+// envList parses a comma-separated environment variable. Empty entries are
+// dropped.
+func envList(name string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envMap parses a comma-separated list of key=value pairs.
+func envMap(name string) map[string]string {
+	m := map[string]string{}
+	for _, item := range envList(name) {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Printf("⚠️  Ignoring malformed entry in %s: %q", name, item)
+			continue
+		}
+		m[strings.TrimSpace(key)] = value
+	}
+	return m
+}
@@ -0,0 +1,262 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+)
+
+// Output formats shared by the HTTP API and the query commands.
+const (
+	formatJSON     = "json"
+	formatCSV      = "csv"
+	formatTSV      = "tsv"
+	formatMarkdown = "md"
+)
+
+// Media types for each format.
+var formatMediaTypes = map[string]string{
+	formatJSON:     "application/json",
+	formatCSV:      "text/csv",
+	formatTSV:      "text/tab-separated-values",
+	formatMarkdown: "text/markdown",
+}
+
+// A column of a tabular export.
+type column[T any] struct {
+	name  string
+	value func(T) string
+}
+
+// Server columns, in the order of the ServerObject fields. Add new columns at
+// the end, so spreadsheets built on earlier exports keep working.
+var serverColumns = []column[*ServerObject]{
+	{"ip", func(s *ServerObject) string { return s.Address }},
+	{"protocol", func(s *ServerObject) string { return strconv.Itoa(int(s.Protocol)) }},
+	{"name", func(s *ServerObject) string { return s.Name }},
+	{"map", func(s *ServerObject) string { return s.MapName }},
+	{"folder", func(s *ServerObject) string { return s.Folder }},
+	{"game", func(s *ServerObject) string { return s.Game }},
+	{"players", func(s *ServerObject) string { return strconv.Itoa(int(s.Players)) }},
+	{"max_players", func(s *ServerObject) string { return strconv.Itoa(int(s.MaxPlayers)) }},
+	{"bots", func(s *ServerObject) string { return strconv.Itoa(int(s.Bots)) }},
+	{"type", func(s *ServerObject) string { return s.Type }},
+	{"os", func(s *ServerObject) string { return s.Os }},
+	{"visibility", func(s *ServerObject) string { return s.Visibility }},
+	{"vac", func(s *ServerObject) string { return strconv.FormatBool(s.Vac) }},
+	{"appid", func(s *ServerObject) string { return strconv.Itoa(int(s.AppID)) }},
+	{"game_version", func(s *ServerObject) string { return s.GameVersion }},
+	{"port", func(s *ServerObject) string { return strconv.Itoa(int(s.Port)) }},
+	{"steamid", func(s *ServerObject) string { return s.SteamID }},
+	{"game_mode", func(s *ServerObject) string { return s.GameMode }},
+	{"gameid", func(s *ServerObject) string { return s.GameID }},
+	{"cached_at", func(s *ServerObject) string { return formatTime(s.CachedAt) }},
+}
+
+// A player row: the server address, then the player's fields.
+type playerRow struct {
+	server string
+	player int
+	*ServerObject
+}
+
+var playerColumns = []column[playerRow]{
+	{"ip", func(r playerRow) string { return r.server }},
+	{"name", func(r playerRow) string { return r.PlayersOnline[r.player].Name }},
+	{"score", func(r playerRow) string { return strconv.FormatUint(uint64(r.PlayersOnline[r.player].Score), 10) }},
+	{"duration", func(r playerRow) string {
+		return strconv.FormatFloat(float64(r.PlayersOnline[r.player].Duration), 'f', 0, 32)
+	}},
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// serverRows lays out query results as a header and rows. Servers that failed
+// have only their address, the error and their sources filled in. The sources
+// come last, after the error, and are only filled in when several were
+// merged.
+func serverRows(results []batch.Result[*net.TCPAddr, *ServerObject]) ([]string, [][]string) {
+	header := make([]string, 0, len(serverColumns)+2)
+	for _, col := range serverColumns {
+		header = append(header, col.name)
+	}
+	header = append(header, "error", "sources")
+
+	var rows [][]string
+	for _, result := range results {
+		row := make([]string, len(header))
+		if result.Err != nil || result.Value == nil {
+			errorObject := buildErrorObject(result.Item.String(), result.Err)
+			row[0] = result.Item.String()
+			row[len(row)-2] = errorObject.Error
+			row[len(row)-1] = strings.Join(errorObject.Sources, ",")
+		} else {
+			for i, col := range serverColumns {
+				row[i] = col.value(result.Value)
+			}
+			row[len(row)-1] = strings.Join(result.Value.Sources, ",")
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+// playerRows lays out every player of every server that answered, one per
+// row.
+func playerRows(results []batch.Result[*net.TCPAddr, *ServerObject]) ([]string, [][]string) {
+	header := make([]string, 0, len(playerColumns))
+	for _, col := range playerColumns {
+		header = append(header, col.name)
+	}
+
+	var rows [][]string
+	for _, result := range results {
+		if result.Value == nil {
+			continue
+		}
+		for i := range result.Value.PlayersOnline {
+			r := playerRow{result.Item.String(), i, result.Value}
+			row := make([]string, len(playerColumns))
+			for j, col := range playerColumns {
+				row[j] = col.value(r)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return header, rows
+}
+
+// writeTable writes a header and rows as CSV, TSV or a Markdown table.
+func writeTable(w io.Writer, format string, header []string, rows [][]string) error {
+	if format == formatMarkdown {
+		return writeMarkdownTable(w, header, rows)
+	}
+
+	out := csv.NewWriter(w)
+	if format == formatTSV {
+		out.Comma = '\t'
+	}
+	out.Write(header)
+	out.WriteAll(rows)
+	return out.Error()
+}
+
+func writeMarkdownTable(w io.Writer, header []string, rows [][]string) error {
+	escape := strings.NewReplacer("|", `\|`, "\r", "", "\n", "<br>")
+	line := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = escape.Replace(cell)
+		}
+		_, err := fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+		return err
+	}
+
+	if err := line(header); err != nil {
+		return err
+	}
+	rule := make([]string, len(header))
+	for i := range rule {
+		rule[i] = "---"
+	}
+	if err := line(rule); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := line(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An output format requested by an HTTP client.
+type responseFormat struct {
+	format string
+
+	// For tabular formats, which table to export: "servers", or "players"
+	// for the players of every server, one per row.
+	sheet string
+}
+
+// requestFormat picks the output format for a request: the format query
+// parameter if given, otherwise the most preferred type in the Accept
+// header, otherwise JSON.
+func requestFormat(r *http.Request) (responseFormat, error) {
+	query := r.URL.Query()
+	out := responseFormat{format: formatJSON, sheet: query.Get("sheet")}
+
+	switch out.sheet {
+	case "":
+		out.sheet = "servers"
+	case "servers", "players":
+	default:
+		return out, fmt.Errorf("sheet %q isn't servers or players", out.sheet)
+	}
+
+	if format := strings.ToLower(query.Get("format")); format != "" {
+		if format == "markdown" {
+			format = formatMarkdown
+		}
+		if _, ok := formatMediaTypes[format]; !ok {
+			return out, fmt.Errorf("format %q isn't json, csv, tsv or md", format)
+		}
+		out.format = format
+		return out, nil
+	}
+
+	best := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		for format, formatType := range formatMediaTypes {
+			// The first of equally preferred types wins.
+			if mediaType == formatType && q > best {
+				out.format, best = format, q
+			}
+		}
+	}
+	return out, nil
+}
+
+// write sends query results in this format.
+func (f responseFormat) write(w http.ResponseWriter, results []batch.Result[*net.TCPAddr, *ServerObject]) error {
+	w.Header().Set("Content-Type", formatMediaTypes[f.format]+"; charset=UTF-8")
+	if f.format == formatJSON {
+		return writeResults(w, results)
+	}
+
+	// Failures are logged here, once, as writeResults does.
+	for _, result := range results {
+		if result.Err != nil {
+			logQueryError(result.Item.String(), result.Err)
+		}
+	}
+	header, rows := serverRows(results)
+	if f.sheet == "players" {
+		header, rows = playerRows(results)
+	}
+	return writeTable(w, f.format, header, rows)
+}
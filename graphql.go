@@ -0,0 +1,557 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Maximum size of a /graphql request body.
+const kMaxGraphQLRequestSize = 64 * 1024
+
+// The parts of a server a GraphQL query can select. Each costs one A2S query.
+type a2sPart int
+
+const (
+	partInfo a2sPart = iota
+	partPlayers
+	partRules
+	numParts
+)
+
+// A server as returned by the search and server fields. Nothing is queried
+// until one of its other fields is selected.
+type serverRef struct {
+	addr  string
+	fresh bool
+
+	// In a search merged from several sources, the sources that listed the
+	// server.
+	sources []string
+}
+
+// A serverLoader batches the A2S queries of one GraphQL request. Resolvers
+// ask it for part of a server and get a thunk back. The executor runs thunks
+// once it has resolved a whole level of the query, and the first one to run
+// queries everything asked for so far, all servers at once. Each part of a
+// server is queried at most once per request.
+type serverLoader struct {
+	mu      sync.Mutex
+	servers map[string]*loadedServer
+	pending []*loadedServer
+}
+
+// What has been asked of one server, and its replies.
+type loadedServer struct {
+	addr   string
+	fresh  bool
+	queued bool
+	wanted [numParts]bool
+	loaded [numParts]bool
+	errs   [numParts]error
+
+	info     *valve.ServerInfo
+	players  []*valve.Player
+	rules    map[string]string
+	cachedAt time.Time
+}
+
+type serverLoaderKey struct{}
+
+func newServerLoader() *serverLoader {
+	return &serverLoader{servers: map[string]*loadedServer{}}
+}
+
+func loaderFrom(ctx context.Context) *serverLoader {
+	return ctx.Value(serverLoaderKey{}).(*serverLoader)
+}
+
+// Ask for part of a server. |value| is called with the server once the part
+// has been queried.
+func (l *serverLoader) load(ref serverRef, part a2sPart, value func(*loadedServer) (interface{}, error)) func() (interface{}, error) {
+	l.mu.Lock()
+	server, ok := l.servers[ref.addr]
+	if !ok {
+		server = &loadedServer{addr: ref.addr}
+		l.servers[ref.addr] = server
+	}
+	server.fresh = server.fresh || ref.fresh
+	if !server.loaded[part] && !server.wanted[part] {
+		server.wanted[part] = true
+		if !server.queued {
+			server.queued = true
+			l.pending = append(l.pending, server)
+		}
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		loaded := server.loaded[part]
+		l.mu.Unlock()
+		if !loaded {
+			l.flush()
+		}
+		return value(server)
+	}
+}
+
+// Query the info of several servers at once.
+func (l *serverLoader) loadInfo(refs []serverRef) {
+	for _, ref := range refs {
+		l.load(ref, partInfo, nil)
+	}
+	l.flush()
+}
+
+// A server something was asked of, or nil.
+func (l *serverLoader) server(addr string) *loadedServer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.servers[addr]
+}
+
+// Query every server that has parts waiting.
+func (l *serverLoader) flush() {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	bp := batch.NewProcessor(func(server *loadedServer) (struct{}, error) {
+		l.fetch(server)
+		return struct{}{}, nil
+	}, batch.Options{
+		MaxTasks: queryMaxWorkers,
+		Pool:     queryPool,
+		Priority: batch.PriorityInteractive,
+	})
+	bp.Add(pending...)
+	bp.Collect()
+}
+
+// Query the parts of a server that were asked for. Players and rules need the
+// info to decode split replies, so it's always queried first.
+func (l *serverLoader) fetch(server *loadedServer) {
+	l.mu.Lock()
+	wanted := server.wanted
+	server.wanted = [numParts]bool{}
+	server.queued = false
+	l.mu.Unlock()
+
+	query := &serverQuery{
+		addr: server.addr,
+		opts: queryOptions{Priority: batch.PriorityInteractive, Fresh: server.fresh},
+	}
+	defer query.close()
+
+	if !server.loaded[partInfo] {
+		info, err := query.info()
+		l.mu.Lock()
+		server.info, server.errs[partInfo], server.loaded[partInfo] = info, err, true
+		l.mu.Unlock()
+	}
+
+	var players []*valve.Player
+	var rules map[string]string
+	var playersErr, rulesErr error
+	if err := server.errs[partInfo]; err != nil {
+		playersErr, rulesErr = err, err
+	} else {
+		if wanted[partPlayers] && server.info.Players > 0 {
+			players, playersErr = query.players(server.info)
+		}
+		if wanted[partRules] {
+			rules, rulesErr = query.rules(server.info)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if wanted[partPlayers] {
+		server.players, server.errs[partPlayers], server.loaded[partPlayers] = players, playersErr, true
+	}
+	if wanted[partRules] {
+		server.rules, server.errs[partRules], server.loaded[partRules] = rules, rulesErr, true
+	}
+	if !query.cachedAt.IsZero() && (server.cachedAt.IsZero() || query.cachedAt.Before(server.cachedAt)) {
+		server.cachedAt = query.cachedAt
+	}
+}
+
+// A field of a server's A2S_INFO reply. It's null if the server didn't
+// answer; the error field says why.
+func infoField(typ graphql.Output, description string, get func(*ServerObject) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type:        typ,
+		Description: description,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ref := p.Source.(serverRef)
+			return loaderFrom(p.Context).load(ref, partInfo, func(server *loadedServer) (interface{}, error) {
+				if server.errs[partInfo] != nil {
+					return nil, nil
+				}
+				return get(newServerObject(server.addr, server.info)), nil
+			}), nil
+		},
+	}
+}
+
+// A server's players or rules. Failures are reported as field errors.
+func partField(typ graphql.Output, description string, part a2sPart, get func(*loadedServer) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type:        typ,
+		Description: description,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ref := p.Source.(serverRef)
+			return loaderFrom(p.Context).load(ref, part, func(server *loadedServer) (interface{}, error) {
+				if err := server.errs[part]; err != nil {
+					return nil, errors.New(newErrorObject(server.addr, err).Error)
+				}
+				return get(server), nil
+			}), nil
+		},
+	}
+}
+
+type gqlRule struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var gqlPlayerType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Player",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*valve.Player).Name, nil
+			},
+		},
+		"score": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return int(p.Source.(*valve.Player).Score), nil
+			},
+		},
+		"duration": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.Float),
+			Description: "Seconds connected.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return float64(p.Source.(*valve.Player).Duration), nil
+			},
+		},
+	},
+})
+
+var gqlRuleType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Rule",
+	Fields: graphql.Fields{
+		"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"value": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+// Server fields are named as in the JSON API.
+var gqlServerType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "Server",
+	Description: "A game server. Info fields cost an A2S_INFO query, players_online an A2S_PLAYER query and rules an A2S_RULES query.",
+	Fields: graphql.Fields{
+		"ip": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(serverRef).addr, nil
+			},
+		},
+		"error": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Why the server couldn't be queried, if it couldn't.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				ref := p.Source.(serverRef)
+				return loaderFrom(p.Context).load(ref, partInfo, func(server *loadedServer) (interface{}, error) {
+					if err := server.errs[partInfo]; err != nil {
+						return newErrorObject(server.addr, err).Error, nil
+					}
+					return nil, nil
+				}), nil
+			},
+		},
+		"protocol":     infoField(graphql.Int, "", func(s *ServerObject) interface{} { return int(s.Protocol) }),
+		"name":         infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.Name }),
+		"map":          infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.MapName }),
+		"folder":       infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.Folder }),
+		"game":         infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.Game }),
+		"players":      infoField(graphql.Int, "Number of players.", func(s *ServerObject) interface{} { return int(s.Players) }),
+		"max_players":  infoField(graphql.Int, "", func(s *ServerObject) interface{} { return int(s.MaxPlayers) }),
+		"bots":         infoField(graphql.Int, "", func(s *ServerObject) interface{} { return int(s.Bots) }),
+		"type":         infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.Type }),
+		"os":           infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.Os }),
+		"visibility":   infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.Visibility }),
+		"vac":          infoField(graphql.Boolean, "", func(s *ServerObject) interface{} { return s.Vac }),
+		"appid":        infoField(graphql.Int, "", func(s *ServerObject) interface{} { return int(s.AppID) }),
+		"game_version": infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.GameVersion }),
+		"port":         infoField(graphql.Int, "", func(s *ServerObject) interface{} { return int(s.Port) }),
+		"steamid":      infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.SteamID }),
+		"game_mode":    infoField(graphql.String, "The server's keywords (tags).", func(s *ServerObject) interface{} { return s.GameMode }),
+		"gameid":       infoField(graphql.String, "", func(s *ServerObject) interface{} { return s.GameID }),
+		"players_online": partField(graphql.NewList(graphql.NewNonNull(gqlPlayerType)), "", partPlayers,
+			func(server *loadedServer) interface{} {
+				if server.players == nil {
+					return []*valve.Player{}
+				}
+				return server.players
+			}),
+		"rules": partField(graphql.NewList(graphql.NewNonNull(gqlRuleType)), "Server cvars, sorted by name.", partRules,
+			func(server *loadedServer) interface{} {
+				rules := make([]gqlRule, 0, len(server.rules))
+				for _, name := range sortedRuleNames(server.rules) {
+					rules = append(rules, gqlRule{name, server.rules[name]})
+				}
+				return rules
+			}),
+		"cached_at": &graphql.Field{
+			Type:        graphql.DateTime,
+			Description: "When the oldest reply used was received, if any came from the cache.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				ref := p.Source.(serverRef)
+				return loaderFrom(p.Context).load(ref, partInfo, func(server *loadedServer) (interface{}, error) {
+					if server.cachedAt.IsZero() {
+						return nil, nil
+					}
+					return server.cachedAt, nil
+				}), nil
+			},
+		},
+		"sources": &graphql.Field{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "In a search merged from several sources, every source that listed the server.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				sources := p.Source.(serverRef).sources
+				if sources == nil {
+					return nil, nil
+				}
+				return sources, nil
+			},
+		},
+	},
+})
+
+var freshArgument = &graphql.ArgumentConfig{
+	Type:         graphql.Boolean,
+	DefaultValue: false,
+	Description:  "Query the servers again instead of using cached replies.",
+}
+
+var gqlQueryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"search": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(gqlServerType))),
+			Description: "Servers on the Steam server list. Either appid or addr is required.",
+			Args: graphql.FieldConfigArgument{
+				"appid": &graphql.ArgumentConfig{Type: graphql.Int},
+				"name": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "Server name to match; * is a wildcard.",
+				},
+				"addr": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "Only servers at this IP or IP:port.",
+				},
+				"source": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "Server list sources to use, comma-separated, or all. Defaults to DEFAULT_SOURCE.",
+				},
+				"fresh": freshArgument,
+			},
+			Resolve: resolveSearch,
+		},
+		"server": &graphql.Field{
+			Type:        graphql.NewNonNull(gqlServerType),
+			Description: "One server, queried directly without the server list.",
+			Args: graphql.FieldConfigArgument{
+				"addr": &graphql.ArgumentConfig{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "IP or host, with an optional port (default 27015).",
+				},
+				"fresh": freshArgument,
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				addr, err := resolveServerAddr(p.Args["addr"].(string))
+				if err != nil {
+					return nil, errors.New("invalid server address")
+				}
+				return serverRef{addr: addr.String(), fresh: p.Args["fresh"].(bool)}, nil
+			},
+		},
+	},
+})
+
+var graphqlSchema = func() graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: gqlQueryType})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}()
+
+func resolveSearch(p graphql.ResolveParams) (interface{}, error) {
+	appID, _ := p.Args["appid"].(int)
+	name, _ := p.Args["name"].(string)
+	gameaddr, _ := p.Args["addr"].(string)
+	if appID == 0 && gameaddr == "" {
+		return nil, errors.New("either appid or addr is required")
+	}
+
+	spec, _ := p.Args["source"].(string)
+	sources, err := parseSources(spec)
+	if err != nil {
+		return nil, err
+	}
+	master, err := openSources(sources)
+	if err != nil {
+		_, message := describeQueryError(err)
+		return nil, errors.New(message)
+	}
+	defer master.Close()
+	if appID != 0 {
+		master.FilterAppId(valve.AppId(appID))
+	}
+	if name != "" {
+		master.FilterName(name)
+	}
+	if gameaddr != "" {
+		master.FilterGameaddr(gameaddr)
+	}
+
+	fresh := p.Args["fresh"].(bool)
+	var addrs []*net.TCPAddr
+	var servers []serverRef
+	err = master.Query(func(list valve.ServerList) error {
+		for _, addr := range list {
+			addrs = append(addrs, addr)
+			servers = append(servers, serverRef{addr: addr.String(), fresh: fresh})
+		}
+		return nil
+	})
+	if err != nil {
+		_, message := describeQueryError(err)
+		return nil, errors.New(message)
+	}
+
+	// Servers whose source couldn't filter have their info asked first, and
+	// in a search merged from several sources every server's, to find those
+	// listed under different addresses. The loader keeps the replies for the
+	// fields that select them, and players and rules are still only queried
+	// if selected.
+	_, merged := master.(*valve.MultiMasterQuerier)
+	var unchecked []serverRef
+	for i, addr := range addrs {
+		if merged || needsInfo(master, addr) {
+			unchecked = append(unchecked, servers[i])
+		}
+	}
+	if len(unchecked) == 0 {
+		return servers, nil
+	}
+	loader := loaderFrom(p.Context)
+	loader.loadInfo(unchecked)
+
+	filterer, _ := master.(valve.InfoFilterer)
+	results := make([]batch.Result[*net.TCPAddr, *ServerObject], 0, len(addrs))
+	for i, addr := range addrs {
+		result := batch.Result[*net.TCPAddr, *ServerObject]{Item: addr}
+		server := loader.server(servers[i].addr)
+		switch {
+		case server == nil:
+			result.Value = &ServerObject{Address: servers[i].addr}
+		case server.errs[partInfo] != nil:
+			result.Err = server.errs[partInfo]
+		case needsInfo(master, addr) && !filterer.MatchInfo(addr, server.info):
+			continue
+		default:
+			result.Value = newServerObject(server.addr, server.info)
+		}
+		results = append(results, result)
+	}
+
+	matched := make([]serverRef, 0, len(results))
+	for _, result := range mergeResults(master, results) {
+		ref := serverRef{addr: result.Item.String(), fresh: fresh}
+		var sourced *sourcedError
+		if result.Value != nil {
+			ref.sources = result.Value.Sources
+		} else if errors.As(result.Err, &sourced) {
+			ref.sources = sourced.sources
+		}
+		matched = append(matched, ref)
+	}
+	return matched, nil
+}
+
+// A GraphQL request, as sent by POST or in GET query parameters.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func httpGraphQL(w http.ResponseWriter, r *http.Request) {
+	var request graphqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid variables")
+				return
+			}
+		}
+	case http.MethodPost:
+		body := io.LimitReader(r.Body, kMaxGraphQLRequestSize)
+		if err := json.NewDecoder(body).Decode(&request); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if request.Query == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing query")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), serverLoaderKey{}, newServerLoader())
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        ctx,
+	})
+	if result.HasErrors() {
+		log.Printf("⚠️  GraphQL errors: %v", result.Errors)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,236 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+type graphqlResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func doGraphQL(t *testing.T, query string, variables map[string]interface{}) *graphqlResponse {
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	recorder := httptest.NewRecorder()
+	httpGraphQL(recorder, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response graphqlResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, recorder.Body.String())
+	}
+	return &response
+}
+
+func TestGraphQLSelectsQueries(t *testing.T) {
+	info := a2stest.SourceInfo()
+	info.Players = 1
+	server := a2stest.NewUnstartedServer(info)
+	server.SetPlayers([]*valve.Player{{Name: "alice", Score: 7}})
+	server.SetRules(map[string]string{"sv_gravity": "800"})
+	server.Start()
+	defer server.Close()
+
+	// Only info fields: players and rules aren't queried.
+	response := doGraphQL(t, `query($addr: String!) { server(addr: $addr) { ip name map } }`,
+		map[string]interface{}{"addr": server.Addr()})
+	if len(response.Errors) != 0 {
+		t.Fatal(response.Errors)
+	}
+	var got struct {
+		IP   string `json:"ip"`
+		Name string `json:"name"`
+		Map  string `json:"map"`
+	}
+	json.Unmarshal(response.Data["server"], &got)
+	if got.IP != server.Addr() || got.Name != info.Name || got.Map != "cp_badlands" {
+		t.Fatalf("got %+v", got)
+	}
+	if n := server.Queries(); n != 1 {
+		t.Fatalf("got %d queries, want only A2S_INFO", n)
+	}
+
+	// The same server selected twice is still queried once for each part.
+	response = doGraphQL(t, `query($addr: String!) {
+		a: server(addr: $addr, fresh: true) { name players_online { name } }
+		b: server(addr: $addr, fresh: true) { map players_online { score } rules { name value } }
+	}`, map[string]interface{}{"addr": server.Addr()})
+	if len(response.Errors) != 0 {
+		t.Fatal(response.Errors)
+	}
+	var b struct {
+		Players []struct{ Score int } `json:"players_online"`
+		Rules   []struct{ Name, Value string }
+	}
+	json.Unmarshal(response.Data["b"], &b)
+	if len(b.Players) != 1 || b.Players[0].Score != 7 || len(b.Rules) != 1 || b.Rules[0].Value != "800" {
+		t.Fatalf("got %+v", b)
+	}
+
+	// One A2S_INFO, then a challenge and a request each for players and rules.
+	if n := server.Queries() - 1; n != 5 {
+		t.Fatalf("got %d queries, want 5", n)
+	}
+}
+
+func TestGraphQLSearch(t *testing.T) {
+	source := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: source.Addr(), Name: "Uncletopia | Seattle", Appid: 440},
+		webapitest.Entry{Addr: "127.0.0.1:1", Name: "Uncletopia | Dead", Appid: 440},
+	)
+
+	response := doGraphQL(t, `{ search(appid: 440, name: "Uncletopia*") { ip name error } }`, nil)
+	if len(response.Errors) != 0 {
+		t.Fatal(response.Errors)
+	}
+	var servers []struct {
+		IP    string  `json:"ip"`
+		Name  *string `json:"name"`
+		Error *string `json:"error"`
+	}
+	json.Unmarshal(response.Data["search"], &servers)
+	if len(servers) != 2 {
+		t.Fatalf("got %+v", servers)
+	}
+	for _, server := range servers {
+		switch server.IP {
+		case source.Addr():
+			if server.Name == nil || server.Error != nil {
+				t.Errorf("got %+v", server)
+			}
+		case "127.0.0.1:1":
+			if server.Name != nil || server.Error == nil {
+				t.Errorf("got %+v", server)
+			}
+		default:
+			t.Errorf("unexpected server %s", server.IP)
+		}
+	}
+
+	response = doGraphQL(t, `{ search { ip } }`, nil)
+	if len(response.Errors) != 1 || response.Errors[0].Message != "either appid or addr is required" {
+		t.Fatalf("search without filters: got %+v", response)
+	}
+}
+
+// A source that can't filter has its servers' info queried once, and players
+// only if they're selected.
+func TestGraphQLSearchStaticSource(t *testing.T) {
+	info := a2stest.SourceInfo()
+	info.Name = "Uncletopia | Seattle"
+	info.Players = 1
+	match := newTestGameServer(t, info, []*valve.Player{{Name: "alice", Score: 7}})
+	other := newTestGameServer(t, a2stest.GoldSrcInfo(), nil)
+	setStaticServers(t, match.Addr(), other.Addr())
+
+	response := doGraphQL(t, `{ search(appid: 440, name: "Uncletopia*", source: "static") { ip name } }`, nil)
+	if len(response.Errors) != 0 {
+		t.Fatal(response.Errors)
+	}
+	var servers []struct {
+		IP   string `json:"ip"`
+		Name string `json:"name"`
+	}
+	json.Unmarshal(response.Data["search"], &servers)
+	if len(servers) != 1 || servers[0].IP != match.Addr() || servers[0].Name != info.Name {
+		t.Fatalf("got %+v", servers)
+	}
+	if match.Queries() != 1 || other.Queries() != 1 {
+		t.Fatalf("got %d and %d queries, want only A2S_INFO", match.Queries(), other.Queries())
+	}
+
+	response = doGraphQL(t, `{ search(appid: 440, source: "static", fresh: true) { name players_online { name } } }`, nil)
+	if len(response.Errors) != 0 {
+		t.Fatal(response.Errors)
+	}
+	// A2S_INFO again, then a challenge and a request for players.
+	if n := match.Queries() - 1; n != 3 {
+		t.Fatalf("got %d queries, want 3", n)
+	}
+
+	response = doGraphQL(t, `{ search(appid: 440, source: "nowhere") { ip } }`, nil)
+	if len(response.Errors) != 1 {
+		t.Fatalf("unknown source: got %+v", response)
+	}
+}
+
+// Over merged sources, only the servers no filtering source listed are
+// queried to check them.
+func TestGraphQLSearchMergedSources(t *testing.T) {
+	// The master filtered its server, so it isn't checked against the app ID,
+	// but it's queried all the same to find it again in the static list.
+	listedInfo := a2stest.SourceInfo()
+	listedInfo.Ext.AppId = 730
+	listed := newTestGameServer(t, listedInfo, nil)
+	duplicate := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	otherInfo := a2stest.SourceInfo()
+	otherInfo.Ext.Port = 27016
+	otherInfo.Ext.SteamId++
+	other := newTestGameServer(t, otherInfo, nil)
+	newTestWebAPI(t, webapitest.Entry{Addr: listed.Addr(), Appid: 440})
+	setStaticServers(t, duplicate.Addr(), other.Addr())
+
+	response := doGraphQL(t, `{ search(appid: 440, source: "master,static") { ip sources } }`, nil)
+	if len(response.Errors) != 0 {
+		t.Fatal(response.Errors)
+	}
+	var servers []struct {
+		IP      string
+		Sources []string
+	}
+	json.Unmarshal(response.Data["search"], &servers)
+	want := []string{listed.Addr(), other.Addr()}
+	if len(servers) != 2 || servers[0].IP != want[0] || servers[1].IP != want[1] {
+		t.Fatalf("got %+v, want %v", servers, want)
+	}
+	if !reflect.DeepEqual(servers[0].Sources, []string{kSourceMaster, kSourceStatic}) || !reflect.DeepEqual(servers[1].Sources, []string{kSourceStatic}) {
+		t.Errorf("got %+v", servers)
+	}
+	for _, server := range []*a2stest.Server{listed, duplicate, other} {
+		if queries := server.Queries(); queries != 1 {
+			t.Errorf("%s: got %d queries, want only its A2S_INFO", server.Addr(), queries)
+		}
+	}
+
+	// A single source lists no sources.
+	response = doGraphQL(t, `{ search(appid: 440, source: "static") { ip sources } }`, nil)
+	if got := string(response.Data["search"]); got != `[{"ip":"`+duplicate.Addr()+`","sources":null},{"ip":"`+other.Addr()+`","sources":null}]` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestGraphQLBadRequests(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/graphql", "{", http.StatusBadRequest},
+		{http.MethodPost, "/graphql", "{}", http.StatusBadRequest},
+		{http.MethodGet, "/graphql?query=%7B__typename%7D", "", http.StatusOK},
+		{http.MethodDelete, "/graphql", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		httpGraphQL(recorder, httptest.NewRequest(test.method, test.target, bytes.NewReader([]byte(test.body))))
+		if recorder.Code != test.status {
+			t.Errorf("%s %s %q: got status %d, want %d", test.method, test.target, test.body, recorder.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+)
+
+const (
+	// Maximum size of a group request body.
+	kMaxGroupRequestSize = 64 * 1024
+
+	// Maximum number of servers in one group.
+	kMaxGroupSize = 256
+)
+
+var (
+	errGroupNotFound    = errors.New("group not found")
+	errGroupTooLarge    = errors.New("too many servers in group")
+	errServerNotInGroup = errors.New("server not in group")
+
+	groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+var (
+	// Named groups of servers, managed through /groups.
+	serverGroups = &groupRegistry{groups: map[string][]string{}}
+
+	// Bearer token required to change groups. If empty, they are read-only.
+	groupsToken string
+)
+
+/*
+GroupRequest ...
+*/
+type GroupRequest struct {
+	Servers []string `json:"servers"`
+}
+
+/*
+GroupObject ...
+*/
+type GroupObject struct {
+	Name    string   `json:"name"`
+	Servers []string `json:"servers"`
+}
+
+// A groupRegistry holds server groups, saved to a JSON file on every change.
+type groupRegistry struct {
+	mu     sync.Mutex
+	path   string // Not saved if empty.
+	groups map[string][]string
+}
+
+// openGroupRegistry loads groups from a file. A missing file is an empty
+// registry.
+func openGroupRegistry(path string) (*groupRegistry, error) {
+	registry := &groupRegistry{path: path, groups: map[string][]string{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &registry.groups); err != nil {
+		return nil, err
+	}
+	if registry.groups == nil {
+		registry.groups = map[string][]string{}
+	}
+	return registry, nil
+}
+
+// loadGroupsConfig opens the group registry and reads its token from the
+// environment.
+func loadGroupsConfig() {
+	groupsToken = envString("GROUPS_API_TOKEN", "")
+
+	path := envString("GROUPS_FILE", "groups.json")
+	registry, err := openGroupRegistry(path)
+	if err != nil {
+		log.Fatalf("Cannot start service: failed to load server groups from %s: %s", path, err.Error())
+	}
+	serverGroups = registry
+
+	log.Printf("✓ %d server group(s) loaded from %s", len(registry.groups), path)
+	if groupsToken == "" {
+		log.Printf("⚠️  GROUPS_API_TOKEN is empty, server groups are read-only")
+	}
+}
+
+// list returns every group, sorted by name.
+func (gr *groupRegistry) list() []*GroupObject {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	out := make([]*GroupObject, 0, len(gr.groups))
+	for name, servers := range gr.groups {
+		out = append(out, &GroupObject{Name: name, Servers: slices.Clone(servers)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (gr *groupRegistry) get(name string) ([]string, bool) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	servers, ok := gr.groups[name]
+	return slices.Clone(servers), ok
+}
+
+// update applies a change to one group and saves the registry. The change
+// gets the group's servers, or nil if it doesn't exist, and returns the new
+// list, or nil to delete the group. Nothing changes if saving fails.
+func (gr *groupRegistry) update(name string, change func(servers []string, ok bool) ([]string, error)) ([]string, error) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	servers, ok := gr.groups[name]
+	servers, err := change(slices.Clone(servers), ok)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) > kMaxGroupSize {
+		return nil, errGroupTooLarge
+	}
+
+	next := maps.Clone(gr.groups)
+	if servers == nil {
+		delete(next, name)
+	} else {
+		next[name] = servers
+	}
+	if err := gr.save(next); err != nil {
+		return nil, err
+	}
+	gr.groups = next
+	return slices.Clone(servers), nil
+}
+
+// save writes groups to the registry's file, replacing it atomically.
+func (gr *groupRegistry) save(groups map[string][]string) error {
+	if gr.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(groups, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(gr.path), filepath.Base(gr.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), gr.path)
+}
+
+// normalizeGroupServers resolves server addresses and drops duplicates.
+// Hostnames are resolved once, when they're added to a group.
+func normalizeGroupServers(servers []string) ([]string, error) {
+	var out []string
+	for _, server := range servers {
+		addr, err := resolveServerAddr(strings.TrimSpace(server))
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a server address", server)
+		}
+		if !slices.Contains(out, addr.String()) {
+			out = append(out, addr.String())
+		}
+	}
+	return out, nil
+}
+
+// writeGroupError writes a failed group change.
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errGroupNotFound):
+		writeJSONError(w, http.StatusNotFound, "Group not found")
+	case errors.Is(err, errServerNotInGroup):
+		writeJSONError(w, http.StatusNotFound, "Server not in group")
+	case errors.Is(err, errGroupTooLarge):
+		writeJSONError(w, http.StatusBadRequest, "Too many servers in group")
+	default:
+		log.Printf("⚠️  Failed to save server groups: %s", err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Failed to save server groups")
+	}
+}
+
+func writeGroup(w http.ResponseWriter, statusCode int, group *GroupObject) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(group)
+}
+
+// httpGroups serves /groups and everything under it:
+//
+//	GET    /groups                         list groups
+//	GET    /groups/{name}                  query every server in a group
+//	PUT    /groups/{name}                  create or replace a group
+//	DELETE /groups/{name}                  delete a group
+//	POST   /groups/{name}/servers          add servers to a group
+//	DELETE /groups/{name}/servers/{addr}   remove a server from a group
+func httpGroups(w http.ResponseWriter, r *http.Request) {
+	var segments []string
+	if rest := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/groups"), "/"); rest != "" {
+		segments = strings.Split(rest, "/")
+	}
+	for i, segment := range segments {
+		segments[i], _ = url.PathUnescape(segment)
+	}
+
+	var allowed []string
+	switch {
+	case len(segments) == 0:
+		allowed = []string{http.MethodGet}
+	case len(segments) == 1:
+		allowed = []string{http.MethodGet, http.MethodPut, http.MethodDelete}
+	case len(segments) == 2 && segments[1] == "servers":
+		allowed = []string{http.MethodPost}
+	case len(segments) == 3 && segments[1] == "servers":
+		allowed = []string{http.MethodDelete}
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if !slices.Contains(allowed, r.Method) {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Reads need the API token like any query; changes need the groups token.
+	if r.Method == http.MethodGet {
+		if !isAPIAuthorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+	} else {
+		if groupsToken == "" {
+			writeJSONError(w, http.StatusForbidden, "Server groups are read-only")
+			return
+		}
+		if !bearerTokenMatches(r.Header.Get("Authorization"), groupsToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+	}
+
+	if len(segments) == 0 {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{"groups": serverGroups.list()})
+		return
+	}
+
+	name := segments[0]
+	if !groupNamePattern.MatchString(name) {
+		writeJSONError(w, http.StatusBadRequest, "Group names may only contain letters, digits, '.', '_' and '-'")
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		httpQueryGroup(w, r, name)
+	case len(segments) == 1 && r.Method == http.MethodPut:
+		httpPutGroup(w, r, name)
+	case len(segments) == 1:
+		_, err := serverGroups.update(name, func(servers []string, ok bool) ([]string, error) {
+			if !ok {
+				return nil, errGroupNotFound
+			}
+			return nil, nil
+		})
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		log.Printf("Deleted server group %s", name)
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 2:
+		httpAddGroupServers(w, r, name)
+	default:
+		httpRemoveGroupServer(w, name, segments[2])
+	}
+}
+
+// readGroupRequest decodes a request body listing servers.
+func readGroupRequest(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var request GroupRequest
+	body := io.LimitReader(r.Body, kMaxGroupRequestSize)
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	servers, err := normalizeGroupServers(request.Servers)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid servers: "+err.Error())
+		return nil, false
+	}
+	return servers, true
+}
+
+func httpPutGroup(w http.ResponseWriter, r *http.Request, name string) {
+	servers, ok := readGroupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	created := false
+	servers, err := serverGroups.update(name, func(_ []string, ok bool) ([]string, error) {
+		created = !ok
+		if servers == nil {
+			return []string{}, nil
+		}
+		return servers, nil
+	})
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	log.Printf("Saved server group %s (%d server(s))", name, len(servers))
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusCreated
+	}
+	writeGroup(w, statusCode, &GroupObject{Name: name, Servers: servers})
+}
+
+func httpAddGroupServers(w http.ResponseWriter, r *http.Request, name string) {
+	added, ok := readGroupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	servers, err := serverGroups.update(name, func(servers []string, ok bool) ([]string, error) {
+		if !ok {
+			return nil, errGroupNotFound
+		}
+		for _, server := range added {
+			if !slices.Contains(servers, server) {
+				servers = append(servers, server)
+			}
+		}
+		return servers, nil
+	})
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	writeGroup(w, http.StatusOK, &GroupObject{Name: name, Servers: servers})
+}
+
+func httpRemoveGroupServer(w http.ResponseWriter, name string, server string) {
+	if addr, err := resolveServerAddr(server); err == nil {
+		server = addr.String()
+	}
+	servers, err := serverGroups.update(name, func(servers []string, ok bool) ([]string, error) {
+		if !ok {
+			return nil, errGroupNotFound
+		}
+		i := slices.Index(servers, server)
+		if i < 0 {
+			return nil, errServerNotInGroup
+		}
+		return slices.Delete(servers, i, i+1), nil
+	})
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	writeGroup(w, http.StatusOK, &GroupObject{Name: name, Servers: servers})
+}
+
+// httpQueryGroup queries every server in a group directly, whether or not
+// it's on the master server, and answers like /search.
+func httpQueryGroup(w http.ResponseWriter, r *http.Request, name string) {
+	format, err := requestFormat(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid export: "+err.Error())
+		return
+	}
+
+	servers, ok := serverGroups.get(name)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+
+	addrs := make([]*net.TCPAddr, 0, len(servers))
+	for _, server := range servers {
+		addr, err := net.ResolveTCPAddr("tcp", server)
+		if err != nil {
+			log.Printf("⚠️  Invalid address in server group %s: %s", name, server)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+
+	results := queryAddrs(addrs, queryOptions{
+		Priority: batch.PriorityInteractive,
+		Fresh:    isFresh(r),
+	})
+	format.write(w, results)
+}
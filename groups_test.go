@@ -0,0 +1,183 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+)
+
+func newTestGroups(t *testing.T, token string) string {
+	path := filepath.Join(t.TempDir(), "groups.json")
+	registry, err := openGroupRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldGroups, oldToken := serverGroups, groupsToken
+	serverGroups, groupsToken = registry, token
+	t.Cleanup(func() { serverGroups, groupsToken = oldGroups, oldToken })
+	return path
+}
+
+func doGroupRequest(t *testing.T, method, target, token, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	httpGroups(recorder, request)
+	return recorder
+}
+
+func decodeGroup(t *testing.T, recorder *httptest.ResponseRecorder, status int) *GroupObject {
+	if recorder.Code != status {
+		t.Fatalf("got status %d, want %d: %s", recorder.Code, status, recorder.Body.String())
+	}
+	var group GroupObject
+	if err := json.Unmarshal(recorder.Body.Bytes(), &group); err != nil {
+		t.Fatal(err)
+	}
+	return &group
+}
+
+func TestGroupsCRUD(t *testing.T) {
+	path := newTestGroups(t, "secret")
+
+	group := decodeGroup(t, doGroupRequest(t, http.MethodPut, "/groups/community", "secret",
+		`{"servers": ["127.0.0.1:27015", "127.0.0.1", "127.0.0.1:27016"]}`), http.StatusCreated)
+	want := []string{"127.0.0.1:27015", "127.0.0.1:27016"}
+	if group.Name != "community" || !reflect.DeepEqual(group.Servers, want) {
+		t.Fatalf("got %+v", group)
+	}
+
+	group = decodeGroup(t, doGroupRequest(t, http.MethodPost, "/groups/community/servers", "secret",
+		`{"servers": ["127.0.0.1:27016", "127.0.0.1:27017"]}`), http.StatusOK)
+	want = append(want, "127.0.0.1:27017")
+	if !reflect.DeepEqual(group.Servers, want) {
+		t.Fatalf("got %+v", group)
+	}
+
+	group = decodeGroup(t, doGroupRequest(t, http.MethodDelete, "/groups/community/servers/127.0.0.1", "secret", ""), http.StatusOK)
+	want = want[1:]
+	if !reflect.DeepEqual(group.Servers, want) {
+		t.Fatalf("got %+v", group)
+	}
+
+	// Changes are saved as they're made.
+	registry, err := openGroupRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers, _ := registry.get("community"); !reflect.DeepEqual(servers, want) {
+		t.Fatalf("saved %v", servers)
+	}
+
+	recorder := doGroupRequest(t, http.MethodGet, "/groups", "", "")
+	var list struct{ Groups []*GroupObject }
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Groups) != 1 || list.Groups[0].Name != "community" {
+		t.Fatalf("got %s", recorder.Body.String())
+	}
+
+	if recorder := doGroupRequest(t, http.MethodDelete, "/groups/community", "secret", ""); recorder.Code != http.StatusNoContent {
+		t.Fatalf("got status %d", recorder.Code)
+	}
+	if recorder := doGroupRequest(t, http.MethodGet, "/groups/community", "", ""); recorder.Code != http.StatusNotFound {
+		t.Fatalf("got status %d", recorder.Code)
+	}
+}
+
+func TestGroupsErrors(t *testing.T) {
+	newTestGroups(t, "secret")
+	serverGroups.update("community", func([]string, bool) ([]string, error) {
+		return []string{"127.0.0.1:27015"}, nil
+	})
+
+	tests := []struct {
+		method, target, token, body string
+		status                      int
+	}{
+		{http.MethodPut, "/groups/new", "", `{"servers": []}`, http.StatusUnauthorized},
+		{http.MethodPut, "/groups/new", "wrong", `{"servers": []}`, http.StatusUnauthorized},
+		{http.MethodPut, "/groups/bad%20name", "secret", `{"servers": []}`, http.StatusBadRequest},
+		{http.MethodPut, "/groups/new", "secret", `{"servers": ["not an address:x"]}`, http.StatusBadRequest},
+		{http.MethodPut, "/groups/new", "secret", `{"servers":`, http.StatusBadRequest},
+		{http.MethodPost, "/groups/missing/servers", "secret", `{"servers": []}`, http.StatusNotFound},
+		{http.MethodDelete, "/groups/community/servers/127.0.0.1:1", "secret", "", http.StatusNotFound},
+		{http.MethodDelete, "/groups/missing", "secret", "", http.StatusNotFound},
+		{http.MethodPost, "/groups/community", "secret", "", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/groups", "secret", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/groups/community/players", "", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		recorder := doGroupRequest(t, test.method, test.target, test.token, test.body)
+		if recorder.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d: %s", test.method, test.target, recorder.Code, test.status, recorder.Body.String())
+		}
+	}
+
+	messages := map[string]string{
+		`{"servers": ["not an address:x"]}`: `"error":"Invalid servers: \"not an address:x\" isn't a server address"`,
+		`{"servers": ["127.0.0.1:1"]}`:      `"error":"Group not found"`,
+	}
+	for body, want := range messages {
+		recorder := doGroupRequest(t, http.MethodPost, "/groups/missing/servers", "secret", body)
+		if got := recorder.Body.String(); !strings.Contains(got, want) {
+			t.Errorf("%s: got %s", body, got)
+		}
+	}
+
+	var big []string
+	for port := 1; port <= kMaxGroupSize+1; port++ {
+		big = append(big, "127.0.0.1:"+strconv.Itoa(10000+port))
+	}
+	body, _ := json.Marshal(&GroupRequest{Servers: big})
+	if recorder := doGroupRequest(t, http.MethodPut, "/groups/big", "secret", string(body)); recorder.Code != http.StatusBadRequest {
+		t.Errorf("oversized group: got status %d", recorder.Code)
+	}
+
+	// Without a token, groups can't be changed at all.
+	groupsToken = ""
+	if recorder := doGroupRequest(t, http.MethodDelete, "/groups/community", "", ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("read-only: got status %d", recorder.Code)
+	}
+}
+
+func TestGroupQuery(t *testing.T) {
+	newTestGroups(t, "secret")
+
+	// Neither server is on the master server; groups query them directly.
+	players := []*valve.Player{{Name: "alice", Score: 10, Duration: 60}}
+	info := a2stest.SourceInfo()
+	info.Players = 1
+	source := newTestGameServer(t, info, players)
+	down := "127.0.0.1:1"
+
+	decodeGroup(t, doGroupRequest(t, http.MethodPut, "/groups/community", "secret",
+		`{"servers": ["`+source.Addr()+`", "`+down+`"]}`), http.StatusCreated)
+
+	response := decodeSearch(t, doRequest(t, httpGroups, "/groups/community"))
+	if response.Total != 2 {
+		t.Fatalf("got %d servers", response.Total)
+	}
+	server := decodeServer(t, response, source.Addr())
+	if server.Name != info.Name || len(server.PlayersOnline) != 1 || server.PlayersOnline[0].Name != "alice" {
+		t.Errorf("got %+v", server)
+	}
+
+	var failed ErrorObject
+	if err := json.Unmarshal(response.Data[0][down], &failed); err != nil || failed.Error == "" {
+		t.Errorf("got %s", response.Data[0][down])
+	}
+}
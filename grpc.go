@@ -0,0 +1,316 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	rpc "github.com/cyxc1124/Mastersteam/rpc"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// The gRPC API. It runs the same queries as the HTTP API, through the same
+// pool, sockets and reply cache.
+type grpcServer struct {
+	rpc.UnimplementedMastersteamServer
+}
+
+func newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(grpcStreamInterceptor),
+	)
+	rpc.RegisterMastersteamServer(server, &grpcServer{})
+	return server
+}
+
+// serveGRPC runs the gRPC API until it fails.
+func serveGRPC(port int) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		log.Printf("⚠️  Failed to start the gRPC server: %s", err.Error())
+		return
+	}
+	log.Print(newGRPCServer().Serve(listener))
+}
+
+// grpcAuthorize counts a call and checks its API token, like the HTTP API
+// does.
+func grpcAuthorize(ctx context.Context, fullMethod string) error {
+	apiRequests.Add("grpc "+path.Base(fullMethod), 1)
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	if !isAPIAuthorized(header) {
+		return status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return nil
+}
+
+func grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := grpcAuthorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcAuthorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// masterStatus converts a server list failure, with the same message and
+// meaning as the HTTP API's error response.
+func masterStatus(err error) error {
+	statusCode, message := describeQueryError(err)
+	code := codes.Internal
+	switch statusCode {
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, message)
+}
+
+// serverStatus converts a game server's query failure.
+func serverStatus(hostAndPort string, err error) error {
+	message := newErrorObject(hostAndPort, err).Error
+	if valve.IsTimeout(err) {
+		return status.Error(codes.DeadlineExceeded, message)
+	}
+	return status.Error(codes.Unavailable, message)
+}
+
+func serverInfoMessage(server *ServerObject) *rpc.ServerInfo {
+	out := &rpc.ServerInfo{
+		Ip:          server.Address,
+		Protocol:    uint32(server.Protocol),
+		Name:        server.Name,
+		Map:         server.MapName,
+		Folder:      server.Folder,
+		Game:        server.Game,
+		Players:     uint32(server.Players),
+		MaxPlayers:  uint32(server.MaxPlayers),
+		Bots:        uint32(server.Bots),
+		Type:        server.Type,
+		Os:          server.Os,
+		Visibility:  server.Visibility,
+		Vac:         server.Vac,
+		Appid:       uint32(server.AppID),
+		GameVersion: server.GameVersion,
+		Port:        uint32(server.Port),
+		Steamid:     server.SteamID,
+		GameMode:    server.GameMode,
+		Gameid:      server.GameID,
+		Sources:     server.Sources,
+	}
+	out.PlayersOnline = playerMessages(server.PlayersOnline)
+	if server.CachedAt != nil {
+		out.CachedAt = timestamppb.New(*server.CachedAt)
+	}
+	return out
+}
+
+func playerMessages(players []*valve.Player) []*rpc.Player {
+	out := make([]*rpc.Player, 0, len(players))
+	for _, player := range players {
+		out = append(out, &rpc.Player{
+			Name:     player.Name,
+			Score:    player.Score,
+			Duration: player.Duration,
+		})
+	}
+	return out
+}
+
+// resolveRequestAddr resolves the address of a Get request.
+func resolveRequestAddr(addr string) (*net.TCPAddr, error) {
+	resolved, err := resolveServerAddr(addr)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid server address")
+	}
+	return resolved, nil
+}
+
+func (s *grpcServer) SearchServers(req *rpc.SearchServersRequest, stream grpc.ServerStreamingServer[rpc.ServerInfo]) error {
+	if req.Appid == 0 && req.Addr == "" {
+		return status.Error(codes.InvalidArgument, "Either appid or addr is required")
+	}
+
+	sources, err := parseSources(req.Source)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Invalid source: "+err.Error())
+	}
+	master, err := openSources(sources)
+	if err != nil {
+		return masterStatus(err)
+	}
+	defer master.Close()
+	if req.Appid != 0 {
+		master.FilterAppId(valve.AppId(req.Appid))
+	}
+	if req.Name != "" {
+		master.FilterName(req.Name)
+	}
+	if req.Addr != "" {
+		master.FilterGameaddr(req.Addr)
+	}
+
+	// Stream servers as they answer, rather than in list order. A search
+	// merged from several sources has to hear from every server before it
+	// can drop duplicates, so its servers are sent once the last one answers.
+	_, merged := master.(*valve.MultiMasterQuerier)
+	var held []batch.Result[*net.TCPAddr, *ServerObject]
+	bp, errc := startServerQueries(master, queryOptions{
+		Priority: batch.PriorityBulk,
+		Fresh:    req.Fresh,
+	}, false)
+
+	ctx := stream.Context()
+	results := bp.Results()
+	for results != nil {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			if errors.Is(result.Err, errFilteredOut) {
+				continue
+			}
+			if merged {
+				held = append(held, result)
+				continue
+			}
+			if err := stream.Send(searchMessage(result)); err != nil {
+				bp.Terminate()
+				return err
+			}
+		case <-ctx.Done():
+			bp.Terminate()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	if err := <-errc; err != nil {
+		return masterStatus(err)
+	}
+	for _, result := range mergeResults(master, held) {
+		if err := stream.Send(searchMessage(result)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchMessage converts one SearchServers result.
+func searchMessage(result batch.Result[*net.TCPAddr, *ServerObject]) *rpc.ServerInfo {
+	if result.Err == nil {
+		return serverInfoMessage(result.Value)
+	}
+	failed := newErrorObject(result.Item.String(), result.Err)
+	return &rpc.ServerInfo{
+		Ip:      result.Item.String(),
+		Error:   failed.Error,
+		Sources: failed.Sources,
+	}
+}
+
+func (s *grpcServer) GetServer(ctx context.Context, req *rpc.GetServerRequest) (*rpc.ServerInfo, error) {
+	addr, err := resolveRequestAddr(req.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := onQueryPool(batch.PriorityInteractive, func() (*ServerObject, error) {
+		return queryServer(addr, nil, queryOptions{
+			Priority: batch.PriorityInteractive,
+			Fresh:    req.Fresh,
+		})
+	})
+	if err != nil {
+		return nil, serverStatus(addr.String(), err)
+	}
+	return serverInfoMessage(server), nil
+}
+
+func (s *grpcServer) GetPlayers(ctx context.Context, req *rpc.GetServerRequest) (*rpc.GetPlayersResponse, error) {
+	addr, err := resolveRequestAddr(req.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &serverQuery{
+		addr: addr.String(),
+		opts: queryOptions{Priority: batch.PriorityInteractive, Fresh: req.Fresh},
+	}
+	defer query.close()
+
+	players, err := onQueryPool(batch.PriorityInteractive, func() ([]*valve.Player, error) {
+		info, err := query.info()
+		if err != nil || info.Players == 0 {
+			return nil, err
+		}
+		return query.players(info)
+	})
+	if err != nil {
+		return nil, serverStatus(addr.String(), err)
+	}
+
+	out := &rpc.GetPlayersResponse{Ip: addr.String(), Players: playerMessages(players)}
+	if !query.cachedAt.IsZero() {
+		out.CachedAt = timestamppb.New(query.cachedAt)
+	}
+	return out, nil
+}
+
+func (s *grpcServer) GetRules(ctx context.Context, req *rpc.GetServerRequest) (*rpc.GetRulesResponse, error) {
+	addr, err := resolveRequestAddr(req.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &serverQuery{
+		addr: addr.String(),
+		opts: queryOptions{Priority: batch.PriorityInteractive, Fresh: req.Fresh},
+	}
+	defer query.close()
+
+	rules, err := onQueryPool(batch.PriorityInteractive, func() (map[string]string, error) {
+		info, err := query.info()
+		if err != nil {
+			return nil, err
+		}
+		return query.rules(info)
+	})
+	if err != nil {
+		return nil, serverStatus(addr.String(), err)
+	}
+
+	out := &rpc.GetRulesResponse{Ip: addr.String(), Rules: rules}
+	if !query.cachedAt.IsZero() {
+		out.CachedAt = timestamppb.New(query.cachedAt)
+	}
+	return out, nil
+}
@@ -0,0 +1,255 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	rpc "github.com/cyxc1124/Mastersteam/rpc"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+func newTestGRPCClient(t *testing.T) rpc.MastersteamClient {
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewMastersteamClient(conn)
+}
+
+func TestGRPCGetServer(t *testing.T) {
+	info := a2stest.SourceInfo()
+	info.Players = 1
+	server := a2stest.NewUnstartedServer(info)
+	server.SetPlayers([]*valve.Player{{Name: "alice", Score: 7}})
+	server.SetRules(map[string]string{"sv_gravity": "800"})
+	server.Start()
+	defer server.Close()
+
+	client := newTestGRPCClient(t)
+	ctx := context.Background()
+
+	got, err := client.GetServer(ctx, &rpc.GetServerRequest{Addr: server.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Ip != server.Addr() || got.Map != "cp_badlands" || got.Appid != uint32(valve.App_TF2) ||
+		len(got.PlayersOnline) != 1 || got.PlayersOnline[0].Name != "alice" {
+		t.Fatalf("got %v", got)
+	}
+
+	players, err := client.GetPlayers(ctx, &rpc.GetServerRequest{Addr: server.Addr()})
+	if err != nil || len(players.Players) != 1 || players.Players[0].Score != 7 {
+		t.Fatalf("got %v, %v", players, err)
+	}
+
+	rules, err := client.GetRules(ctx, &rpc.GetServerRequest{Addr: server.Addr()})
+	if err != nil || rules.Rules["sv_gravity"] != "800" {
+		t.Fatalf("got %v, %v", rules, err)
+	}
+
+	_, err = client.GetServer(ctx, &rpc.GetServerRequest{Addr: "not an address:x"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("bad address: got %v", err)
+	}
+}
+
+// Unary calls take a slot in the shared query pool like searches do.
+func TestGRPCUsesQueryPool(t *testing.T) {
+	server := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	oldPool := queryPool
+	queryPool = batch.NewPool(1)
+	t.Cleanup(func() {
+		queryPool.Close()
+		queryPool = oldPool
+	})
+
+	// Hold the only worker.
+	release := make(chan struct{})
+	blocker := batch.NewProcessor(func(struct{}) (struct{}, error) {
+		<-release
+		return struct{}{}, nil
+	}, batch.Options{Pool: queryPool})
+	blocker.Add(struct{}{})
+	blocker.Close()
+
+	client := newTestGRPCClient(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetServer(context.Background(), &rpc.GetServerRequest{Addr: server.Addr(), Fresh: true})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("query ran while the pool was full: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if server.Queries() != 0 {
+		t.Fatalf("got %d queries while the pool was full", server.Queries())
+	}
+
+	close(release)
+	blocker.Collect()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCSearchServers(t *testing.T) {
+	source := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: source.Addr(), Appid: 440},
+		webapitest.Entry{Addr: "127.0.0.1:1", Appid: 440},
+	)
+
+	client := newTestGRPCClient(t)
+	stream, err := client.SearchServers(context.Background(), &rpc.SearchServersRequest{Appid: 440})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]*rpc.ServerInfo{}
+	for {
+		server, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[server.Ip] = server
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %v", got)
+	}
+	if server := got[source.Addr()]; server == nil || server.Name == "" || server.Error != "" {
+		t.Errorf("got %v", server)
+	}
+	if server := got["127.0.0.1:1"]; server == nil || server.Error == "" {
+		t.Errorf("got %v", server)
+	}
+
+	stream, err = client.SearchServers(context.Background(), &rpc.SearchServersRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("search without filters: got %v", err)
+	}
+}
+
+// Search and collect the streamed servers by address.
+func searchServers(t *testing.T, client rpc.MastersteamClient, req *rpc.SearchServersRequest) (map[string]*rpc.ServerInfo, error) {
+	stream, err := client.SearchServers(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	got := map[string]*rpc.ServerInfo{}
+	for {
+		server, err := stream.Recv()
+		if err == io.EOF {
+			return got, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if got[server.Ip] != nil {
+			t.Errorf("%s was sent twice", server.Ip)
+		}
+		got[server.Ip] = server
+	}
+}
+
+func TestGRPCSearchServersSource(t *testing.T) {
+	listed := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	static := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	newTestWebAPI(t, webapitest.Entry{Addr: listed.Addr(), Appid: 440})
+	setStaticServers(t, static.Addr())
+
+	client := newTestGRPCClient(t)
+	got, err := searchServers(t, client, &rpc.SearchServersRequest{Appid: 440, Source: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[static.Addr()] == nil {
+		t.Fatalf("got %v", got)
+	}
+
+	if _, err := searchServers(t, client, &rpc.SearchServersRequest{Appid: 440, Source: "nowhere"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("unknown source: got %v", err)
+	}
+}
+
+func TestGRPCSearchServersMerged(t *testing.T) {
+	// The static list has the master's server under another address.
+	listed := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	duplicate := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	otherInfo := a2stest.SourceInfo()
+	otherInfo.Ext.Port = 27016
+	otherInfo.Ext.SteamId++
+	other := newTestGameServer(t, otherInfo, nil)
+	newTestWebAPI(t, webapitest.Entry{Addr: listed.Addr(), Appid: 440})
+	setStaticServers(t, duplicate.Addr(), other.Addr(), "127.0.0.1:1")
+
+	client := newTestGRPCClient(t)
+	got, err := searchServers(t, client, &rpc.SearchServersRequest{Appid: 440, Source: "master,static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[duplicate.Addr()] != nil {
+		t.Fatalf("got %v", got)
+	}
+	if sources := got[listed.Addr()].Sources; !reflect.DeepEqual(sources, []string{kSourceMaster, kSourceStatic}) {
+		t.Errorf("got sources %v", sources)
+	}
+	if sources := got[other.Addr()].Sources; !reflect.DeepEqual(sources, []string{kSourceStatic}) {
+		t.Errorf("got sources %v", sources)
+	}
+	if dead := got["127.0.0.1:1"]; dead.Error == "" || !reflect.DeepEqual(dead.Sources, []string{kSourceStatic}) {
+		t.Errorf("got %v", dead)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	apiToken = "secret"
+	defer func() { apiToken = "" }()
+
+	server := a2stest.NewServer(a2stest.SourceInfo())
+	defer server.Close()
+	client := newTestGRPCClient(t)
+
+	request := &rpc.GetServerRequest{Addr: server.Addr()}
+	if _, err := client.GetServer(context.Background(), request); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("without a token: got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	if _, err := client.GetServer(ctx, request); err != nil {
+		t.Fatalf("with the token: got %v", err)
+	}
+}
@@ -0,0 +1,442 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+const (
+	// Messages a subscriber may fall behind by. Past this, its backlog is
+	// replaced with one snapshot of the current state.
+	kLiveMaxBacklog = 16
+
+	// WebSocket heartbeats: a ping every kLivePingInterval, and the
+	// connection is dropped if nothing (pongs included) arrives within
+	// kLivePongWait.
+	kLivePingInterval = 30 * time.Second
+	kLivePongWait     = 60 * time.Second
+
+	// Time allowed to write one message, so a client that stops reading
+	// can't hold a connection open forever.
+	kLiveWriteWait = 10 * time.Second
+
+	// Browsers can't set an Authorization header on a WebSocket handshake,
+	// so they offer this subprotocol followed by the API token instead:
+	// new WebSocket(url, ["bearer", token]).
+	kLiveTokenProtocol = "bearer"
+)
+
+var (
+	// How often a watched server is polled.
+	livePollInterval = 5 * time.Second
+
+	// Origins allowed to open WebSockets, besides the API's own. "*" allows
+	// any.
+	liveAllowedOrigins []string
+
+	// Servers that may be watched at once, each with its own poller. 0
+	// means no limit.
+	liveMaxServers = 100
+
+	// Servers being watched, shared by all subscribers.
+	liveServers = &liveHub{servers: map[string]*liveServer{}}
+
+	errLiveFull = errors.New("too many servers watched")
+)
+
+// A message pushed to subscribers. A snapshot carries the whole state. It's
+// sent when subscribing, when the server goes down or comes back, and when a
+// subscriber has fallen too far behind. Otherwise, a diff carries what changed
+// since the previous message.
+type liveMessage struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Snapshots only. Server is set if the server answered, Error otherwise.
+	Server *ServerObject `json:"server,omitempty"`
+	Error  string        `json:"error,omitempty"`
+
+	// Diffs only. Changes holds the ServerObject fields that changed, with
+	// their new values; a null value means the field was removed. Players
+	// are matched by name.
+	Changes        map[string]interface{} `json:"changes,omitempty"`
+	PlayersJoined  []*valve.Player        `json:"players_joined,omitempty"`
+	PlayersLeft    []*valve.Player        `json:"players_left,omitempty"`
+	PlayersUpdated []*valve.Player        `json:"players_updated,omitempty"`
+}
+
+// The outcome of one poll.
+type liveState struct {
+	server *ServerObject
+	err    string
+	at     time.Time
+}
+
+func (state *liveState) snapshot() *liveMessage {
+	return &liveMessage{
+		Type:   "snapshot",
+		Time:   state.at,
+		Server: state.server,
+		Error:  state.err,
+	}
+}
+
+// A liveHub polls each watched server once, however many subscribers it has.
+type liveHub struct {
+	mu      sync.Mutex
+	servers map[string]*liveServer
+
+	subscribers atomic.Int64
+	resyncs     atomic.Uint64
+}
+
+// A watched server.
+type liveServer struct {
+	hub         *liveHub
+	addr        string
+	subscribers map[*liveSubscriber]struct{}
+	state       *liveState // Nil until the first poll finishes.
+	stop        chan struct{}
+}
+
+// One subscriber's queue of messages.
+type liveSubscriber struct {
+	server *liveServer
+
+	mu      sync.Mutex
+	backlog []*liveMessage
+
+	// Signaled when the backlog becomes non-empty.
+	ready chan struct{}
+}
+
+// Subscribe to a server, starting to poll it if nobody else is. This fails
+// with errLiveFull if liveMaxServers are already being polled.
+func (hub *liveHub) subscribe(addr string) (*liveSubscriber, error) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	server, ok := hub.servers[addr]
+	if !ok {
+		if liveMaxServers > 0 && len(hub.servers) >= liveMaxServers {
+			return nil, errLiveFull
+		}
+		server = &liveServer{
+			hub:         hub,
+			addr:        addr,
+			subscribers: map[*liveSubscriber]struct{}{},
+			stop:        make(chan struct{}),
+		}
+		hub.servers[addr] = server
+		go server.poll()
+	}
+
+	sub := &liveSubscriber{server: server, ready: make(chan struct{}, 1)}
+	server.subscribers[sub] = struct{}{}
+	hub.subscribers.Add(1)
+	if server.state != nil {
+		hub.push(sub, server.state.snapshot())
+	}
+	return sub, nil
+}
+
+// Unsubscribe, and stop polling the server if that was the last subscriber.
+func (hub *liveHub) unsubscribe(sub *liveSubscriber) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	server := sub.server
+	delete(server.subscribers, sub)
+	hub.subscribers.Add(-1)
+	if len(server.subscribers) == 0 {
+		close(server.stop)
+		delete(hub.servers, server.addr)
+	}
+}
+
+// Queue a message for a subscriber. A subscriber that is too far behind gets
+// its backlog replaced with a snapshot. The caller holds mu.
+func (hub *liveHub) push(sub *liveSubscriber, message *liveMessage) {
+	sub.mu.Lock()
+	if len(sub.backlog) >= kLiveMaxBacklog {
+		sub.backlog = []*liveMessage{sub.server.state.snapshot()}
+		hub.resyncs.Add(1)
+	} else {
+		sub.backlog = append(sub.backlog, message)
+	}
+	sub.mu.Unlock()
+
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Take every queued message.
+func (sub *liveSubscriber) take() []*liveMessage {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	backlog := sub.backlog
+	sub.backlog = nil
+	return backlog
+}
+
+// Poll the server until the last subscriber leaves.
+func (server *liveServer) poll() {
+	addr, err := resolveServerAddr(server.addr)
+	if err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(livePollInterval)
+	defer ticker.Stop()
+	for {
+		state := &liveState{at: time.Now()}
+		// Polls bypass the cache, but refresh it for everyone else.
+		result, err := onQueryPool(batch.PriorityInteractive, func() (*ServerObject, error) {
+			return queryServer(addr, nil, queryOptions{
+				Priority: batch.PriorityInteractive,
+				Fresh:    true,
+			})
+		})
+		if err != nil {
+			state.err = newErrorObject(server.addr, err).Error
+		} else {
+			state.server = result
+		}
+		server.publish(state)
+
+		select {
+		case <-ticker.C:
+		case <-server.stop:
+			return
+		}
+	}
+}
+
+// Record a poll and push what changed to every subscriber.
+func (server *liveServer) publish(state *liveState) {
+	hub := server.hub
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	previous := server.state
+	server.state = state
+
+	var message *liveMessage
+	if previous == nil || previous.server == nil || state.server == nil {
+		if previous != nil && previous.err == state.err && state.server == nil {
+			return
+		}
+		message = state.snapshot()
+	} else {
+		message = diffServers(previous.server, state.server)
+		if message == nil {
+			return
+		}
+		message.Time = state.at
+	}
+
+	for sub := range server.subscribers {
+		hub.push(sub, message)
+	}
+}
+
+// Fields left out of diffs: players are diffed separately, and cached_at is
+// always empty for polls.
+var liveIgnoredFields = map[string]bool{
+	"players_online": true,
+	"cached_at":      true,
+}
+
+// diffServers describes what changed between two replies, or returns nil if
+// nothing did.
+func diffServers(old, new *ServerObject) *liveMessage {
+	message := &liveMessage{Type: "diff", Changes: map[string]interface{}{}}
+
+	oldFields, newFields := jsonFieldMap(old), jsonFieldMap(new)
+	for name, value := range newFields {
+		if !liveIgnoredFields[name] && !reflect.DeepEqual(oldFields[name], value) {
+			message.Changes[name] = value
+		}
+	}
+	for name := range oldFields {
+		if _, ok := newFields[name]; !ok && !liveIgnoredFields[name] {
+			message.Changes[name] = nil
+		}
+	}
+
+	message.PlayersJoined, message.PlayersLeft, message.PlayersUpdated = diffPlayers(old.PlayersOnline, new.PlayersOnline)
+
+	if len(message.Changes) == 0 && len(message.PlayersJoined) == 0 &&
+		len(message.PlayersLeft) == 0 && len(message.PlayersUpdated) == 0 {
+		return nil
+	}
+	if len(message.Changes) == 0 {
+		message.Changes = nil
+	}
+	return message
+}
+
+func jsonFieldMap(server *ServerObject) map[string]interface{} {
+	buf, _ := json.Marshal(server)
+	var fields map[string]interface{}
+	json.Unmarshal(buf, &fields)
+	return fields
+}
+
+// diffPlayers matches players by name; players sharing a name are matched in
+// order. A player whose score changed is updated. Durations change on every
+// poll, so they don't count as an update.
+func diffPlayers(old, new []*valve.Player) (joined, left, updated []*valve.Player) {
+	remaining := map[string][]*valve.Player{}
+	for _, player := range old {
+		remaining[player.Name] = append(remaining[player.Name], player)
+	}
+
+	for _, player := range new {
+		matches := remaining[player.Name]
+		if len(matches) == 0 {
+			joined = append(joined, player)
+			continue
+		}
+		if matches[0].Score != player.Score {
+			updated = append(updated, player)
+		}
+		remaining[player.Name] = matches[1:]
+	}
+
+	for _, player := range old {
+		if matches := remaining[player.Name]; len(matches) > 0 {
+			left = append(left, matches[0])
+			remaining[player.Name] = matches[1:]
+		}
+	}
+	return joined, left, updated
+}
+
+var liveUpgrader = websocket.Upgrader{
+	CheckOrigin:  checkLiveOrigin,
+	Subprotocols: []string{kLiveTokenProtocol},
+}
+
+// liveAuthorization finds the API token of a WebSocket handshake: in the
+// Authorization header, offered as the subprotocol after kLiveTokenProtocol,
+// or as ?access_token=. It's returned as an Authorization value.
+func liveAuthorization(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return header
+	}
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == kLiveTokenProtocol && i+1 < len(protocols) {
+			return "Bearer " + protocols[i+1]
+		}
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return "Bearer " + token
+	}
+	return ""
+}
+
+// checkLiveOrigin allows clients that send no Origin (anything but a
+// browser), the API's own origin, and LIVE_ALLOWED_ORIGINS.
+func checkLiveOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range liveAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// loadLiveConfig reads the live subscription settings from the environment.
+func loadLiveConfig() {
+	livePollInterval = envDuration("LIVE_POLL_INTERVAL", livePollInterval)
+	if livePollInterval < time.Second {
+		livePollInterval = time.Second
+	}
+	liveAllowedOrigins = envList("LIVE_ALLOWED_ORIGINS")
+	liveMaxServers = envInt("LIVE_MAX_SERVERS", liveMaxServers)
+}
+
+// httpLiveServer streams a server's state over a WebSocket.
+func httpLiveServer(w http.ResponseWriter, r *http.Request) {
+	host, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/ws/server/"))
+	addr, err := resolveServerAddr(host)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid server address")
+		return
+	}
+
+	sub, err := liveServers.subscribe(addr.String())
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Too many servers watched")
+		return
+	}
+	defer liveServers.unsubscribe(sub)
+
+	conn, err := liveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied.
+		return
+	}
+	defer conn.Close()
+
+	// Clients don't send anything, but reading is how pongs and closes
+	// arrive.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(kLivePongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(kLivePongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(kLivePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-sub.ready:
+			for _, message := range sub.take() {
+				conn.SetWriteDeadline(time.Now().Add(kLiveWriteWait))
+				if err := conn.WriteJSON(message); err != nil {
+					log.Printf("⚠️  Live subscriber for %s dropped: %s", addr, err.Error())
+					return
+				}
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(kLiveWriteWait)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
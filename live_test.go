@@ -0,0 +1,221 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+)
+
+func TestDiffPlayers(t *testing.T) {
+	old := []*valve.Player{
+		{Name: "alice", Score: 1, Duration: 10},
+		{Name: "bob", Score: 2, Duration: 10},
+		{Name: "bob", Score: 3, Duration: 10},
+	}
+	new := []*valve.Player{
+		{Name: "alice", Score: 1, Duration: 20},
+		{Name: "bob", Score: 5, Duration: 20},
+		{Name: "carol", Score: 0, Duration: 1},
+	}
+
+	joined, left, updated := diffPlayers(old, new)
+	if len(joined) != 1 || joined[0].Name != "carol" {
+		t.Errorf("joined: got %v", joined)
+	}
+	if len(left) != 1 || left[0].Name != "bob" || left[0].Score != 3 {
+		t.Errorf("left: got %v", left)
+	}
+	if len(updated) != 1 || updated[0].Name != "bob" || updated[0].Score != 5 {
+		t.Errorf("updated: got %v", updated)
+	}
+}
+
+func TestDiffServers(t *testing.T) {
+	old := &ServerObject{Name: "a", MapName: "cp_badlands", Players: 1, GameMode: "x"}
+	new := &ServerObject{Name: "a", MapName: "ctf_2fort", Players: 1}
+
+	message := diffServers(old, new)
+	if message == nil || message.Type != "diff" || len(message.Changes) != 2 ||
+		message.Changes["map"] != "ctf_2fort" || message.Changes["game_mode"] != nil {
+		t.Fatalf("got %+v", message)
+	}
+	if _, ok := message.Changes["game_mode"]; !ok {
+		t.Fatalf("removed field missing: %+v", message.Changes)
+	}
+
+	if message := diffServers(new, new); message != nil {
+		t.Fatalf("no change: got %+v", message)
+	}
+}
+
+func TestLiveBacklogResync(t *testing.T) {
+	hub := &liveHub{servers: map[string]*liveServer{}}
+	server := &liveServer{
+		hub:         hub,
+		addr:        "127.0.0.1:27015",
+		subscribers: map[*liveSubscriber]struct{}{},
+		state:       &liveState{server: &ServerObject{Name: "latest"}},
+	}
+	sub := &liveSubscriber{server: server, ready: make(chan struct{}, 1)}
+
+	for i := 0; i <= kLiveMaxBacklog; i++ {
+		hub.push(sub, &liveMessage{Type: "diff"})
+	}
+	backlog := sub.take()
+	if len(backlog) != 1 || backlog[0].Type != "snapshot" || backlog[0].Server.Name != "latest" {
+		t.Fatalf("got %+v", backlog)
+	}
+	if hub.resyncs.Load() != 1 {
+		t.Fatalf("got %d resyncs", hub.resyncs.Load())
+	}
+}
+
+func readLiveMessage(t *testing.T, conn *websocket.Conn) *liveMessage {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message liveMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return &message
+}
+
+func TestLiveSubscription(t *testing.T) {
+	oldInterval := livePollInterval
+	livePollInterval = 50 * time.Millisecond
+	defer func() { livePollInterval = oldInterval }()
+
+	info := a2stest.SourceInfo()
+	game := a2stest.NewServer(info)
+	defer game.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/server/", httpLiveServer)
+	service := httptest.NewServer(mux)
+	defer service.Close()
+
+	url := "ws" + strings.TrimPrefix(service.URL, "http") + "/ws/server/" + game.Addr()
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+
+		message := readLiveMessage(t, conn)
+		if message.Type != "snapshot" || message.Server == nil || message.Server.MapName != "cp_badlands" {
+			t.Fatalf("got %+v", message)
+		}
+	}
+
+	// Both subscribers share one poller.
+	liveServers.mu.Lock()
+	watched := len(liveServers.servers)
+	subscribers := len(liveServers.servers[game.Addr()].subscribers)
+	liveServers.mu.Unlock()
+	if watched != 1 || subscribers != 2 {
+		t.Fatalf("got %d watched servers, %d subscribers", watched, subscribers)
+	}
+
+	changed := *info
+	changed.MapName = "ctf_2fort"
+	game.SetInfo(&changed)
+	for _, conn := range conns {
+		message := readLiveMessage(t, conn)
+		if message.Type != "diff" || message.Changes["map"] != "ctf_2fort" || len(message.Changes) != 1 {
+			t.Fatalf("got %+v", message)
+		}
+	}
+
+	// The poller stops once everyone has left.
+	for _, conn := range conns {
+		conn.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		liveServers.mu.Lock()
+		watched = len(liveServers.servers)
+		liveServers.mu.Unlock()
+		if watched == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still watching %d servers", watched)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLiveAuth(t *testing.T) {
+	apiToken = "secret"
+	defer func() { apiToken = "" }()
+
+	game := a2stest.NewServer(a2stest.SourceInfo())
+	defer game.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/server/", requireAPITokenIn(liveAuthorization, httpLiveServer))
+	service := httptest.NewServer(mux)
+	defer service.Close()
+	url := "ws" + strings.TrimPrefix(service.URL, "http") + "/ws/server/" + game.Addr()
+
+	if _, response, err := websocket.DefaultDialer.Dial(url, nil); err == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without a token: got %v", err)
+	}
+	if _, response, err := websocket.DefaultDialer.Dial(url+"?access_token=wrong", nil); err == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("with a wrong token: got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+	}{
+		{"header", url, http.Header{"Authorization": {"Bearer secret"}}},
+		{"subprotocol", url, http.Header{"Sec-WebSocket-Protocol": {kLiveTokenProtocol + ", secret"}}},
+		{"query", url + "?access_token=secret", nil},
+	}
+	for _, test := range tests {
+		conn, response, err := websocket.DefaultDialer.Dial(test.target, test.header)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.name == "subprotocol" && response.Header.Get("Sec-WebSocket-Protocol") != kLiveTokenProtocol {
+			t.Errorf("%s: got subprotocol %q", test.name, response.Header.Get("Sec-WebSocket-Protocol"))
+		}
+		readLiveMessage(t, conn)
+		conn.Close()
+	}
+}
+
+func TestLiveMaxServers(t *testing.T) {
+	oldMax := liveMaxServers
+	liveMaxServers = 1
+	defer func() { liveMaxServers = oldMax }()
+
+	hub := &liveHub{servers: map[string]*liveServer{}}
+	first, err := hub.subscribe("127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(first)
+
+	// The same server can take more subscribers, but no other can be added.
+	second, err := hub.subscribe("127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.unsubscribe(second)
+	if _, err := hub.subscribe("127.0.0.1:2"); err != errLiveFull {
+		t.Fatalf("got %v, want errLiveFull", err)
+	}
+}
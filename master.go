@@ -0,0 +1,102 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"log"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+var (
+	// Master server to list servers from over the legacy UDP protocol, as
+	// host:port. If empty, the Steam Web API is used.
+	masterServer string
+
+	// Region asked of the UDP master server.
+	masterRegion = valve.RegionAll
+
+	// Time allowed for each page of the UDP master server's list.
+	masterTimeout = 5 * time.Second
+
+	// UDP address our own master server listens on for heartbeats, such as
+	// ":27010". If empty, it isn't started.
+	masterListen string
+
+	// How long a registered server is kept without a heartbeat.
+	masterExpiry = 15 * time.Minute
+
+	// Our own master server, when it's running. Its registry takes the place
+	// of the other server list sources.
+	localMaster *valve.MasterServer
+)
+
+// loadMasterConfig picks the server list source from the environment.
+func loadMasterConfig() {
+	masterServer = envString("MASTER_SERVER", "")
+	masterTimeout = envDuration("MASTER_TIMEOUT", masterTimeout)
+	masterListen = envString("MASTER_LISTEN", "")
+	masterExpiry = envDuration("MASTER_EXPIRY", masterExpiry)
+	if name := envString("MASTER_REGION", ""); name != "" {
+		region, err := valve.ParseMasterRegion(name)
+		if err != nil {
+			log.Printf("⚠️  Invalid value for MASTER_REGION: %q, using all regions", name)
+		} else {
+			masterRegion = region
+		}
+	}
+}
+
+// startLocalMaster starts our own master server, if MASTER_LISTEN is set.
+func startLocalMaster() {
+	if masterListen == "" {
+		return
+	}
+	if masterServer != "" {
+		log.Printf("⚠️  MASTER_SERVER is ignored while MASTER_LISTEN is set")
+	}
+
+	master, err := valve.ListenMasterServer(masterListen, masterExpiry)
+	if err != nil {
+		log.Fatalf("Failed to start master server on %s: %v", masterListen, err)
+	}
+	localMaster = master
+	log.Printf("✓ Master server listening on %s (servers expire after %s)", master.Addr(), masterExpiry)
+}
+
+// masterMode describes where server lists come from.
+func masterMode() string {
+	if localMaster != nil {
+		return "built-in master server on " + localMaster.Addr().String()
+	}
+	if masterServer != "" {
+		return "UDP master server " + masterServer + " (" + masterRegion.String() + ")"
+	}
+	return "Steam Web API"
+}
+
+// newMasterQuerier creates a querier for the configured server list source.
+func newMasterQuerier() (valve.MasterQuerier, error) {
+	if localMaster != nil {
+		m := localMaster.NewQuerier()
+		m.SetRegion(masterRegion)
+		return m, nil
+	}
+	if masterServer != "" {
+		m, err := valve.NewUdpMasterQuerier(masterServer, masterTimeout)
+		if err != nil {
+			log.Printf("ERROR: Failed to create UDP master server querier: %s", err.Error())
+			return nil, err
+		}
+		m.SetRegion(masterRegion)
+		return m, nil
+	}
+
+	// Create Steam Web API querier
+	m, err := valve.NewSteamWebAPIQuerier(valve.SteamAPIKey)
+	if err != nil {
+		log.Printf("ERROR: Failed to create Steam Web API querier: %s", err.Error())
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,88 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"expvar"
+	"net/http"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Requests served, keyed by API and route, e.g. "http /search/" or
+// "grpc SearchServers".
+var apiRequests = new(expvar.Map)
+
+// countHTTPRequests counts requests to a route in apiRequests.
+func countHTTPRequests(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	key := "http " + pattern
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiRequests.Add(key, 1)
+		handler(w, r)
+	}
+}
+
+// publishMetrics exposes runtime counters as JSON at /debug/vars, alongside
+// the standard memstats and cmdline.
+func publishMetrics() {
+	expvar.Publish("a2s_rate_limit", expvar.Func(func() interface{} {
+		if valve.DefaultRateLimiter == nil {
+			return nil
+		}
+		stats := valve.DefaultRateLimiter.Stats()
+		return map[string]interface{}{
+			"throttled_queries": stats.Throttled,
+			"rejected_queries":  stats.Rejected,
+			"destinations":      stats.Destinations,
+		}
+	}))
+
+	expvar.Publish("a2s_cache", expvar.Func(func() interface{} {
+		if replyCache == nil {
+			return nil
+		}
+		stats := replyCache.Stats()
+		return map[string]interface{}{
+			"entries": stats.Entries,
+			"hits":    stats.Hits,
+			"misses":  stats.Misses,
+		}
+	}))
+
+	expvar.Publish("api_requests", apiRequests)
+
+	expvar.Publish("batch", expvar.Func(func() interface{} {
+		totals := batch.Totals()
+		return map[string]interface{}{
+			"items":     totals.Total,
+			"processed": totals.Done,
+			"failed":    totals.Failed,
+			"dropped":   totals.Dropped,
+		}
+	}))
+
+	expvar.Publish("master_server", expvar.Func(func() interface{} {
+		if localMaster == nil {
+			return nil
+		}
+		stats := localMaster.Stats()
+		return map[string]interface{}{
+			"servers":    stats.Servers,
+			"heartbeats": stats.Heartbeats,
+			"rejected":   stats.Rejected,
+			"queries":    stats.Queries,
+			"throttled":  stats.Throttled,
+		}
+	}))
+
+	expvar.Publish("live", expvar.Func(func() interface{} {
+		liveServers.mu.Lock()
+		watched := len(liveServers.servers)
+		liveServers.mu.Unlock()
+		return map[string]interface{}{
+			"watched_servers": watched,
+			"subscribers":     liveServers.subscribers.Load(),
+			"resyncs":         liveServers.resyncs.Load(),
+		}
+	}))
+}
@@ -0,0 +1,18 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The OpenAPI description of the HTTP API. Keep it in step with the handlers
+// and with the client package.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func httpOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,283 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cyxc1124/Mastersteam/client"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The spec and the client describe the same fields as the handlers write.
+func TestOpenAPISchemas(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+			}
+		}
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		schema string
+		types  []reflect.Type
+	}{
+		{"ServerObject", []reflect.Type{reflect.TypeOf(ServerObject{}), reflect.TypeOf(client.Server{})}},
+		{"ErrorObject", []reflect.Type{reflect.TypeOf(ErrorObject{}), reflect.TypeOf(client.ServerError{})}},
+		{"Player", []reflect.Type{reflect.TypeOf(valve.Player{}), reflect.TypeOf(client.Player{})}},
+		{"PlayerMatch", []reflect.Type{reflect.TypeOf(PlayerMatch{})}},
+		{"GroupRequest", []reflect.Type{reflect.TypeOf(GroupRequest{})}},
+		{"GroupObject", []reflect.Type{reflect.TypeOf(GroupObject{})}},
+		{"RconRequest", []reflect.Type{reflect.TypeOf(RconRequest{})}},
+		{"RconResponse", []reflect.Type{reflect.TypeOf(RconResponse{})}},
+		{"Error", []reflect.Type{reflect.TypeOf(client.Error{})}},
+	}
+	for _, test := range tests {
+		var properties []string
+		for name := range spec.Components.Schemas[test.schema].Properties {
+			properties = append(properties, name)
+		}
+		sort.Strings(properties)
+
+		for _, typ := range test.types {
+			if fields := jsonFields(typ); !reflect.DeepEqual(fields, properties) {
+				t.Errorf("%s: %s has %v, the spec has %v", test.schema, typ, fields, properties)
+			}
+		}
+	}
+}
+
+// Operations the client deliberately leaves to plain HTTP.
+var kClientUnwrapped = []string{
+	"searchPlayers", "graphqlGet", "graphql", "liveServer", "listGroups", "queryGroup",
+	"putGroup", "deleteGroup", "addGroupServers", "removeGroupServer", "openapi",
+}
+
+type specParameter struct {
+	Ref  string `json:"$ref"`
+	Name string
+	In   string
+}
+
+type specOperation struct {
+	OperationID string `json:"operationId"`
+	Parameters  []specParameter
+	RequestBody struct {
+		Content map[string]struct {
+			Schema struct {
+				Ref string `json:"$ref"`
+			}
+		}
+	} `json:"requestBody"`
+}
+
+// A request the client sent, as seen by the service.
+type clientRequest struct {
+	method string
+	path   string
+	query  []string
+	body   []string
+}
+
+// Every request the client makes is an operation in the spec, with only the
+// parameters and body fields the spec declares, and every other operation is
+// listed as unwrapped. Adding an endpoint to one but not the other fails here.
+func TestOpenAPIClientOperations(t *testing.T) {
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Parameters map[string]specParameter
+			Schemas    map[string]struct {
+				Properties map[string]json.RawMessage
+			}
+		}
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []clientRequest
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := clientRequest{method: r.Method, path: r.URL.EscapedPath()}
+		for name := range r.URL.Query() {
+			request.query = append(request.query, name)
+		}
+		var body map[string]json.RawMessage
+		if json.NewDecoder(r.Body).Decode(&body) == nil {
+			for name := range body {
+				request.body = append(request.body, name)
+			}
+		}
+		requests = append(requests, request)
+		w.Write([]byte(`{"data":[],"total":0}`))
+	}))
+	defer service.Close()
+
+	c := client.New(service.URL)
+	ctx := context.Background()
+	c.Search(ctx, 440, "Uncletopia | *", &client.Options{Fresh: true})
+	c.Server(ctx, "10.0.0.1:27015", &client.Options{Fresh: true})
+	c.Rcon(ctx, "10.0.0.1:27015", "status")
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests", len(requests))
+	}
+
+	wrapped := map[string]bool{}
+	for _, request := range requests {
+		op, ok := findOperation(spec.Paths, request.method, request.path)
+		if !ok {
+			t.Errorf("%s %s: not in the spec", request.method, request.path)
+			continue
+		}
+		wrapped[op.OperationID] = true
+
+		declared := map[string]bool{}
+		for _, param := range op.Parameters {
+			if param.Ref != "" {
+				param = spec.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+			}
+			if param.In == "query" {
+				declared[param.Name] = true
+			}
+		}
+		for _, name := range request.query {
+			if !declared[name] {
+				t.Errorf("%s: the client sends query parameter %q, the spec doesn't declare it", op.OperationID, name)
+			}
+		}
+
+		ref := op.RequestBody.Content["application/json"].Schema.Ref
+		properties := spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")].Properties
+		for _, name := range request.body {
+			if _, ok := properties[name]; !ok {
+				t.Errorf("%s: the client sends body field %q, the spec doesn't declare it", op.OperationID, name)
+			}
+		}
+	}
+
+	unwrapped := map[string]bool{}
+	for _, id := range kClientUnwrapped {
+		unwrapped[id] = true
+	}
+	for path, methods := range spec.Paths {
+		for method, raw := range methods {
+			if method == "parameters" {
+				continue
+			}
+			var op specOperation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case wrapped[op.OperationID] && unwrapped[op.OperationID]:
+				t.Errorf("%s: wrapped by the client but listed as unwrapped", op.OperationID)
+			case !wrapped[op.OperationID] && !unwrapped[op.OperationID]:
+				t.Errorf("%s %s (%s): neither wrapped by the client nor listed as unwrapped", strings.ToUpper(method), path, op.OperationID)
+			}
+			delete(unwrapped, op.OperationID)
+		}
+	}
+	for id := range unwrapped {
+		t.Errorf("%s: listed as unwrapped but not in the spec", id)
+	}
+}
+
+// The operation whose path template matches an escaped request path.
+func findOperation(paths map[string]map[string]json.RawMessage, method, path string) (specOperation, bool) {
+	segments := strings.Split(path, "/")
+	for template, methods := range paths {
+		templateSegments := strings.Split(template, "/")
+		if len(templateSegments) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, segment := range templateSegments {
+			if !strings.HasPrefix(segment, "{") && segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		raw, ok := methods[strings.ToLower(method)]
+		if !matched || !ok {
+			continue
+		}
+		var op specOperation
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return specOperation{}, false
+		}
+		return op, true
+	}
+	return specOperation{}, false
+}
+
+func TestClientEndToEnd(t *testing.T) {
+	source := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: source.Addr(), Name: "Uncletopia | Seattle", Appid: 440},
+		webapitest.Entry{Addr: "127.0.0.1:1", Name: "Uncletopia | Dead", Appid: 440},
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search/", httpMasterSearch)
+	mux.HandleFunc("/server/", httpServer)
+	mux.HandleFunc("/openapi.json", httpOpenAPI)
+	service := httptest.NewServer(mux)
+	defer service.Close()
+
+	c := client.New(service.URL)
+	results, err := c.Search(context.Background(), 440, "Uncletopia | *", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for _, result := range results {
+		switch result.Address {
+		case source.Addr():
+			if result.Server == nil || result.Server.MapName != "cp_badlands" {
+				t.Errorf("got %+v", result)
+			}
+		case "127.0.0.1:1":
+			if result.Err == nil || result.Err.Message == "" {
+				t.Errorf("got %+v", result)
+			}
+		default:
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	resp, err := http.Get(service.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var spec map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil || spec["openapi"] == nil {
+		t.Fatalf("got %v, %v", spec, err)
+	}
+}
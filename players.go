@@ -0,0 +1,288 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Ways of matching player names.
+const (
+	matchContains = "contains"
+	matchExact    = "exact"
+	matchFuzzy    = "fuzzy"
+	matchRegex    = "regex"
+)
+
+/*
+PlayerMatch ...
+*/
+type PlayerMatch struct {
+	Name       string  `json:"name"`
+	Score      uint32  `json:"score"`
+	Duration   float32 `json:"duration"`
+	Address    string  `json:"ip"`
+	ServerName string  `json:"server_name"`
+}
+
+// newPlayerMatcher returns a function reporting whether a player name matches
+// the pattern:
+//
+//   - contains: the name contains the pattern, ignoring case.
+//   - exact: the name is the pattern, ignoring case.
+//   - fuzzy: ignoring case and anything but letters and digits, part of the
+//     name is within a few typos of the pattern.
+//   - regex: the name matches the regular expression.
+func newPlayerMatcher(pattern, mode string) (func(string) bool, error) {
+	switch mode {
+	case "", matchContains:
+		pattern = strings.ToLower(pattern)
+		return func(name string) bool {
+			return strings.Contains(strings.ToLower(name), pattern)
+		}, nil
+	case matchExact:
+		return func(name string) bool {
+			return strings.EqualFold(name, pattern)
+		}, nil
+	case matchFuzzy:
+		normalized := []rune(normalizePlayerName(pattern))
+		if len(normalized) == 0 {
+			return nil, errors.New("fuzzy pattern needs letters or digits")
+		}
+		// One typo per four characters.
+		maxTypos := len(normalized) / 4
+		return func(name string) bool {
+			return substringDistance(normalized, []rune(normalizePlayerName(name))) <= maxTypos
+		}, nil
+	case matchRegex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.New("invalid regular expression")
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("unknown match mode %q", mode)
+	}
+}
+
+// normalizePlayerName lowercases a name and drops everything but letters and
+// digits, so "xX_Sn1per_Xx" becomes "xxsn1perxx".
+func normalizePlayerName(name string) string {
+	var out strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			out.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return out.String()
+}
+
+// substringDistance returns the fewest edits turning the pattern into some
+// substring of the text.
+func substringDistance(pattern, text []rune) int {
+	// Row i holds the distances for the first i runes of the pattern. A match
+	// may start anywhere in the text, so row 0 is all zeros.
+	row := make([]int, len(text)+1)
+	for i, p := range pattern {
+		diagonal := row[0]
+		row[0] = i + 1
+		for j, t := range text {
+			cost := 1
+			if p == t {
+				cost = 0
+			}
+			next := min(row[j+1]+1, row[j]+1, diagonal+cost)
+			diagonal, row[j+1] = row[j+1], next
+		}
+	}
+	return slices.Min(row)
+}
+
+// searchServerPlayers returns the players on one server whose names match.
+func searchServerPlayers(addr *net.TCPAddr, limiter *batch.AIMD, opts queryOptions, match func(string) bool) ([]*PlayerMatch, error) {
+	query := &serverQuery{
+		addr:    addr.String(),
+		opts:    opts,
+		limiter: limiter,
+	}
+	defer query.close()
+
+	// The info is usually cached, and split player lists can't be decoded
+	// without it.
+	info, err := query.info()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Match != nil && !opts.Match(addr, info) {
+		return nil, nil
+	}
+	if info.Players == 0 {
+		return nil, nil
+	}
+	players, err := query.players(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*PlayerMatch
+	for _, player := range players {
+		if match(player.Name) {
+			out = append(out, &PlayerMatch{
+				Name:       player.Name,
+				Score:      player.Score,
+				Duration:   player.Duration,
+				Address:    addr.String(),
+				ServerName: info.Name,
+			})
+		}
+	}
+	return out, nil
+}
+
+// startPlayerSearch lists an app's servers and searches the players on each
+// one as soon as it's listed. Servers the master reports as empty are
+// skipped, if it reports player counts.
+func startPlayerSearch(master valve.MasterQuerier, opts queryOptions, match func(string) bool) (*batch.Processor[*net.TCPAddr, []*PlayerMatch], <-chan error) {
+	opts = withInfoFilter(master, opts)
+	limiter := batch.NewAIMD(queryWorkers, 1, queryMaxWorkers)
+	limiter.LatencyTarget = queryLatencyTarget
+
+	bp := batch.NewProcessor(func(addr *net.TCPAddr) ([]*PlayerMatch, error) {
+		return searchServerPlayers(addr, limiter, opts, match)
+	}, batch.Options{
+		Limiter:  limiter,
+		Pool:     queryPool,
+		Priority: opts.Priority,
+	})
+
+	errc := listServers(bp, func() error {
+		listings, ok := master.(valve.ListingQuerier)
+		if !ok {
+			return master.Query(func(servers valve.ServerList) error {
+				bp.Add(servers...)
+				return nil
+			})
+		}
+
+		listed, populated := 0, 0
+		err := listings.QueryListings(func(servers []*valve.ServerListing) error {
+			for _, server := range servers {
+				listed++
+				if server.Players > 0 {
+					populated++
+					bp.Add(server.Addr)
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			log.Printf("Player search: %d of %d servers populated", populated, listed)
+		}
+		return err
+	})
+	return bp, errc
+}
+
+// httpPlayerSearch streams the players on an app's servers whose names match,
+// as newline-delimited JSON, in the order servers answer.
+func httpPlayerSearch(w http.ResponseWriter, r *http.Request) {
+	uriSegments := strings.Split(r.URL.EscapedPath(), "/")
+	if len(uriSegments) != 4 {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	appID, err := strconv.ParseUint(uriSegments[3], 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid app ID")
+		return
+	}
+
+	params := r.URL.Query()
+	pattern := params.Get("name")
+	if pattern == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing name")
+		return
+	}
+	match, err := newPlayerMatcher(pattern, params.Get("match"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Can't match names: "+err.Error())
+		return
+	}
+
+	sources, err := requestSources(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid source: "+err.Error())
+		return
+	}
+
+	master, err := openSources(sources)
+	if err != nil {
+		handleQueryError(w, err)
+		return
+	}
+	defer master.Close()
+	master.FilterAppId(valve.AppId(appID))
+
+	bp, errc := startPlayerSearch(master, queryOptions{
+		Priority: batch.PriorityBulk,
+		Fresh:    isFresh(r),
+	}, match)
+
+	// The status is only sent with the first match, so that a failed server
+	// list can still be reported as an error.
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	results := bp.Results()
+	for results != nil {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			if len(result.Value) == 0 {
+				continue
+			}
+			start()
+			for _, match := range result.Value {
+				if err := encoder.Encode(match); err != nil {
+					bp.Terminate()
+					return
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			bp.Terminate()
+			return
+		}
+	}
+
+	if err := <-errc; err != nil && !started {
+		handleQueryError(w, err)
+		return
+	}
+	start()
+}
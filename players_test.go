@@ -0,0 +1,138 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+func TestPlayerMatcher(t *testing.T) {
+	tests := []struct {
+		mode    string
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"", "sniper", "xX_SNIPER_Xx", true},
+		{"contains", "sniper", "snipr", false},
+		{"exact", "Alice", "alice", true},
+		{"exact", "Alice", "alice2", false},
+		{"fuzzy", "sn1per", "xX_Sn1per_Xx", true},
+		{"fuzzy", "sniper", "xX_Sn1per_Xx", true},
+		{"fuzzy", "sniper", "snooper", false},
+		{"fuzzy", "ab", "a_b", true},
+		{"fuzzy", "ab", "ac", false},
+		{"regex", `^\[TAG\]`, "[TAG] bob", true},
+		{"regex", `^\[TAG\]`, "bob [TAG]", false},
+		{"regex", `(?i)^bob$`, "BOB", true},
+	}
+	for _, test := range tests {
+		match, err := newPlayerMatcher(test.pattern, test.mode)
+		if err != nil {
+			t.Fatalf("%s %q: %v", test.mode, test.pattern, err)
+		}
+		if got := match(test.name); got != test.want {
+			t.Errorf("%s %q matching %q: got %v, want %v", test.mode, test.pattern, test.name, got, test.want)
+		}
+	}
+
+	for _, bad := range [][2]string{{"(", "regex"}, {"__", "fuzzy"}, {"x", "soundex"}} {
+		if _, err := newPlayerMatcher(bad[0], bad[1]); err == nil {
+			t.Errorf("%s %q: expected an error", bad[1], bad[0])
+		}
+	}
+}
+
+func TestPlayerSearchEndToEnd(t *testing.T) {
+	info := a2stest.SourceInfo()
+	info.Players = 2
+	first := newTestGameServer(t, info, []*valve.Player{
+		{Name: "alice", Score: 10, Duration: 60},
+		{Name: "bob", Score: 3, Duration: 30},
+	})
+	second := newTestGameServer(t, info, []*valve.Player{
+		{Name: "Alice (2)", Score: 1, Duration: 5},
+	})
+	empty := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: first.Addr(), Appid: 440, Players: 2},
+		webapitest.Entry{Addr: second.Addr(), Appid: 440, Players: 1},
+		webapitest.Entry{Addr: empty.Addr(), Appid: 440},
+	)
+
+	recorder := doRequest(t, httpPlayerSearch, "/players/search/440?name=alice")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/x-ndjson; charset=UTF-8" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+
+	var matches []PlayerMatch
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var match PlayerMatch
+		if err := json.Unmarshal(scanner.Bytes(), &match); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+
+	want := []PlayerMatch{
+		{Name: "Alice (2)", Score: 1, Duration: 5, Address: second.Addr(), ServerName: info.Name},
+		{Name: "alice", Score: 10, Duration: 60, Address: first.Addr(), ServerName: info.Name},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("got %+v", matches)
+	}
+	for i := range want {
+		if matches[i] != want[i] {
+			t.Errorf("got %+v, want %+v", matches[i], want[i])
+		}
+	}
+
+	// Steam said the last server was empty, so it was never queried.
+	if queries := empty.Queries(); queries != 0 {
+		t.Errorf("empty server got %d queries", queries)
+	}
+}
+
+func TestPlayerSearchErrors(t *testing.T) {
+	api := newTestWebAPI(t)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/players/search/440", http.StatusBadRequest},
+		{"/players/search/tf2?name=alice", http.StatusBadRequest},
+		{"/players/search/440?name=(&match=regex", http.StatusBadRequest},
+		{"/players/search/440?name=alice&match=glob", http.StatusBadRequest},
+		{"/players/search/440/x?name=alice", http.StatusNotFound},
+		{"/players/search/440?name=nobody", http.StatusOK},
+	}
+	for _, test := range tests {
+		if recorder := doRequest(t, httpPlayerSearch, test.target); recorder.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.target, recorder.Code, test.status)
+		}
+	}
+
+	recorder := doRequest(t, httpPlayerSearch, "/players/search/440?name=(&match=regex")
+	if body := recorder.Body.String(); !strings.Contains(body, `"error":"Can't match names: invalid regular expression"`) {
+		t.Errorf("got %s", body)
+	}
+
+	api.Fail(webapitest.FailUnauthorized)
+	if recorder := doRequest(t, httpPlayerSearch, "/players/search/440?name=alice"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
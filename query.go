@@ -0,0 +1,167 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Recent A2S replies, shared by all requests. If nil, nothing is cached.
+var replyCache *valve.A2SCache
+
+// Options for one request's worth of server queries.
+type queryOptions struct {
+	// Scheduling class in the shared query pool.
+	Priority batch.Priority
+
+	// Skip the reply cache. Fresh replies are still stored in it.
+	Fresh bool
+
+	// Checks servers from sources that can't filter by AppID or name
+	// themselves. Servers that don't match are dropped from the results.
+	Match func(addr *net.TCPAddr, info *valve.ServerInfo) bool
+}
+
+// loadCacheConfig sets up the reply cache from the environment.
+func loadCacheConfig() {
+	size := envInt("A2S_CACHE_SIZE", 10000)
+	if size <= 0 {
+		log.Printf("✓ A2S reply cache disabled")
+		return
+	}
+
+	config := valve.CacheConfig{
+		InfoTTL:     envDuration("A2S_CACHE_TTL", 10*time.Second),
+		NegativeTTL: envDuration("A2S_CACHE_NEGATIVE_TTL", 5*time.Second),
+		MaxEntries:  size,
+	}
+	config.PlayersTTL = envDuration("A2S_CACHE_PLAYERS_TTL", config.InfoTTL)
+	config.RulesTTL = envDuration("A2S_CACHE_RULES_TTL", 30*time.Second)
+
+	replyCache = valve.NewA2SCache(config)
+	log.Printf("✓ A2S replies cached for %s (up to %d servers)", config.InfoTTL, size)
+}
+
+// A cachedError is a query failure served from the cache.
+type cachedError struct {
+	err      error
+	cachedAt time.Time
+}
+
+func (ce *cachedError) Error() string {
+	return ce.err.Error()
+}
+
+func (ce *cachedError) Unwrap() error {
+	return ce.err
+}
+
+// A serverQuery fetches replies from one server, taking them from the reply
+// cache where it can and only opening a socket when it has to.
+type serverQuery struct {
+	addr    string
+	opts    queryOptions
+	limiter *batch.AIMD
+	query   *valve.ServerQuerier
+
+	// When the oldest cached reply used was received. Zero if every reply
+	// was fresh.
+	cachedAt time.Time
+}
+
+func (sq *serverQuery) querier() (*valve.ServerQuerier, error) {
+	if sq.query == nil {
+		query, err := openServerQuerier(sq.addr, queryTimeout)
+		if err != nil {
+			return nil, err
+		}
+		sq.query = query
+	}
+	return sq.query, nil
+}
+
+func (sq *serverQuery) useCached(at time.Time) {
+	if sq.cachedAt.IsZero() || at.Before(sq.cachedAt) {
+		sq.cachedAt = at
+	}
+}
+
+func (sq *serverQuery) info() (*valve.ServerInfo, error) {
+	if replyCache != nil && !sq.opts.Fresh {
+		if reply, ok := replyCache.Info(sq.addr); ok {
+			if reply.Err != nil {
+				return nil, &cachedError{reply.Err, reply.CachedAt}
+			}
+			sq.useCached(reply.CachedAt)
+			return reply.Value, nil
+		}
+	}
+
+	query, err := sq.querier()
+	if err != nil {
+		return nil, err
+	}
+	info, err := query.QueryInfo()
+	if replyCache != nil {
+		replyCache.PutInfo(sq.addr, info, err)
+	}
+	return info, err
+}
+
+func (sq *serverQuery) players(info *valve.ServerInfo) ([]*valve.Player, error) {
+	if replyCache != nil && !sq.opts.Fresh {
+		if reply, ok := replyCache.Players(sq.addr); ok {
+			if reply.Err != nil {
+				return nil, reply.Err
+			}
+			sq.useCached(reply.CachedAt)
+			return reply.Value, nil
+		}
+	}
+
+	query, err := sq.querier()
+	if err != nil {
+		return nil, err
+	}
+	// The info may have come from the cache.
+	query.SetInfo(info)
+	players, err := query.QueryPlayers()
+	if replyCache != nil {
+		replyCache.PutPlayers(sq.addr, players, err)
+	}
+	return players, err
+}
+
+func (sq *serverQuery) rules(info *valve.ServerInfo) (map[string]string, error) {
+	if replyCache != nil && !sq.opts.Fresh {
+		if reply, ok := replyCache.Rules(sq.addr); ok {
+			if reply.Err != nil {
+				return nil, reply.Err
+			}
+			sq.useCached(reply.CachedAt)
+			return reply.Value, nil
+		}
+	}
+
+	query, err := sq.querier()
+	if err != nil {
+		return nil, err
+	}
+	query.SetInfo(info)
+	rules, err := query.QueryRules()
+	if replyCache != nil {
+		replyCache.PutRules(sq.addr, rules, err)
+	}
+	return rules, err
+}
+
+func (sq *serverQuery) close() {
+	if sq.query != nil {
+		observeQuery(sq.limiter, sq.query)
+		sq.query.Close()
+	}
+}
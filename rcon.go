@@ -0,0 +1,199 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Maximum size of an /rcon request body.
+const kMaxRconRequestSize = 4096
+
+/*
+RconConfig ...
+*/
+type RconConfig struct {
+	// Whether the /rcon endpoint is registered at all.
+	Enabled bool
+
+	// Bearer token callers must present in the Authorization header.
+	Token string
+
+	// RCON passwords keyed by server address (host:port). Servers that are
+	// not listed here cannot be controlled.
+	Passwords map[string]string
+
+	// Engine of each server (host:port), choosing its RCON protocol. Servers
+	// that are not listed here are detected through A2S_INFO.
+	Engines map[string]valve.GameEngine
+
+	// Commands that may be executed, matched against the first word of the
+	// command. An empty list denies everything.
+	AllowedCommands []string
+
+	// Connect and I/O timeout for RCON sessions.
+	Timeout time.Duration
+}
+
+/*
+RconRequest ...
+*/
+type RconRequest struct {
+	Command string `json:"command"`
+}
+
+/*
+RconResponse ...
+*/
+type RconResponse struct {
+	IP       string `json:"ip"`
+	Command  string `json:"command"`
+	Response string `json:"response"`
+}
+
+var rconConfig RconConfig
+
+func loadRconConfig() {
+	rconConfig = RconConfig{
+		Enabled:         envBool("RCON_ENABLED", false),
+		Token:           envString("RCON_API_TOKEN", ""),
+		Passwords:       envMap("RCON_PASSWORDS"),
+		Engines:         map[string]valve.GameEngine{},
+		AllowedCommands: envList("RCON_ALLOWED_COMMANDS"),
+		Timeout:         envDuration("RCON_TIMEOUT", time.Second*5),
+	}
+
+	for host, name := range envMap("RCON_ENGINES") {
+		engine, ok := valve.ParseGameEngine(strings.TrimSpace(name))
+		if !ok {
+			log.Printf("⚠️  Ignoring unknown engine in RCON_ENGINES for %s: %q", host, name)
+			continue
+		}
+		rconConfig.Engines[host] = engine
+	}
+
+	if !rconConfig.Enabled {
+		return
+	}
+	if rconConfig.Token == "" {
+		log.Printf("⚠️  RCON_ENABLED is set but RCON_API_TOKEN is empty, /rcon is disabled")
+		rconConfig.Enabled = false
+		return
+	}
+	if len(rconConfig.AllowedCommands) == 0 {
+		log.Printf("⚠️  RCON_ALLOWED_COMMANDS is empty, all RCON commands will be rejected")
+	}
+	log.Printf("✓ RCON enabled for %d server(s)", len(rconConfig.Passwords))
+}
+
+// isAuthorized checks the bearer token of an /rcon request.
+func (rc *RconConfig) isAuthorized(r *http.Request) bool {
+	return bearerTokenMatches(r.Header.Get("Authorization"), rc.Token)
+}
+
+// isCommandAllowed checks a command against the allow-list. Command chaining
+// is rejected outright, since it would bypass the list.
+func (rc *RconConfig) isCommandAllowed(command string) bool {
+	if strings.ContainsAny(command, ";\r\n") {
+		return false
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+	for _, allowed := range rc.AllowedCommands {
+		if strings.EqualFold(fields[0], allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  message,
+		"status": statusCode,
+	})
+}
+
+func httpRcon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !rconConfig.isAuthorized(r) {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	host, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/rcon/"))
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Server address must be in host:port form")
+		return
+	}
+
+	password, ok := rconConfig.Passwords[host]
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, "RCON is not configured for this server")
+		return
+	}
+
+	var request RconRequest
+	body := io.LimitReader(r.Body, kMaxRconRequestSize)
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !rconConfig.isCommandAllowed(request.Command) {
+		log.Printf("⚠️  Rejected RCON command for %s: %q", host, request.Command)
+		writeJSONError(w, http.StatusForbidden, "Command not allowed")
+		return
+	}
+
+	output, err := executeRcon(host, password, request.Command)
+	if err != nil {
+		log.Printf("⚠️  RCON error [%s]: %s", host, err.Error())
+		if errors.Is(err, valve.ErrRconAuthFailed) {
+			writeJSONError(w, http.StatusBadGateway, "RCON authentication failed")
+		} else {
+			writeJSONError(w, http.StatusBadGateway, "RCON request failed")
+		}
+		return
+	}
+
+	log.Printf("RCON %s: %q", host, request.Command)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(&RconResponse{
+		IP:       host,
+		Command:  request.Command,
+		Response: output,
+	})
+}
+
+func executeRcon(host string, password string, command string) (string, error) {
+	// GoldSrc servers use a different protocol. Unless it's configured, pick
+	// one based on A2S_INFO.
+	client, err := valve.DialRcon(host, rconConfig.Engines[host], rconConfig.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	if err := client.Authenticate(password); err != nil {
+		return "", err
+	}
+	return client.Execute(command)
+}
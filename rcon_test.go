@@ -0,0 +1,102 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+)
+
+func setRconConfig(t *testing.T, config RconConfig) {
+	old := rconConfig
+	rconConfig = config
+	t.Cleanup(func() { rconConfig = old })
+}
+
+func doRconRequest(method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	httpRcon(recorder, r)
+	return recorder
+}
+
+func TestRconRejectedRequests(t *testing.T) {
+	setRconConfig(t, RconConfig{
+		Enabled:         true,
+		Token:           "secret",
+		Passwords:       map[string]string{"127.0.0.1:1": "hunter2"},
+		AllowedCommands: []string{"status", "say"},
+	})
+
+	tests := []struct {
+		method string
+		target string
+		token  string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, "/rcon/127.0.0.1:1", "secret", "", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/rcon/127.0.0.1:1", "secret", `{"command":"status"}`, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "", `{"command":"status"}`, http.StatusUnauthorized},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "wrong", `{"command":"status"}`, http.StatusUnauthorized},
+		{http.MethodPost, "/rcon/127.0.0.1:2", "secret", `{"command":"status"}`, http.StatusForbidden},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "secret", `{"command":"rcon_password x"}`, http.StatusForbidden},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "secret", `{"command":"status; quit"}`, http.StatusForbidden},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "secret", `{"command":"say hi\rquit"}`, http.StatusForbidden},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "secret", `{"command":"say hi\nquit"}`, http.StatusForbidden},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "secret", `{"command":""}`, http.StatusForbidden},
+		{http.MethodPost, "/rcon/127.0.0.1", "secret", `{"command":"status"}`, http.StatusBadRequest},
+		{http.MethodPost, "/rcon/127.0.0.1:1", "secret", `not json`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := doRconRequest(test.method, test.target, test.token, test.body)
+		if recorder.Code != test.want {
+			t.Errorf("%s %s %q: got status %d, want %d: %s", test.method, test.target, test.body, recorder.Code, test.want, recorder.Body.String())
+		}
+	}
+
+	if recorder := doRconRequest(http.MethodGet, "/rcon/127.0.0.1:1", "secret", ""); recorder.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("got Allow %q", recorder.Header().Get("Allow"))
+	}
+}
+
+func TestRconGoldSrc(t *testing.T) {
+	server := a2stest.NewUnstartedServer(a2stest.GoldSrcInfo())
+	server.RconPassword = "hunter2"
+	server.SetRconOutput("status", "hostname: a2stest GoldSrc server\n")
+	server.Start()
+	t.Cleanup(server.Close)
+
+	setRconConfig(t, RconConfig{
+		Enabled:         true,
+		Token:           "secret",
+		Passwords:       map[string]string{server.Addr(): "hunter2"},
+		Engines:         map[string]valve.GameEngine{server.Addr(): valve.GOLDSRC},
+		AllowedCommands: []string{"status"},
+		Timeout:         2 * time.Second,
+	})
+
+	recorder := doRconRequest(http.MethodPost, "/rcon/"+server.Addr(), "secret", `{"command":"status"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var response RconResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Response != "hostname: a2stest GoldSrc server\n" {
+		t.Errorf("got %q", response.Response)
+	}
+	if queries := server.Queries(); queries != 2 {
+		t.Errorf("got %d packets, want no A2S_INFO before the command", queries)
+	}
+}
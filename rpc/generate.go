@@ -0,0 +1,6 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Package rpc holds the gRPC service definition and its generated code.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative mastersteam.proto
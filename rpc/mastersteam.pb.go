@@ -0,0 +1,704 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: mastersteam.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SearchServersRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Steam app ID. Either appid or addr is required.
+	Appid uint32 `protobuf:"varint,1,opt,name=appid,proto3" json:"appid,omitempty"`
+	// Server name to match; * is a wildcard.
+	Name string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// Only servers at this IP or IP:port.
+	Addr string `protobuf:"bytes,3,opt,name=addr,proto3" json:"addr,omitempty"`
+	// Query the servers again instead of using cached replies.
+	Fresh bool `protobuf:"varint,4,opt,name=fresh,proto3" json:"fresh,omitempty"`
+	// Server list sources to use, comma-separated: master, static, file or
+	// srv, whichever are configured, or all. Defaults to DEFAULT_SOURCE.
+	Source        string `protobuf:"bytes,5,opt,name=source,proto3" json:"source,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchServersRequest) Reset() {
+	*x = SearchServersRequest{}
+	mi := &file_mastersteam_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchServersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchServersRequest) ProtoMessage() {}
+
+func (x *SearchServersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mastersteam_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchServersRequest.ProtoReflect.Descriptor instead.
+func (*SearchServersRequest) Descriptor() ([]byte, []int) {
+	return file_mastersteam_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SearchServersRequest) GetAppid() uint32 {
+	if x != nil {
+		return x.Appid
+	}
+	return 0
+}
+
+func (x *SearchServersRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SearchServersRequest) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *SearchServersRequest) GetFresh() bool {
+	if x != nil {
+		return x.Fresh
+	}
+	return false
+}
+
+func (x *SearchServersRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+type GetServerRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// IP or host, with an optional port (default 27015).
+	Addr string `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	// Query the server again instead of using cached replies.
+	Fresh         bool `protobuf:"varint,2,opt,name=fresh,proto3" json:"fresh,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetServerRequest) Reset() {
+	*x = GetServerRequest{}
+	mi := &file_mastersteam_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetServerRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetServerRequest) ProtoMessage() {}
+
+func (x *GetServerRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mastersteam_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetServerRequest.ProtoReflect.Descriptor instead.
+func (*GetServerRequest) Descriptor() ([]byte, []int) {
+	return file_mastersteam_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetServerRequest) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *GetServerRequest) GetFresh() bool {
+	if x != nil {
+		return x.Fresh
+	}
+	return false
+}
+
+// A server's A2S_INFO reply. Fields are as in the HTTP API's ServerObject.
+type ServerInfo struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Ip          string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Protocol    uint32                 `protobuf:"varint,2,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Name        string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Map         string                 `protobuf:"bytes,4,opt,name=map,proto3" json:"map,omitempty"`
+	Folder      string                 `protobuf:"bytes,5,opt,name=folder,proto3" json:"folder,omitempty"`
+	Game        string                 `protobuf:"bytes,6,opt,name=game,proto3" json:"game,omitempty"`
+	Players     uint32                 `protobuf:"varint,7,opt,name=players,proto3" json:"players,omitempty"`
+	MaxPlayers  uint32                 `protobuf:"varint,8,opt,name=max_players,json=maxPlayers,proto3" json:"max_players,omitempty"`
+	Bots        uint32                 `protobuf:"varint,9,opt,name=bots,proto3" json:"bots,omitempty"`
+	Type        string                 `protobuf:"bytes,10,opt,name=type,proto3" json:"type,omitempty"`
+	Os          string                 `protobuf:"bytes,11,opt,name=os,proto3" json:"os,omitempty"`
+	Visibility  string                 `protobuf:"bytes,12,opt,name=visibility,proto3" json:"visibility,omitempty"`
+	Vac         bool                   `protobuf:"varint,13,opt,name=vac,proto3" json:"vac,omitempty"`
+	Appid       uint32                 `protobuf:"varint,14,opt,name=appid,proto3" json:"appid,omitempty"`
+	GameVersion string                 `protobuf:"bytes,15,opt,name=game_version,json=gameVersion,proto3" json:"game_version,omitempty"`
+	Port        uint32                 `protobuf:"varint,16,opt,name=port,proto3" json:"port,omitempty"`
+	Steamid     string                 `protobuf:"bytes,17,opt,name=steamid,proto3" json:"steamid,omitempty"`
+	// The server's keywords (tags).
+	GameMode      string    `protobuf:"bytes,18,opt,name=game_mode,json=gameMode,proto3" json:"game_mode,omitempty"`
+	Gameid        string    `protobuf:"bytes,19,opt,name=gameid,proto3" json:"gameid,omitempty"`
+	PlayersOnline []*Player `protobuf:"bytes,20,rep,name=players_online,json=playersOnline,proto3" json:"players_online,omitempty"`
+	// When the reply was received, if it was served from the cache.
+	CachedAt *timestamppb.Timestamp `protobuf:"bytes,21,opt,name=cached_at,json=cachedAt,proto3" json:"cached_at,omitempty"`
+	// In SearchServers, why the server couldn't be queried. Only ip is set
+	// then.
+	Error string `protobuf:"bytes,22,opt,name=error,proto3" json:"error,omitempty"`
+	// In a search merged from several sources, every source that listed the
+	// server.
+	Sources       []string `protobuf:"bytes,23,rep,name=sources,proto3" json:"sources,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerInfo) Reset() {
+	*x = ServerInfo{}
+	mi := &file_mastersteam_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfo) ProtoMessage() {}
+
+func (x *ServerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_mastersteam_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfo.ProtoReflect.Descriptor instead.
+func (*ServerInfo) Descriptor() ([]byte, []int) {
+	return file_mastersteam_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ServerInfo) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetProtocol() uint32 {
+	if x != nil {
+		return x.Protocol
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetFolder() string {
+	if x != nil {
+		return x.Folder
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetGame() string {
+	if x != nil {
+		return x.Game
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetPlayers() uint32 {
+	if x != nil {
+		return x.Players
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetMaxPlayers() uint32 {
+	if x != nil {
+		return x.MaxPlayers
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetBots() uint32 {
+	if x != nil {
+		return x.Bots
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetVisibility() string {
+	if x != nil {
+		return x.Visibility
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetVac() bool {
+	if x != nil {
+		return x.Vac
+	}
+	return false
+}
+
+func (x *ServerInfo) GetAppid() uint32 {
+	if x != nil {
+		return x.Appid
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetGameVersion() string {
+	if x != nil {
+		return x.GameVersion
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetSteamid() string {
+	if x != nil {
+		return x.Steamid
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetGameMode() string {
+	if x != nil {
+		return x.GameMode
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetGameid() string {
+	if x != nil {
+		return x.Gameid
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetPlayersOnline() []*Player {
+	if x != nil {
+		return x.PlayersOnline
+	}
+	return nil
+}
+
+func (x *ServerInfo) GetCachedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CachedAt
+	}
+	return nil
+}
+
+func (x *ServerInfo) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetSources() []string {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+type Player struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Score uint32                 `protobuf:"varint,2,opt,name=score,proto3" json:"score,omitempty"`
+	// Seconds connected.
+	Duration      float32 `protobuf:"fixed32,3,opt,name=duration,proto3" json:"duration,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Player) Reset() {
+	*x = Player{}
+	mi := &file_mastersteam_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Player) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Player) ProtoMessage() {}
+
+func (x *Player) ProtoReflect() protoreflect.Message {
+	mi := &file_mastersteam_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Player.ProtoReflect.Descriptor instead.
+func (*Player) Descriptor() ([]byte, []int) {
+	return file_mastersteam_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Player) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Player) GetScore() uint32 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Player) GetDuration() float32 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+type GetPlayersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Players       []*Player              `protobuf:"bytes,2,rep,name=players,proto3" json:"players,omitempty"`
+	CachedAt      *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=cached_at,json=cachedAt,proto3" json:"cached_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPlayersResponse) Reset() {
+	*x = GetPlayersResponse{}
+	mi := &file_mastersteam_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPlayersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPlayersResponse) ProtoMessage() {}
+
+func (x *GetPlayersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mastersteam_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPlayersResponse.ProtoReflect.Descriptor instead.
+func (*GetPlayersResponse) Descriptor() ([]byte, []int) {
+	return file_mastersteam_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetPlayersResponse) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *GetPlayersResponse) GetPlayers() []*Player {
+	if x != nil {
+		return x.Players
+	}
+	return nil
+}
+
+func (x *GetPlayersResponse) GetCachedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CachedAt
+	}
+	return nil
+}
+
+type GetRulesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Rules         map[string]string      `protobuf:"bytes,2,rep,name=rules,proto3" json:"rules,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	CachedAt      *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=cached_at,json=cachedAt,proto3" json:"cached_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRulesResponse) Reset() {
+	*x = GetRulesResponse{}
+	mi := &file_mastersteam_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRulesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRulesResponse) ProtoMessage() {}
+
+func (x *GetRulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mastersteam_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRulesResponse.ProtoReflect.Descriptor instead.
+func (*GetRulesResponse) Descriptor() ([]byte, []int) {
+	return file_mastersteam_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetRulesResponse) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *GetRulesResponse) GetRules() map[string]string {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+func (x *GetRulesResponse) GetCachedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CachedAt
+	}
+	return nil
+}
+
+var File_mastersteam_proto protoreflect.FileDescriptor
+
+const file_mastersteam_proto_rawDesc = "" +
+	"\n" +
+	"\x11mastersteam.proto\x12\x0emastersteam.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x82\x01\n" +
+	"\x14SearchServersRequest\x12\x14\n" +
+	"\x05appid\x18\x01 \x01(\rR\x05appid\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x12\n" +
+	"\x04addr\x18\x03 \x01(\tR\x04addr\x12\x14\n" +
+	"\x05fresh\x18\x04 \x01(\bR\x05fresh\x12\x16\n" +
+	"\x06source\x18\x05 \x01(\tR\x06source\"<\n" +
+	"\x10GetServerRequest\x12\x12\n" +
+	"\x04addr\x18\x01 \x01(\tR\x04addr\x12\x14\n" +
+	"\x05fresh\x18\x02 \x01(\bR\x05fresh\"\xf3\x04\n" +
+	"\n" +
+	"ServerInfo\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x1a\n" +
+	"\bprotocol\x18\x02 \x01(\rR\bprotocol\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x10\n" +
+	"\x03map\x18\x04 \x01(\tR\x03map\x12\x16\n" +
+	"\x06folder\x18\x05 \x01(\tR\x06folder\x12\x12\n" +
+	"\x04game\x18\x06 \x01(\tR\x04game\x12\x18\n" +
+	"\aplayers\x18\a \x01(\rR\aplayers\x12\x1f\n" +
+	"\vmax_players\x18\b \x01(\rR\n" +
+	"maxPlayers\x12\x12\n" +
+	"\x04bots\x18\t \x01(\rR\x04bots\x12\x12\n" +
+	"\x04type\x18\n" +
+	" \x01(\tR\x04type\x12\x0e\n" +
+	"\x02os\x18\v \x01(\tR\x02os\x12\x1e\n" +
+	"\n" +
+	"visibility\x18\f \x01(\tR\n" +
+	"visibility\x12\x10\n" +
+	"\x03vac\x18\r \x01(\bR\x03vac\x12\x14\n" +
+	"\x05appid\x18\x0e \x01(\rR\x05appid\x12!\n" +
+	"\fgame_version\x18\x0f \x01(\tR\vgameVersion\x12\x12\n" +
+	"\x04port\x18\x10 \x01(\rR\x04port\x12\x18\n" +
+	"\asteamid\x18\x11 \x01(\tR\asteamid\x12\x1b\n" +
+	"\tgame_mode\x18\x12 \x01(\tR\bgameMode\x12\x16\n" +
+	"\x06gameid\x18\x13 \x01(\tR\x06gameid\x12=\n" +
+	"\x0eplayers_online\x18\x14 \x03(\v2\x16.mastersteam.v1.PlayerR\rplayersOnline\x127\n" +
+	"\tcached_at\x18\x15 \x01(\v2\x1a.google.protobuf.TimestampR\bcachedAt\x12\x14\n" +
+	"\x05error\x18\x16 \x01(\tR\x05error\x12\x18\n" +
+	"\asources\x18\x17 \x03(\tR\asources\"N\n" +
+	"\x06Player\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\rR\x05score\x12\x1a\n" +
+	"\bduration\x18\x03 \x01(\x02R\bduration\"\x8f\x01\n" +
+	"\x12GetPlayersResponse\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x120\n" +
+	"\aplayers\x18\x02 \x03(\v2\x16.mastersteam.v1.PlayerR\aplayers\x127\n" +
+	"\tcached_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\bcachedAt\"\xd8\x01\n" +
+	"\x10GetRulesResponse\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12A\n" +
+	"\x05rules\x18\x02 \x03(\v2+.mastersteam.v1.GetRulesResponse.RulesEntryR\x05rules\x127\n" +
+	"\tcached_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\bcachedAt\x1a8\n" +
+	"\n" +
+	"RulesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\xd1\x02\n" +
+	"\vMastersteam\x12S\n" +
+	"\rSearchServers\x12$.mastersteam.v1.SearchServersRequest\x1a\x1a.mastersteam.v1.ServerInfo0\x01\x12I\n" +
+	"\tGetServer\x12 .mastersteam.v1.GetServerRequest\x1a\x1a.mastersteam.v1.ServerInfo\x12R\n" +
+	"\n" +
+	"GetPlayers\x12 .mastersteam.v1.GetServerRequest\x1a\".mastersteam.v1.GetPlayersResponse\x12N\n" +
+	"\bGetRules\x12 .mastersteam.v1.GetServerRequest\x1a .mastersteam.v1.GetRulesResponseB%Z#github.com/cyxc1124/Mastersteam/rpcb\x06proto3"
+
+var (
+	file_mastersteam_proto_rawDescOnce sync.Once
+	file_mastersteam_proto_rawDescData []byte
+)
+
+func file_mastersteam_proto_rawDescGZIP() []byte {
+	file_mastersteam_proto_rawDescOnce.Do(func() {
+		file_mastersteam_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_mastersteam_proto_rawDesc), len(file_mastersteam_proto_rawDesc)))
+	})
+	return file_mastersteam_proto_rawDescData
+}
+
+var file_mastersteam_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_mastersteam_proto_goTypes = []any{
+	(*SearchServersRequest)(nil),  // 0: mastersteam.v1.SearchServersRequest
+	(*GetServerRequest)(nil),      // 1: mastersteam.v1.GetServerRequest
+	(*ServerInfo)(nil),            // 2: mastersteam.v1.ServerInfo
+	(*Player)(nil),                // 3: mastersteam.v1.Player
+	(*GetPlayersResponse)(nil),    // 4: mastersteam.v1.GetPlayersResponse
+	(*GetRulesResponse)(nil),      // 5: mastersteam.v1.GetRulesResponse
+	nil,                           // 6: mastersteam.v1.GetRulesResponse.RulesEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_mastersteam_proto_depIdxs = []int32{
+	3,  // 0: mastersteam.v1.ServerInfo.players_online:type_name -> mastersteam.v1.Player
+	7,  // 1: mastersteam.v1.ServerInfo.cached_at:type_name -> google.protobuf.Timestamp
+	3,  // 2: mastersteam.v1.GetPlayersResponse.players:type_name -> mastersteam.v1.Player
+	7,  // 3: mastersteam.v1.GetPlayersResponse.cached_at:type_name -> google.protobuf.Timestamp
+	6,  // 4: mastersteam.v1.GetRulesResponse.rules:type_name -> mastersteam.v1.GetRulesResponse.RulesEntry
+	7,  // 5: mastersteam.v1.GetRulesResponse.cached_at:type_name -> google.protobuf.Timestamp
+	0,  // 6: mastersteam.v1.Mastersteam.SearchServers:input_type -> mastersteam.v1.SearchServersRequest
+	1,  // 7: mastersteam.v1.Mastersteam.GetServer:input_type -> mastersteam.v1.GetServerRequest
+	1,  // 8: mastersteam.v1.Mastersteam.GetPlayers:input_type -> mastersteam.v1.GetServerRequest
+	1,  // 9: mastersteam.v1.Mastersteam.GetRules:input_type -> mastersteam.v1.GetServerRequest
+	2,  // 10: mastersteam.v1.Mastersteam.SearchServers:output_type -> mastersteam.v1.ServerInfo
+	2,  // 11: mastersteam.v1.Mastersteam.GetServer:output_type -> mastersteam.v1.ServerInfo
+	4,  // 12: mastersteam.v1.Mastersteam.GetPlayers:output_type -> mastersteam.v1.GetPlayersResponse
+	5,  // 13: mastersteam.v1.Mastersteam.GetRules:output_type -> mastersteam.v1.GetRulesResponse
+	10, // [10:14] is the sub-list for method output_type
+	6,  // [6:10] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_mastersteam_proto_init() }
+func file_mastersteam_proto_init() {
+	if File_mastersteam_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_mastersteam_proto_rawDesc), len(file_mastersteam_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_mastersteam_proto_goTypes,
+		DependencyIndexes: file_mastersteam_proto_depIdxs,
+		MessageInfos:      file_mastersteam_proto_msgTypes,
+	}.Build()
+	File_mastersteam_proto = out.File
+	file_mastersteam_proto_goTypes = nil
+	file_mastersteam_proto_depIdxs = nil
+}
@@ -0,0 +1,263 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: mastersteam.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Mastersteam_SearchServers_FullMethodName = "/mastersteam.v1.Mastersteam/SearchServers"
+	Mastersteam_GetServer_FullMethodName     = "/mastersteam.v1.Mastersteam/GetServer"
+	Mastersteam_GetPlayers_FullMethodName    = "/mastersteam.v1.Mastersteam/GetPlayers"
+	Mastersteam_GetRules_FullMethodName      = "/mastersteam.v1.Mastersteam/GetRules"
+)
+
+// MastersteamClient is the client API for Mastersteam service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Searches the Steam server list and queries game servers over A2S. This is
+// the gRPC counterpart of the HTTP API, and shares its configuration, query
+// pipeline, reply cache and metrics.
+type MastersteamClient interface {
+	// Look up servers on the Steam server list and query each of them. Servers
+	// are streamed as their replies arrive, not in list order. A search merged
+	// from several sources is deduped first, so its servers are only sent once
+	// they have all answered.
+	SearchServers(ctx context.Context, in *SearchServersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ServerInfo], error)
+	// Query one server's info, and its players if anyone is playing.
+	GetServer(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*ServerInfo, error)
+	// Query one server's players.
+	GetPlayers(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*GetPlayersResponse, error)
+	// Query one server's rules (cvars).
+	GetRules(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*GetRulesResponse, error)
+}
+
+type mastersteamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMastersteamClient(cc grpc.ClientConnInterface) MastersteamClient {
+	return &mastersteamClient{cc}
+}
+
+func (c *mastersteamClient) SearchServers(ctx context.Context, in *SearchServersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ServerInfo], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Mastersteam_ServiceDesc.Streams[0], Mastersteam_SearchServers_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SearchServersRequest, ServerInfo]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Mastersteam_SearchServersClient = grpc.ServerStreamingClient[ServerInfo]
+
+func (c *mastersteamClient) GetServer(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*ServerInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServerInfo)
+	err := c.cc.Invoke(ctx, Mastersteam_GetServer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mastersteamClient) GetPlayers(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*GetPlayersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPlayersResponse)
+	err := c.cc.Invoke(ctx, Mastersteam_GetPlayers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mastersteamClient) GetRules(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*GetRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetRulesResponse)
+	err := c.cc.Invoke(ctx, Mastersteam_GetRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MastersteamServer is the server API for Mastersteam service.
+// All implementations must embed UnimplementedMastersteamServer
+// for forward compatibility.
+//
+// Searches the Steam server list and queries game servers over A2S. This is
+// the gRPC counterpart of the HTTP API, and shares its configuration, query
+// pipeline, reply cache and metrics.
+type MastersteamServer interface {
+	// Look up servers on the Steam server list and query each of them. Servers
+	// are streamed as their replies arrive, not in list order. A search merged
+	// from several sources is deduped first, so its servers are only sent once
+	// they have all answered.
+	SearchServers(*SearchServersRequest, grpc.ServerStreamingServer[ServerInfo]) error
+	// Query one server's info, and its players if anyone is playing.
+	GetServer(context.Context, *GetServerRequest) (*ServerInfo, error)
+	// Query one server's players.
+	GetPlayers(context.Context, *GetServerRequest) (*GetPlayersResponse, error)
+	// Query one server's rules (cvars).
+	GetRules(context.Context, *GetServerRequest) (*GetRulesResponse, error)
+	mustEmbedUnimplementedMastersteamServer()
+}
+
+// UnimplementedMastersteamServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMastersteamServer struct{}
+
+func (UnimplementedMastersteamServer) SearchServers(*SearchServersRequest, grpc.ServerStreamingServer[ServerInfo]) error {
+	return status.Error(codes.Unimplemented, "method SearchServers not implemented")
+}
+func (UnimplementedMastersteamServer) GetServer(context.Context, *GetServerRequest) (*ServerInfo, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetServer not implemented")
+}
+func (UnimplementedMastersteamServer) GetPlayers(context.Context, *GetServerRequest) (*GetPlayersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPlayers not implemented")
+}
+func (UnimplementedMastersteamServer) GetRules(context.Context, *GetServerRequest) (*GetRulesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetRules not implemented")
+}
+func (UnimplementedMastersteamServer) mustEmbedUnimplementedMastersteamServer() {}
+func (UnimplementedMastersteamServer) testEmbeddedByValue()                     {}
+
+// UnsafeMastersteamServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MastersteamServer will
+// result in compilation errors.
+type UnsafeMastersteamServer interface {
+	mustEmbedUnimplementedMastersteamServer()
+}
+
+func RegisterMastersteamServer(s grpc.ServiceRegistrar, srv MastersteamServer) {
+	// If the following call panics, it indicates UnimplementedMastersteamServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Mastersteam_ServiceDesc, srv)
+}
+
+func _Mastersteam_SearchServers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchServersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MastersteamServer).SearchServers(m, &grpc.GenericServerStream[SearchServersRequest, ServerInfo]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Mastersteam_SearchServersServer = grpc.ServerStreamingServer[ServerInfo]
+
+func _Mastersteam_GetServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MastersteamServer).GetServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mastersteam_GetServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MastersteamServer).GetServer(ctx, req.(*GetServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mastersteam_GetPlayers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MastersteamServer).GetPlayers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mastersteam_GetPlayers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MastersteamServer).GetPlayers(ctx, req.(*GetServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mastersteam_GetRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MastersteamServer).GetRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mastersteam_GetRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MastersteamServer).GetRules(ctx, req.(*GetServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Mastersteam_ServiceDesc is the grpc.ServiceDesc for Mastersteam service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Mastersteam_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mastersteam.v1.Mastersteam",
+	HandlerType: (*MastersteamServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetServer",
+			Handler:    _Mastersteam_GetServer_Handler,
+		},
+		{
+			MethodName: "GetPlayers",
+			Handler:    _Mastersteam_GetPlayers_Handler,
+		},
+		{
+			MethodName: "GetRules",
+			Handler:    _Mastersteam_GetRules_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SearchServers",
+			Handler:       _Mastersteam_SearchServers_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mastersteam.proto",
+}
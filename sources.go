@@ -0,0 +1,295 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Server list sources, as named in ?source=.
+const (
+	// The Steam Web API, or the master server configured in its place.
+	kSourceMaster = "master"
+
+	// Servers listed in STATIC_SERVERS.
+	kSourceStatic = "static"
+
+	// Servers listed in SERVERS_FILE.
+	kSourceFile = "file"
+
+	// Servers named by the SERVERS_SRV DNS record.
+	kSourceSrv = "srv"
+
+	// Every configured source, merged.
+	kSourceAll = "all"
+)
+
+var (
+	// Servers for the static source, as host:port.
+	staticServers []string
+
+	// Watched file for the file source. If nil, the source isn't configured.
+	serverListFile *valve.ServerListFile
+
+	// DNS name of the SRV record for the srv source.
+	serversSrv string
+
+	// Sources used when a request doesn't pick any.
+	defaultSources = []string{kSourceMaster}
+)
+
+// A query result for a server that was dropped by its source's filters.
+var errFilteredOut = errors.New("server doesn't match the filters")
+
+// loadSourcesConfig sets up the extra server list sources from the
+// environment, and which are used by default.
+func loadSourcesConfig() {
+	staticServers = envList("STATIC_SERVERS")
+	serversSrv = envString("SERVERS_SRV", "")
+
+	if path := envString("SERVERS_FILE", ""); path != "" {
+		interval := envDuration("SERVERS_FILE_INTERVAL", 5*time.Second)
+		file, err := valve.WatchServerListFile(path, interval)
+		if err != nil {
+			log.Fatalf("Failed to read SERVERS_FILE: %v", err)
+		}
+		serverListFile = file
+	}
+
+	if spec := envString("DEFAULT_SOURCE", ""); spec != "" {
+		sources, err := parseSources(spec)
+		if err != nil {
+			log.Printf("⚠️  Invalid value for DEFAULT_SOURCE: %s, using %s", err, kSourceMaster)
+		} else {
+			defaultSources = sources
+		}
+	}
+
+	log.Printf("✓ Server list sources: %s (default %s)", strings.Join(configuredSources(), ", "), strings.Join(defaultSources, ","))
+}
+
+// configuredSources lists the names of the sources that are set up.
+func configuredSources() []string {
+	sources := []string{kSourceMaster}
+	if len(staticServers) > 0 {
+		sources = append(sources, kSourceStatic)
+	}
+	if serverListFile != nil {
+		sources = append(sources, kSourceFile)
+	}
+	if serversSrv != "" {
+		sources = append(sources, kSourceSrv)
+	}
+	return sources
+}
+
+// parseSources reads a comma-separated list of source names. Empty means the
+// default sources, and "all" every configured one.
+func parseSources(spec string) ([]string, error) {
+	if spec == "" {
+		return defaultSources, nil
+	}
+	configured := configuredSources()
+	if spec == kSourceAll {
+		return configured, nil
+	}
+
+	var sources []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(configured, name) {
+			return nil, fmt.Errorf("%q isn't one of: %s, %s", name, strings.Join(configured, ", "), kSourceAll)
+		}
+		if !slices.Contains(sources, name) {
+			sources = append(sources, name)
+		}
+	}
+	return sources, nil
+}
+
+// requestSources reads the sources a request asks for with ?source=.
+func requestSources(r *http.Request) ([]string, error) {
+	return parseSources(r.URL.Query().Get("source"))
+}
+
+// openSources creates a querier for the given sources, merging them if there
+// are several.
+func openSources(sources []string) (valve.MasterQuerier, error) {
+	if len(sources) == 1 {
+		return openSource(sources[0])
+	}
+
+	merged := valve.NewMultiMasterQuerier()
+	for _, name := range sources {
+		querier, err := openSource(name)
+		if err != nil {
+			merged.Close()
+			return nil, err
+		}
+		merged.Add(name, querier)
+	}
+	return merged, nil
+}
+
+// openSource creates a querier for one source.
+func openSource(name string) (valve.MasterQuerier, error) {
+	switch name {
+	case kSourceStatic:
+		return valve.NewStaticMasterQuerier(staticServers), nil
+	case kSourceFile:
+		return serverListFile.NewQuerier(), nil
+	case kSourceSrv:
+		return valve.NewSrvMasterQuerier(serversSrv), nil
+	default:
+		return newMasterQuerier()
+	}
+}
+
+// withInfoFilter makes the query options check servers against the
+// querier's filters, if its source can't apply them itself.
+func withInfoFilter(master valve.MasterQuerier, opts queryOptions) queryOptions {
+	if filterer, ok := master.(valve.InfoFilterer); ok {
+		opts.Match = filterer.MatchInfo
+	}
+	return opts
+}
+
+// needsInfo reports whether a server listed by master has to be queried to
+// check it against the filters, because its source couldn't apply them.
+func needsInfo(master valve.MasterQuerier, addr *net.TCPAddr) bool {
+	if merged, ok := master.(*valve.MultiMasterQuerier); ok {
+		return merged.NeedsInfo(addr)
+	}
+	_, ok := master.(valve.InfoFilterer)
+	return ok
+}
+
+// dropFiltered removes the servers that didn't match their source's filters.
+func dropFiltered(results []batch.Result[*net.TCPAddr, *ServerObject]) []batch.Result[*net.TCPAddr, *ServerObject] {
+	return slices.DeleteFunc(results, func(result batch.Result[*net.TCPAddr, *ServerObject]) bool {
+		return errors.Is(result.Err, errFilteredOut)
+	})
+}
+
+// A sourcedError is a query failure for a server listed by several merged
+// sources, recording which.
+type sourcedError struct {
+	err     error
+	sources []string
+}
+
+func (se *sourcedError) Error() string {
+	return se.err.Error()
+}
+
+func (se *sourcedError) Unwrap() error {
+	return se.err
+}
+
+// mergeResults dedupes the results of a search merged from several sources,
+// and records which sources listed each server. It leaves the results of a
+// single source as they are.
+//
+// Sources can list one server under different addresses: the Steam Web API
+// lists its query port, while a static list may have its game port, which
+// the server reports in its A2S_INFO reply. MultiMasterQuerier already skips
+// game addresses an earlier source reported; the rest cost a query each and
+// are matched here. Servers are the same if they share an address, a game
+// address or a SteamID. The first one that answered is kept, and a server
+// that didn't answer is dropped if another one answered for its address, its
+// sources going to the one kept.
+func mergeResults(master valve.MasterQuerier, results []batch.Result[*net.TCPAddr, *ServerObject]) []batch.Result[*net.TCPAddr, *ServerObject] {
+	merged, ok := master.(*valve.MultiMasterQuerier)
+	if !ok {
+		return results
+	}
+
+	// The kept result each key leads to, and the sources of each kept
+	// result.
+	kept := map[string]int{}
+	sources := make([][]string, len(results))
+	dropped := make([]bool, len(results))
+	addSources := func(i int, addr *net.TCPAddr) {
+		for _, name := range merged.Sources(addr) {
+			if !slices.Contains(sources[i], name) {
+				sources[i] = append(sources[i], name)
+			}
+		}
+	}
+
+	for i, result := range results {
+		if result.Err != nil || result.Value == nil {
+			continue
+		}
+		keys := serverKeys(result.Item, result.Value)
+		into := i
+		for _, key := range keys {
+			if j, ok := kept[key]; ok {
+				into = j
+				dropped[i] = true
+				break
+			}
+		}
+		for _, key := range keys {
+			if _, ok := kept[key]; !ok {
+				kept[key] = into
+			}
+		}
+		addSources(into, result.Item)
+	}
+
+	for i, result := range results {
+		if result.Err == nil && result.Value != nil {
+			continue
+		}
+		addr := valve.CanonicalAddr(result.Item).String()
+		if j, ok := kept["addr "+addr]; ok {
+			dropped[i] = true
+			addSources(j, result.Item)
+		} else if j, ok := kept["game "+addr]; ok {
+			dropped[i] = true
+			addSources(j, result.Item)
+		} else {
+			addSources(i, result.Item)
+		}
+	}
+
+	out := results[:0]
+	for i, result := range results {
+		if dropped[i] {
+			continue
+		}
+		if result.Err != nil || result.Value == nil {
+			result.Err = &sourcedError{result.Err, sources[i]}
+		} else {
+			result.Value.Sources = sources[i]
+		}
+		out = append(out, result)
+	}
+	return out
+}
+
+// serverKeys returns the keys a server that answered is known by: its
+// address, the address of its game port and its SteamID, where known.
+func serverKeys(addr *net.TCPAddr, server *ServerObject) []string {
+	canonical := valve.CanonicalAddr(addr)
+	keys := []string{"addr " + canonical.String()}
+	if server.Port != 0 {
+		game := netip.AddrPortFrom(canonical.Addr(), server.Port)
+		keys = append(keys, "game "+game.String())
+	}
+	if server.SteamID != "" && server.SteamID != "0" {
+		keys = append(keys, "steamid "+server.SteamID)
+	}
+	return keys
+}
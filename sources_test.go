@@ -0,0 +1,207 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	batch "github.com/cyxc1124/Mastersteam/batch"
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+func setStaticServers(t *testing.T, addrs ...string) {
+	old := staticServers
+	staticServers = addrs
+	t.Cleanup(func() { staticServers = old })
+}
+
+func TestParseSources(t *testing.T) {
+	setStaticServers(t, "10.0.0.1:27015")
+
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"", []string{kSourceMaster}},
+		{"static", []string{kSourceStatic}},
+		{"static, master,static", []string{kSourceStatic, kSourceMaster}},
+		{"all", []string{kSourceMaster, kSourceStatic}},
+	}
+	for _, test := range tests {
+		sources, err := parseSources(test.spec)
+		if err != nil || !reflect.DeepEqual(sources, test.want) {
+			t.Errorf("%q: got %v, %v, want %v", test.spec, sources, err, test.want)
+		}
+	}
+
+	for _, bad := range []string{"steam", "file", "static,"} {
+		if _, err := parseSources(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestSearchStaticSource(t *testing.T) {
+	source := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	goldsrc := newTestGameServer(t, a2stest.GoldSrcInfo(), nil)
+	setStaticServers(t, source.Addr(), goldsrc.Addr())
+
+	// The list can't be filtered, so servers are checked once they answer.
+	response := decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/*?source=static"))
+	if response.Total != 1 {
+		t.Fatalf("got total %d, want 1", response.Total)
+	}
+	decodeServer(t, response, source.Addr())
+
+	response = decodeSearch(t, doRequest(t, httpMasterSearch, "/search/10/a2stest*?source=static"))
+	if response.Total != 1 {
+		t.Fatalf("got total %d, want 1", response.Total)
+	}
+	decodeServer(t, response, goldsrc.Addr())
+
+	recorder := doRequest(t, httpMasterSearch, "/search/440/*?source=nowhere")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown source", recorder.Code)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, `"error":"Invalid source: \"nowhere\" isn't one of: `) {
+		t.Errorf("got %s", body)
+	}
+}
+
+func TestSearchMergedSources(t *testing.T) {
+	shared := newTestGameServer(t, a2stest.SourceInfo(), nil)
+	otherInfo := a2stest.SourceInfo()
+	otherInfo.Ext.Port = 27016
+	otherInfo.Ext.SteamId++
+	other := newTestGameServer(t, otherInfo, nil)
+	goldsrc := newTestGameServer(t, a2stest.GoldSrcInfo(), nil)
+	newTestWebAPI(t, webapitest.Entry{Addr: shared.Addr(), Appid: 440})
+	setStaticServers(t, shared.Addr(), other.Addr(), goldsrc.Addr())
+
+	// The server listed by both sources appears once, with both recorded.
+	response := decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/*?source=all"))
+	if response.Total != 2 {
+		t.Fatalf("got total %d, want 2", response.Total)
+	}
+	if server := decodeServer(t, response, shared.Addr()); !reflect.DeepEqual(server.Sources, []string{kSourceMaster, kSourceStatic}) {
+		t.Errorf("got sources %v", server.Sources)
+	}
+	if server := decodeServer(t, response, other.Addr()); !reflect.DeepEqual(server.Sources, []string{kSourceStatic}) {
+		t.Errorf("got sources %v", server.Sources)
+	}
+
+	// A single source doesn't record any.
+	response = decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/*?source=static"))
+	if server := decodeServer(t, response, shared.Addr()); server.Sources != nil {
+		t.Errorf("got sources %v", server.Sources)
+	}
+}
+
+func TestSearchMergedGamePorts(t *testing.T) {
+	// The server's query port is its own; its game port has nothing on it,
+	// so querying the game address fails.
+	info := a2stest.SourceInfo()
+	info.Ext.Port = 2
+	live := newTestGameServer(t, info, nil)
+	gameAddr := "127.0.0.1:2"
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: live.Addr(), Gameport: 2, Appid: 440},
+		webapitest.Entry{Addr: "127.0.0.1:1", Gameport: 1, Appid: 440},
+	)
+	setStaticServers(t, gameAddr, "127.0.0.1:1")
+
+	search := func(sources string, want, deadWant []string) {
+		response := decodeSearch(t, doRequest(t, httpMasterSearch, "/search/440/*?fresh=1&source="+sources))
+		if response.Total != 2 {
+			t.Fatalf("%s: got total %d, want 2", sources, response.Total)
+		}
+		if server := decodeServer(t, response, live.Addr()); !reflect.DeepEqual(server.Sources, want) {
+			t.Errorf("%s: got sources %v, want %v", sources, server.Sources, want)
+		}
+		if _, ok := response.Data[0][gameAddr]; ok {
+			t.Errorf("%s: the game address is listed too", sources)
+		}
+
+		var dead ErrorObject
+		if err := json.Unmarshal(response.Data[0]["127.0.0.1:1"], &dead); err != nil || dead.Error == "" {
+			t.Fatalf("%s: got %+v, %v", sources, dead, err)
+		}
+		if !reflect.DeepEqual(dead.Sources, deadWant) {
+			t.Errorf("%s: got sources %v on the error, want %v", sources, dead.Sources, deadWant)
+		}
+	}
+
+	// Listed by the Web API first, the game address is known to be the same
+	// server and isn't queried.
+	search("master,static", []string{kSourceMaster, kSourceStatic}, []string{kSourceMaster, kSourceStatic})
+	if queries := live.Queries(); queries != 1 {
+		t.Errorf("got %d queries, want 1", queries)
+	}
+
+	// Listed by the static list first, both addresses are queried, and the
+	// one that failed is merged into the one that answered.
+	search("static,master", []string{kSourceMaster, kSourceStatic}, []string{kSourceStatic, kSourceMaster})
+}
+
+func TestMergeResults(t *testing.T) {
+	// The master lists the query port, the static list the game port, and
+	// the file list the same server behind another address.
+	master := valve.NewMultiMasterQuerier()
+	master.Add(kSourceMaster, valve.NewStaticMasterQuerier([]string{"10.0.0.1:27016", "10.0.0.2:27015"}))
+	master.Add(kSourceStatic, valve.NewStaticMasterQuerier([]string{"10.0.0.1:27015", "10.0.0.3:27015"}))
+	master.Add(kSourceFile, valve.NewStaticMasterQuerier([]string{"10.0.0.4:27015", "10.0.0.2:27015"}))
+	if err := master.Query(func(valve.ServerList) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	result := func(addr string, server *ServerObject, err error) batch.Result[*net.TCPAddr, *ServerObject] {
+		tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+		return batch.Result[*net.TCPAddr, *ServerObject]{Item: tcpAddr, Value: server, Err: err}
+	}
+	refused := errors.New("connection refused")
+	results := []batch.Result[*net.TCPAddr, *ServerObject]{
+		result("10.0.0.1:27016", &ServerObject{Port: 27015, SteamID: "1"}, nil),
+		result("10.0.0.2:27015", nil, refused),
+		result("10.0.0.1:27015", nil, refused),
+		result("10.0.0.3:27015", &ServerObject{Port: 27015, SteamID: "3"}, nil),
+		result("10.0.0.4:27015", &ServerObject{Port: 27015, SteamID: "3"}, nil),
+	}
+
+	merged := mergeResults(master, results)
+	if len(merged) != 3 {
+		t.Fatalf("got %d results, want 3", len(merged))
+	}
+	want := []struct {
+		addr    string
+		sources []string
+	}{
+		{"10.0.0.1:27016", []string{kSourceMaster, kSourceStatic}},
+		{"10.0.0.2:27015", []string{kSourceMaster, kSourceFile}},
+		{"10.0.0.3:27015", []string{kSourceStatic, kSourceFile}},
+	}
+	for i, want := range want {
+		result := merged[i]
+		if result.Item.String() != want.addr {
+			t.Fatalf("%d: got %s, want %s", i, result.Item, want.addr)
+		}
+		var sources []string
+		if result.Err != nil {
+			sources = newErrorObject(want.addr, result.Err).Sources
+		} else {
+			sources = result.Value.Sources
+		}
+		if !reflect.DeepEqual(sources, want.sources) {
+			t.Errorf("%s: got sources %v, want %v", want.addr, sources, want.sources)
+		}
+	}
+	if !errors.Is(merged[1].Err, refused) {
+		t.Errorf("got error %v", merged[1].Err)
+	}
+}
@@ -0,0 +1,214 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package a2stest
+
+import (
+	"sort"
+	"strconv"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+var oobHeader = []byte{0xff, 0xff, 0xff, 0xff}
+
+// Encode an S2A_INFO reply. The wire format is chosen by info.InfoVersion.
+func EncodeInfo(info *valve.ServerInfo) []byte {
+	if info.InfoVersion == valve.S2A_INFO_GOLDSRC {
+		return encodeOldInfo(info)
+	}
+	return encodeNewInfo(info)
+}
+
+func encodeServerType(st valve.ServerType) uint8 {
+	switch st {
+	case valve.ServerType_Dedicated:
+		return 'd'
+	case valve.ServerType_Listen:
+		return 'l'
+	case valve.ServerType_HLTV:
+		return 'p'
+	default:
+		return 0
+	}
+}
+
+func encodeServerOS(so valve.ServerOS) uint8 {
+	switch so {
+	case valve.ServerOS_Linux:
+		return 'l'
+	case valve.ServerOS_Windows:
+		return 'w'
+	case valve.ServerOS_Mac:
+		return 'm'
+	default:
+		return 0
+	}
+}
+
+func encodeNewInfo(info *valve.ServerInfo) []byte {
+	ext := info.Ext
+	if ext == nil {
+		ext = &valve.ExtendedInfo{}
+	}
+
+	var packet valve.PacketBuilder
+	packet.WriteBytes(oobHeader)
+	packet.WriteUint8(valve.S2A_INFO_SOURCE)
+	packet.WriteUint8(info.Protocol)
+	packet.WriteCString(info.Name)
+	packet.WriteCString(info.MapName)
+	packet.WriteCString(info.Folder)
+	packet.WriteCString(info.Game)
+	packet.WriteUint16(uint16(ext.AppId))
+	packet.WriteUint8(info.Players)
+	packet.WriteUint8(info.MaxPlayers)
+	packet.WriteUint8(info.Bots)
+	packet.WriteUint8(encodeServerType(info.Type))
+	packet.WriteUint8(encodeServerOS(info.OS))
+	packet.WriteUint8(info.Visibility)
+	packet.WriteUint8(info.Vac)
+
+	if ext.AppId == valve.App_TheShip {
+		ship := info.TheShip
+		if ship == nil {
+			ship = &valve.TheShipInfo{}
+		}
+		packet.WriteUint8(ship.Mode)
+		packet.WriteUint8(ship.Witnesses)
+		packet.WriteUint8(ship.Duration)
+	}
+
+	packet.WriteCString(ext.GameVersion)
+
+	var edf uint8
+	if ext.Port != 0 {
+		edf |= 0x80
+	}
+	if ext.SteamId != 0 {
+		edf |= 0x10
+	}
+	if info.SpecTv != nil {
+		edf |= 0x40
+	}
+	if ext.GameModeDescription != "" {
+		edf |= 0x20
+	}
+	if ext.GameId != 0 {
+		edf |= 0x01
+	}
+	if edf == 0 {
+		return packet.Bytes()
+	}
+
+	packet.WriteUint8(edf)
+	if ext.Port != 0 {
+		packet.WriteUint16(ext.Port)
+	}
+	if ext.SteamId != 0 {
+		packet.WriteUint64(ext.SteamId)
+	}
+	if info.SpecTv != nil {
+		packet.WriteUint16(info.SpecTv.Port)
+		packet.WriteCString(info.SpecTv.Name)
+	}
+	if ext.GameModeDescription != "" {
+		packet.WriteCString(ext.GameModeDescription)
+	}
+	if ext.GameId != 0 {
+		packet.WriteUint64(ext.GameId)
+	}
+	return packet.Bytes()
+}
+
+func encodeOldInfo(info *valve.ServerInfo) []byte {
+	var packet valve.PacketBuilder
+	packet.WriteBytes(oobHeader)
+	packet.WriteUint8(valve.S2A_INFO_GOLDSRC)
+	packet.WriteCString(info.Address)
+	packet.WriteCString(info.Name)
+	packet.WriteCString(info.MapName)
+	packet.WriteCString(info.Folder)
+	packet.WriteCString(info.Game)
+	packet.WriteUint8(info.Players)
+	packet.WriteUint8(info.MaxPlayers)
+	packet.WriteUint8(info.Protocol)
+	packet.WriteUint8(encodeServerType(info.Type))
+	packet.WriteUint8(encodeServerOS(info.OS))
+	packet.WriteUint8(info.Visibility)
+
+	if info.Mod != nil {
+		packet.WriteUint8(1)
+		packet.WriteCString(info.Mod.Url)
+		packet.WriteCString(info.Mod.DwlUrl)
+		packet.WriteUint8(0)
+		packet.WriteUint32(info.Mod.Version)
+		packet.WriteUint32(info.Mod.Size)
+		packet.WriteUint8(info.Mod.Type)
+		packet.WriteUint8(info.Mod.Dll)
+	} else {
+		packet.WriteUint8(0)
+	}
+
+	packet.WriteUint8(info.Vac)
+	packet.WriteUint8(info.Bots)
+	return packet.Bytes()
+}
+
+// Encode an S2A_PLAYER reply.
+func EncodePlayers(players []*valve.Player) []byte {
+	var packet valve.PacketBuilder
+	packet.WriteBytes(oobHeader)
+	packet.WriteUint8(valve.S2A_PLAYER)
+	packet.WriteUint8(uint8(len(players)))
+	for index, player := range players {
+		packet.WriteUint8(uint8(index))
+		packet.WriteCString(player.Name)
+		packet.WriteUint32(player.Score)
+		packet.WriteFloat32(player.Duration)
+	}
+	return packet.Bytes()
+}
+
+// Encode an S2A_RULES reply. Rules are sorted by name so the output is stable.
+func EncodeRules(rules map[string]string) []byte {
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var packet valve.PacketBuilder
+	packet.WriteBytes(oobHeader)
+	packet.WriteUint8(valve.S2A_RULES)
+	packet.WriteUint16(uint16(len(keys)))
+	for _, key := range keys {
+		packet.WriteCString(key)
+		packet.WriteCString(rules[key])
+	}
+	return packet.Bytes()
+}
+
+// Encode an S2C_CHALLENGE reply.
+func EncodeChallenge(challenge uint32) []byte {
+	var packet valve.PacketBuilder
+	packet.WriteBytes(oobHeader)
+	packet.WriteUint8(valve.S2C_CHALLENGE)
+	packet.WriteUint32(challenge)
+	return packet.Bytes()
+}
+
+// Encode a GoldSrc "challenge rcon" reply.
+func EncodeRconChallenge(challenge uint32) []byte {
+	var packet valve.PacketBuilder
+	packet.WriteBytes(oobHeader)
+	packet.WriteCString("challenge rcon " + strconv.FormatUint(uint64(challenge), 10) + "\n")
+	return packet.Bytes()
+}
+
+// Encode a GoldSrc S2A_RCON reply.
+func EncodeRconReply(output string) []byte {
+	var packet valve.PacketBuilder
+	packet.WriteBytes(oobHeader)
+	packet.WriteUint8(valve.S2A_RCON)
+	packet.WriteCString(output)
+	return packet.Bytes()
+}
@@ -0,0 +1,31 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package a2stest
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Answer a GoldSrc "challenge rcon" or "rcon" request the way HLDS does.
+// Wrong passwords and challenges get an error message as the reply.
+func (s *Server) handleRcon(request []byte) [][]byte {
+	text := strings.TrimRight(string(request), "\x00\n")
+	if text == "challenge rcon" {
+		return [][]byte{EncodeRconChallenge(s.Challenge)}
+	}
+
+	// rcon <challenge> "<password>" <command>
+	rest, ok := strings.CutPrefix(text, "rcon ")
+	if !ok {
+		return nil
+	}
+	challenge, rest, _ := strings.Cut(rest, " ")
+	if challenge != strconv.FormatUint(uint64(s.Challenge), 10) {
+		return [][]byte{EncodeRconReply("Bad challenge.\n")}
+	}
+	password, command, ok := strings.Cut(strings.TrimPrefix(rest, "\""), "\" ")
+	if !ok || password != s.RconPassword {
+		return [][]byte{EncodeRconReply("Bad rcon_password.\n")}
+	}
+	return s.split(EncodeRconReply(s.outputs[command]))
+}
@@ -0,0 +1,288 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Package a2stest runs a fake A2S responder on a local UDP port, so code that
+// queries game servers can be exercised without real ones.
+package a2stest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// A Server answers A2S_INFO, A2S_PLAYER and A2S_RULES queries with canned
+// data. The option fields must be set before Start; the served data can be
+// changed at any time through the setters.
+type Server struct {
+	// Challenge handed out in S2C_CHALLENGE replies.
+	Challenge uint32
+
+	// Require a challenge on A2S_INFO, as servers have done since late 2020.
+	InfoChallenge bool
+
+	// Replies larger than this are split into multiple packets.
+	MaxPacketSize int
+
+	// Compress split replies with bzip2. This only applies to Source servers.
+	Compress bool
+
+	// Reply to A2S_INFO with an S2A_PLAYER packet first, followed by another
+	// S2A_PLAYER and then the real info, like some Half-Life 1 servers do.
+	MistakenReply bool
+
+	// Send every fragment of a split reply twice.
+	DuplicatePackets bool
+
+	// Reply to the A2S_RULES challenge request with the rules immediately.
+	ImmediateRulesReply bool
+
+	// Ignore this many requests before answering any, as if they were lost.
+	DropRequests int
+
+	// Wait this long before sending each reply.
+	ReplyDelay time.Duration
+
+	// Password for GoldSrc "challenge rcon" commands. If empty, RCON
+	// requests are ignored.
+	RconPassword string
+
+	conn net.PacketConn
+	done chan struct{}
+
+	mu      sync.Mutex
+	info    *valve.ServerInfo
+	players []*valve.Player
+	rules   map[string]string
+	outputs map[string]string
+	splitId uint32
+	queries int
+}
+
+// Create a server that is not listening yet. Configure its options, then call
+// Start.
+func NewUnstartedServer(info *valve.ServerInfo) *Server {
+	return &Server{
+		Challenge:     0x12345678,
+		MaxPacketSize: 1400,
+		info:          info,
+		rules:         map[string]string{},
+		outputs:       map[string]string{},
+	}
+}
+
+// Create and start a server with default options.
+func NewServer(info *valve.ServerInfo) *Server {
+	server := NewUnstartedServer(info)
+	server.Start()
+	return server
+}
+
+// A typical Source (Orange Box) server.
+func SourceInfo() *valve.ServerInfo {
+	return &valve.ServerInfo{
+		InfoVersion: valve.S2A_INFO_SOURCE,
+		Protocol:    17,
+		Name:        "a2stest Source server",
+		MapName:     "cp_badlands",
+		Folder:      "tf",
+		Game:        "Team Fortress",
+		MaxPlayers:  24,
+		Type:        valve.ServerType_Dedicated,
+		OS:          valve.ServerOS_Linux,
+		Vac:         1,
+		Ext: &valve.ExtendedInfo{
+			AppId:               valve.App_TF2,
+			GameVersion:         "8835751",
+			Port:                27015,
+			SteamId:             90071992547409920,
+			GameModeDescription: "cp,increased_maxplayers",
+			GameId:              uint64(valve.App_TF2),
+		},
+	}
+}
+
+// A typical Half-Life 1 server answering with the old info format.
+func GoldSrcInfo() *valve.ServerInfo {
+	return &valve.ServerInfo{
+		InfoVersion: valve.S2A_INFO_GOLDSRC,
+		Address:     "127.0.0.1:27015",
+		Protocol:    47,
+		Name:        "a2stest GoldSrc server",
+		MapName:     "de_dust2",
+		Folder:      "cstrike",
+		Game:        "Counter-Strike",
+		MaxPlayers:  32,
+		Type:        valve.ServerType_Dedicated,
+		OS:          valve.ServerOS_Linux,
+		Vac:         1,
+	}
+}
+
+// Start listening on a random local port.
+func (s *Server) Start() {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic("a2stest: failed to listen: " + err.Error())
+	}
+	s.conn = conn
+	s.done = make(chan struct{})
+	go s.serve()
+}
+
+// The address the server is listening on, as host:port.
+func (s *Server) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Stop the server and wait for it to exit.
+func (s *Server) Close() {
+	s.conn.Close()
+	<-s.done
+}
+
+// Replace the info returned by A2S_INFO.
+func (s *Server) SetInfo(info *valve.ServerInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = info
+}
+
+// Replace the players returned by A2S_PLAYER.
+func (s *Server) SetPlayers(players []*valve.Player) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.players = players
+}
+
+// Replace the rules returned by A2S_RULES.
+func (s *Server) SetRules(rules map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+}
+
+// Set the output of a GoldSrc RCON command. Commands without one answer
+// with an empty reply.
+func (s *Server) SetRconOutput(command string, output string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputs[command] = output
+}
+
+// The number of queries received so far, including challenge requests.
+func (s *Server) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func (s *Server) serve() {
+	defer close(s.done)
+
+	buffer := make([]byte, 1400)
+	for {
+		n, addr, err := s.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		replies := s.handle(buffer[:n])
+		if s.ReplyDelay > 0 {
+			time.AfterFunc(s.ReplyDelay, func() {
+				s.send(replies, addr)
+			})
+			continue
+		}
+		s.send(replies, addr)
+	}
+}
+
+func (s *Server) send(replies [][]byte, addr net.Addr) {
+	for _, reply := range replies {
+		s.conn.WriteTo(reply, addr)
+	}
+}
+
+func (s *Server) handle(request []byte) [][]byte {
+	if len(request) < 5 || !bytes.Equal(request[:4], oobHeader) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queries++
+	if s.queries <= s.DropRequests {
+		return nil
+	}
+
+	switch request[4] {
+	case valve.A2S_INFO:
+		return s.handleInfo(request)
+	case valve.A2S_PLAYER:
+		if !s.hasChallenge(request[5:]) {
+			return [][]byte{EncodeChallenge(s.Challenge)}
+		}
+		return s.split(EncodePlayers(s.players))
+	case valve.A2S_RULES:
+		if !s.hasChallenge(request[5:]) {
+			if s.ImmediateRulesReply {
+				return [][]byte{EncodeRules(s.rules)}
+			}
+			return [][]byte{EncodeChallenge(s.Challenge)}
+		}
+		return s.split(EncodeRules(s.rules))
+	}
+	if s.RconPassword != "" {
+		return s.handleRcon(request[4:])
+	}
+	return nil
+}
+
+func (s *Server) handleInfo(request []byte) [][]byte {
+	payload, ok := bytes.CutPrefix(request[5:], []byte("Source Engine Query\x00"))
+	if !ok {
+		return nil
+	}
+	if s.InfoChallenge && !s.hasChallenge(payload) {
+		return [][]byte{EncodeChallenge(s.Challenge)}
+	}
+
+	info := EncodeInfo(s.info)
+	if s.MistakenReply {
+		players := EncodePlayers(s.players)
+		return [][]byte{players, players, info}
+	}
+	return [][]byte{info}
+}
+
+func (s *Server) hasChallenge(payload []byte) bool {
+	return len(payload) >= 4 && binary.LittleEndian.Uint32(payload) == s.Challenge
+}
+
+func (s *Server) split(reply []byte) [][]byte {
+	if len(reply) <= s.MaxPacketSize {
+		return [][]byte{reply}
+	}
+
+	s.splitId++
+	id := s.splitId
+	compress := s.Compress && s.info.GameEngine() == valve.SOURCE
+	if compress {
+		reply = CompressReply(reply)
+		id |= 0x80000000
+	}
+
+	packets := SplitReply(s.info, id, reply, s.MaxPacketSize)
+	if s.DuplicatePackets {
+		var duplicated [][]byte
+		for _, packet := range packets {
+			duplicated = append(duplicated, packet, packet)
+		}
+		packets = duplicated
+	}
+	return packets
+}
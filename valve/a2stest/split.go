@@ -0,0 +1,78 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package a2stest
+
+import (
+	"bytes"
+	"hash/crc32"
+
+	"github.com/dsnet/compress/bzip2"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// Split a reply into multi-packet fragments, each at most maxPacketSize bytes
+// including its header. The header layout depends on the engine of the
+// server described by info.
+func SplitReply(info *valve.ServerInfo, id uint32, reply []byte, maxPacketSize int) [][]byte {
+	var headerSize int
+	switch {
+	case info.GameEngine() == valve.GOLDSRC:
+		headerSize = 9
+	case info.IsPreOrangeBox():
+		headerSize = 10
+	default:
+		headerSize = 12
+	}
+
+	chunkSize := maxPacketSize - headerSize
+	var chunks [][]byte
+	for len(reply) > chunkSize {
+		chunks = append(chunks, reply[:chunkSize])
+		reply = reply[chunkSize:]
+	}
+	chunks = append(chunks, reply)
+
+	packets := make([][]byte, len(chunks))
+	for number, chunk := range chunks {
+		var packet valve.PacketBuilder
+		packet.WriteInt32(-2)
+		packet.WriteUint32(id)
+
+		if info.GameEngine() == valve.GOLDSRC {
+			packet.WriteUint8(uint8(number<<4) | uint8(len(chunks)&0xf))
+		} else {
+			packet.WriteUint8(uint8(len(chunks)))
+			packet.WriteUint8(uint8(number))
+			if !info.IsPreOrangeBox() {
+				packet.WriteUint16(uint16(maxPacketSize))
+			}
+		}
+
+		packet.WriteBytes(chunk)
+		packets[number] = packet.Bytes()
+	}
+	return packets
+}
+
+// Compress a reply the way Source servers do before splitting it: the
+// decompressed size and CRC32 followed by a bzip2 stream. The caller must set
+// the high bit of the multi-packet id.
+func CompressReply(reply []byte) []byte {
+	var compressed bytes.Buffer
+	writer, err := bzip2.NewWriter(&compressed, nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := writer.Write(reply); err != nil {
+		panic(err)
+	}
+	if err := writer.Close(); err != nil {
+		panic(err)
+	}
+
+	var packet valve.PacketBuilder
+	packet.WriteUint32(uint32(len(reply)))
+	packet.WriteUint32(crc32.ChecksumIEEE(reply))
+	packet.WriteBytes(compressed.Bytes())
+	return packet.Bytes()
+}
@@ -115,6 +115,19 @@ var HL2Apps = []AppId{
 	App_IOSoccer,
 }
 
+// GoldSrc apps by game folder, for heartbeats and replies that don't carry an
+// AppID.
+var goldSrcAppIds = map[string]AppId{
+	"cstrike":  App_CS,
+	"tfc":      App_TFC,
+	"dod":      App_DOD,
+	"dmc":      App_DMC,
+	"gearbox":  App_OP4,
+	"ricochet": App_Ricochet,
+	"valve":    App_HL,
+	"czero":    App_CS_CZ,
+}
+
 func IsPreOrangeBoxApp(appId AppId) bool {
 	switch appId {
 	case App_SDK2006, App_EternalSilence, App_InsurgencyMod, App_Neotokyo, App_FortressForever:
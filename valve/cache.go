@@ -0,0 +1,231 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lifetimes and size of an A2SCache. A zero TTL disables caching of that
+// kind of reply.
+type CacheConfig struct {
+	InfoTTL    time.Duration
+	PlayersTTL time.Duration
+	RulesTTL   time.Duration
+
+	// How long a query that timed out is remembered, so an unreachable
+	// server isn't queried again by every request.
+	NegativeTTL time.Duration
+
+	// Maximum number of server addresses kept. The least recently used
+	// address is evicted first.
+	MaxEntries int
+}
+
+// A cached reply, or a cached failure.
+type CachedReply[T any] struct {
+	Value T
+
+	// Set if the query failed. Only timeouts are cached.
+	Err error
+
+	// When the reply was received.
+	CachedAt time.Time
+
+	expires time.Time
+}
+
+// An A2SCache holds recent A2S replies by server address. It is safe for
+// concurrent use.
+type A2SCache struct {
+	config CacheConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List // Of *cacheEntry, most recently used first.
+	entries map[string]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// Counters for an A2SCache.
+type CacheStats struct {
+	Entries int
+	Hits    uint64
+	Misses  uint64
+}
+
+type cacheEntry struct {
+	addr    string
+	info    *CachedReply[*ServerInfo]
+	players *CachedReply[[]*Player]
+	rules   *CachedReply[map[string]string]
+}
+
+func NewA2SCache(config CacheConfig) *A2SCache {
+	return &A2SCache{
+		config:  config,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Look up a server's info.
+func (c *A2SCache) Info(addr string) (*CachedReply[*ServerInfo], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.get(addr)
+	if entry == nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	if !cachedReplyLive(c, entry.info) {
+		return nil, false
+	}
+	return entry.info, true
+}
+
+// Look up a server's player list.
+func (c *A2SCache) Players(addr string) (*CachedReply[[]*Player], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.get(addr)
+	if entry == nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	if !cachedReplyLive(c, entry.players) {
+		return nil, false
+	}
+	return entry.players, true
+}
+
+// Look up a server's rules.
+func (c *A2SCache) Rules(addr string) (*CachedReply[map[string]string], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.get(addr)
+	if entry == nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	if !cachedReplyLive(c, entry.rules) {
+		return nil, false
+	}
+	return entry.rules, true
+}
+
+// Store the outcome of an A2S_INFO query.
+func (c *A2SCache) PutInfo(addr string, info *ServerInfo, err error) {
+	reply, ok := newCachedReply(c, info, err, c.config.InfoTTL)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(addr).info = reply
+}
+
+// Store the outcome of an A2S_PLAYER query.
+func (c *A2SCache) PutPlayers(addr string, players []*Player, err error) {
+	reply, ok := newCachedReply(c, players, err, c.config.PlayersTTL)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(addr).players = reply
+}
+
+// Store the outcome of an A2S_RULES query.
+func (c *A2SCache) PutRules(addr string, rules map[string]string, err error) {
+	reply, ok := newCachedReply(c, rules, err, c.config.RulesTTL)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(addr).rules = reply
+}
+
+func (c *A2SCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Entries: entries,
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+	}
+}
+
+// Build a cache record, or return false if this outcome shouldn't be cached.
+func newCachedReply[T any](c *A2SCache, value T, err error, ttl time.Duration) (*CachedReply[T], bool) {
+	if ttl <= 0 {
+		return nil, false
+	}
+	if err != nil {
+		if !IsTimeout(err) || c.config.NegativeTTL <= 0 {
+			return nil, false
+		}
+		ttl = c.config.NegativeTTL
+	}
+
+	now := c.now()
+	return &CachedReply[T]{
+		Value:    value,
+		Err:      err,
+		CachedAt: now,
+		expires:  now.Add(ttl),
+	}, true
+}
+
+// Whether a record exists and hasn't expired, counting the lookup as a hit
+// or miss. The caller holds mu.
+func cachedReplyLive[T any](c *A2SCache, reply *CachedReply[T]) bool {
+	if reply != nil && c.now().Before(reply.expires) {
+		c.hits.Add(1)
+		return true
+	}
+	c.misses.Add(1)
+	return false
+}
+
+// Find an entry and mark it as recently used. The caller holds mu.
+func (c *A2SCache) get(addr string) *cacheEntry {
+	elem, ok := c.entries[addr]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry)
+}
+
+// Find or create an entry, evicting the oldest if the cache is full. The
+// caller holds mu.
+func (c *A2SCache) put(addr string) *cacheEntry {
+	if entry := c.get(addr); entry != nil {
+		return entry
+	}
+
+	entry := &cacheEntry{addr: addr}
+	c.entries[addr] = c.lru.PushFront(entry)
+
+	for c.config.MaxEntries > 0 && c.lru.Len() > c.config.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).addr)
+	}
+	return entry
+}
@@ -0,0 +1,90 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "i/o timeout" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+func newTestCache(config CacheConfig) (*A2SCache, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	cache := NewA2SCache(config)
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func TestCacheExpiry(t *testing.T) {
+	cache, now := newTestCache(CacheConfig{InfoTTL: 10 * time.Second, PlayersTTL: 2 * time.Second})
+
+	info := &ServerInfo{Name: "cached"}
+	cache.PutInfo("1.2.3.4:27015", info, nil)
+	cache.PutPlayers("1.2.3.4:27015", []*Player{{Name: "alice"}}, nil)
+	cachedAt := *now
+
+	*now = now.Add(5 * time.Second)
+	reply, ok := cache.Info("1.2.3.4:27015")
+	if !ok || reply.Value != info || !reply.CachedAt.Equal(cachedAt) {
+		t.Fatalf("got %+v, %v", reply, ok)
+	}
+	if _, ok := cache.Players("1.2.3.4:27015"); ok {
+		t.Fatal("players should have expired")
+	}
+	if _, ok := cache.Rules("1.2.3.4:27015"); ok {
+		t.Fatal("rules were never cached")
+	}
+
+	*now = now.Add(5 * time.Second)
+	if _, ok := cache.Info("1.2.3.4:27015"); ok {
+		t.Fatal("info should have expired")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Entries != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	cache, now := newTestCache(CacheConfig{InfoTTL: 10 * time.Second, NegativeTTL: time.Second})
+
+	cache.PutInfo("timeout:1", nil, testTimeoutError{})
+	cache.PutInfo("refused:1", nil, errors.New("connection refused"))
+
+	reply, ok := cache.Info("timeout:1")
+	if !ok || reply.Err == nil {
+		t.Fatalf("got %+v, %v", reply, ok)
+	}
+	if _, ok := cache.Info("refused:1"); ok {
+		t.Fatal("only timeouts should be cached")
+	}
+
+	*now = now.Add(2 * time.Second)
+	if _, ok := cache.Info("timeout:1"); ok {
+		t.Fatal("negative entry should have expired")
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	cache, _ := newTestCache(CacheConfig{InfoTTL: time.Minute, MaxEntries: 2})
+
+	cache.PutInfo("a", &ServerInfo{}, nil)
+	cache.PutInfo("b", &ServerInfo{}, nil)
+	cache.Info("a") // Now b is the least recently used.
+	cache.PutInfo("c", &ServerInfo{}, nil)
+
+	if _, ok := cache.Info("b"); ok {
+		t.Fatal("b should have been evicted")
+	}
+	for _, addr := range []string{"a", "c"} {
+		if _, ok := cache.Info(addr); !ok {
+			t.Fatalf("%s should still be cached", addr)
+		}
+	}
+}
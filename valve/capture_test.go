@@ -0,0 +1,191 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Recording live replies into the seed corpus:
+//
+//	go test ./valve -run=TestCaptureSeeds -capture=1.2.3.4:27015 -capture-game=tf2
+//
+// Each reply is written to testdata/fuzz/<FuzzName>/<game>_<kind>, and listed
+// with the server it came from in testdata/fuzz/SOURCES.md.
+var captureAddr = flag.String("capture", "", "server to record seed replies from, as host:port")
+var captureGame = flag.String("capture-game", "", "short name of the captured server's game, naming the seed files")
+
+// A recordingConn keeps a copy of every packet received.
+type recordingConn struct {
+	PacketConn
+	packets [][]byte
+}
+
+func (rc *recordingConn) Recv() ([]byte, error) {
+	data, err := rc.PacketConn.Recv()
+	if err == nil {
+		rc.packets = append(rc.packets, bytes.Clone(data))
+	}
+	return data, err
+}
+
+// A captured reply, as a seed for one fuzz target.
+type capturedSeed struct {
+	target string
+	name   string
+	values []any
+}
+
+func TestCaptureSeeds(t *testing.T) {
+	if *captureAddr == "" {
+		t.Skip("set -capture=host:port and -capture-game=name to record seeds")
+	}
+	if *captureGame == "" {
+		t.Fatal("-capture-game is required")
+	}
+
+	socket, err := NewUdpSocket(*captureAddr, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &recordingConn{PacketConn: socket}
+	sq := NewServerQuerierConn(conn, 3*time.Second)
+	defer sq.Close()
+
+	info, err := sq.QueryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not every server answers these, so whatever arrived is kept.
+	if _, err := sq.QueryPlayers(); err != nil {
+		t.Logf("players: %v", err)
+	}
+	if _, err := sq.QueryRules(); err != nil {
+		t.Logf("rules: %v", err)
+	}
+
+	seeds, err := sq.capturedSeeds(*captureGame, conn.packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest strings.Builder
+	date := time.Now().UTC().Format("2006-01-02")
+	for _, seed := range seeds {
+		path := filepath.Join("testdata", "fuzz", seed.target, seed.name)
+		if err := os.WriteFile(path, encodeCorpusFile(seed.values...), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&manifest, "| `%s/%s` | %s | Captured from %s on %s |\n", seed.target, seed.name, info.Game, *captureAddr, date)
+		t.Logf("wrote %s", path)
+	}
+
+	f, err := os.OpenFile(filepath.Join("testdata", "fuzz", "SOURCES.md"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(manifest.String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Sort received packets into seeds. Split replies are reassembled first.
+func (sq *ServerQuerier) capturedSeeds(game string, packets [][]byte) ([]capturedSeed, error) {
+	var seeds []capturedSeed
+	var reply multiPacketReply
+	for _, data := range packets {
+		if len(data) < 5 {
+			continue
+		}
+		if int32(binary.LittleEndian.Uint32(data)) != -2 {
+			if seed, ok := replySeed(game, data, false, ""); ok {
+				seeds = append(seeds, seed)
+			}
+			continue
+		}
+
+		header, err := sq.decodeMultiPacketHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		done, err := reply.add(header)
+		if err != nil {
+			return nil, err
+		}
+		if !done {
+			continue
+		}
+
+		payload, compressed := reply.payload()
+		suffix := "_split"
+		if compressed {
+			suffix = "_compressed"
+			seeds = append(seeds, capturedSeed{"FuzzDecompressPayload", game + "_" + kindOf(payload, true), []any{payload}})
+		}
+		if seed, ok := replySeed(game, payload, compressed, suffix); ok {
+			seeds = append(seeds, seed)
+		}
+		reply = multiPacketReply{}
+	}
+	return seeds, nil
+}
+
+// The seed for a single (reassembled) reply, by its type.
+func replySeed(game string, data []byte, compressed bool, suffix string) (capturedSeed, bool) {
+	name := game + "_" + kindOf(data, compressed) + suffix
+	switch kindOf(data, compressed) {
+	case "info":
+		return capturedSeed{"FuzzParseInfo", name, []any{data}}, true
+	case "players":
+		return capturedSeed{"FuzzProcessPlayers", name, []any{data, compressed}}, true
+	case "rules":
+		return capturedSeed{"FuzzProcessRules", name, []any{data, compressed}}, true
+	}
+	return capturedSeed{}, false
+}
+
+// Name the type of a reply, decompressing it first if needed.
+func kindOf(data []byte, compressed bool) string {
+	if compressed {
+		decompressed, err := decompressPayload(data)
+		if err != nil {
+			return "unknown"
+		}
+		data = decompressed
+	}
+	if len(data) < 5 {
+		return "unknown"
+	}
+	switch data[4] {
+	case S2A_INFO_SOURCE, S2A_INFO_GOLDSRC:
+		return "info"
+	case S2A_PLAYER:
+		return "players"
+	case S2A_RULES:
+		return "rules"
+	}
+	return "unknown"
+}
+
+// Encode values in the "go test fuzz v1" corpus format.
+func encodeCorpusFile(values ...any) []byte {
+	var b bytes.Buffer
+	b.WriteString("go test fuzz v1\n")
+	for _, value := range values {
+		switch v := value.(type) {
+		case []byte:
+			fmt.Fprintf(&b, "[]byte(%q)\n", v)
+		case bool:
+			fmt.Fprintf(&b, "bool(%t)\n", v)
+		}
+	}
+	return b.Bytes()
+}
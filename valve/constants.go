@@ -1,8 +1,8 @@
 // Licensed under the GNU General Public License, version 3 or higher.
 package valve
 
-// SteamWebAPIURL - Steam Web API server list endpoint
-const SteamWebAPIURL = "https://api.steampowered.com/IGameServersService/GetServerList/v1/"
+// SteamWebAPIURL - Steam Web API server list endpoint (overridable for testing)
+var SteamWebAPIURL = "https://api.steampowered.com/IGameServersService/GetServerList/v1/"
 
 // SteamAPIKey - Steam API key (set from environment variable or configuration)
 var SteamAPIKey string
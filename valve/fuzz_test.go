@@ -0,0 +1,155 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Seed inputs live in testdata/fuzz/<FuzzName>, so the fuzzer starts from
+// realistic packets rather than from nothing. testdata/fuzz/SOURCES.md says
+// where each came from: whether it was captured from a live server, and of
+// which game, or built by hand. TestCaptureSeeds records new captures.
+
+func FuzzParseInfo(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sq := &ServerQuerier{}
+		info := &ServerInfo{}
+		if err := sq.parse_a2s_info_reply(info, data); err != nil {
+			return
+		}
+
+		// Anything that parses must be usable by the rest of the querier.
+		info.GameEngine()
+		if info.GameEngine() == SOURCE {
+			info.IsPreOrangeBox()
+		}
+	})
+}
+
+func FuzzProcessRules(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte, compressed bool) {
+		sq := &ServerQuerier{}
+		rules, err := sq.processRules(data, compressed)
+		if err != nil {
+			return
+		}
+
+		// Every rule needs at least two terminators in the input.
+		if !compressed && len(rules)*2 > len(data) {
+			t.Fatalf("%d rules from %d bytes", len(rules), len(data))
+		}
+	})
+}
+
+func FuzzProcessPlayers(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte, compressed bool) {
+		sq := &ServerQuerier{}
+		players, err := sq.processPlayers(data, compressed)
+		if err != nil {
+			return
+		}
+		if len(players) > 255 {
+			t.Fatalf("got %d players", len(players))
+		}
+	})
+}
+
+func FuzzDecompressPayload(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decompressed, err := decompressPayload(data)
+		if err != nil {
+			return
+		}
+		if uint64(len(decompressed)) > uint64(len(data))*kMaxCompressionRatio {
+			t.Fatalf("%d bytes decompressed from %d", len(decompressed), len(data))
+		}
+	})
+}
+
+// The input is a sequence of fragments, each prefixed by a one byte length.
+// The first byte picks the engine used to decode the headers.
+func FuzzMultiPacketReply(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+
+		sq := &ServerQuerier{}
+		switch data[0] % 3 {
+		case 0:
+			sq.info = &ServerInfo{InfoVersion: S2A_INFO_GOLDSRC}
+		case 1:
+			sq.info = &ServerInfo{InfoVersion: S2A_INFO_SOURCE, Ext: &ExtendedInfo{AppId: App_TF2}}
+		case 2:
+			sq.info = &ServerInfo{InfoVersion: S2A_INFO_SOURCE, Ext: &ExtendedInfo{AppId: App_SDK2006}}
+		}
+		data = data[1:]
+
+		var reply multiPacketReply
+		total := 0
+		for len(data) > 0 {
+			size := int(data[0])
+			data = data[1:]
+			if size > len(data) {
+				size = len(data)
+			}
+			fragment := data[:size]
+			data = data[size:]
+
+			header, err := sq.decodeMultiPacketHeader(fragment)
+			if err != nil {
+				return
+			}
+			if header.Size+len(header.Payload) != len(fragment) {
+				t.Fatalf("header size %d + payload %d != %d", header.Size, len(header.Payload), len(fragment))
+			}
+
+			done, err := reply.add(header)
+			if err != nil {
+				return
+			}
+			total += len(header.Payload)
+			if done {
+				payload, _ := reply.payload()
+				if len(payload) != total {
+					t.Fatalf("payload is %d bytes, fragments had %d", len(payload), total)
+				}
+				return
+			}
+		}
+	})
+}
+
+func FuzzReadRconPacket(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := ReadRconPacket(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		// Whatever we read must encode back to a valid packet.
+		encoded, err := EncodeRconPacket(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := ReadRconPacket(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *again != *packet {
+			t.Fatalf("got %+v, want %+v", again, packet)
+		}
+	})
+}
+
+func FuzzGoldSrcRconReply(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseRconChallenge(data)
+
+		text, err := parseGoldSrcRconReply(data)
+		if err == nil && len(text) > len(data) {
+			t.Fatalf("%d bytes of text from %d", len(text), len(data))
+		}
+	})
+}
@@ -0,0 +1,179 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// masterFilter builds the filter string master servers understand, such as
+// \appid\730\name_match\test. The Steam Web API and the UDP protocol share
+// the syntax.
+type masterFilter struct {
+	filters []string
+}
+
+// FilterAppId adds an AppID filter
+func (mf *masterFilter) FilterAppId(appId AppId) {
+	mf.filters = append(mf.filters, fmt.Sprintf("appid\\%d", appId))
+}
+
+// FilterAppIds adds multiple AppID filters
+func (mf *masterFilter) FilterAppIds(appIds []AppId) {
+	for _, appId := range appIds {
+		mf.FilterAppId(appId)
+	}
+}
+
+// FilterName adds a server name filter
+func (mf *masterFilter) FilterName(serverName string) {
+	if serverName != "" && serverName != "*" {
+		mf.filters = append(mf.filters, fmt.Sprintf("name_match\\%s", serverName))
+	}
+}
+
+// FilterGameaddr adds an IP address filter
+func (mf *masterFilter) FilterGameaddr(serverIP string) {
+	if serverIP != "" {
+		mf.filters = append(mf.filters, fmt.Sprintf("gameaddr\\%s", serverIP))
+	}
+}
+
+// filterString builds the filter string
+func (mf *masterFilter) filterString() string {
+	if len(mf.filters) == 0 {
+		return ""
+	}
+
+	// Combine all filters into a single string
+	// Format: \appid\730\name_match\test
+	var builder strings.Builder
+	for _, filter := range mf.filters {
+		builder.WriteString("\\")
+		builder.WriteString(filter)
+	}
+
+	return builder.String()
+}
+
+// A filterTerm is one key/value pair of a filter string.
+type filterTerm struct {
+	key   string
+	value string
+}
+
+// parseFilterString splits a filter string like \appid\730\map\de_dust2 into
+// its terms. A trailing key without a value is dropped.
+func parseFilterString(filter string) []filterTerm {
+	parts := strings.Split(strings.TrimPrefix(filter, "\\"), "\\")
+	var terms []filterTerm
+	for i := 0; i+1 < len(parts); i += 2 {
+		terms = append(terms, filterTerm{key: parts[i], value: parts[i+1]})
+	}
+	return terms
+}
+
+// matchWildcard matches a name_match pattern, where * stands for any run of
+// characters. Like Steam, matching ignores case.
+func matchWildcard(name string, pattern string) bool {
+	name = strings.ToLower(name)
+	pieces := strings.Split(strings.ToLower(pattern), "*")
+	if !strings.HasPrefix(name, pieces[0]) {
+		return false
+	}
+	name = name[len(pieces[0]):]
+	for i, piece := range pieces[1:] {
+		if i == len(pieces)-2 {
+			return strings.HasSuffix(name, piece)
+		}
+		index := strings.Index(name, piece)
+		if index < 0 {
+			return false
+		}
+		name = name[index+len(piece):]
+	}
+	return name == ""
+}
+
+// infoFilter records filters for sources that can't apply them, such as a
+// static list. The address filter is applied as servers are listed; the
+// AppID and name filters need each server's A2S_INFO reply, and are applied
+// by MatchInfo.
+type infoFilter struct {
+	appIds    []AppId
+	names     []string
+	gameaddrs []string
+}
+
+// FilterAppId adds an AppID filter. A server matches if it runs any of the
+// filtered apps.
+func (f *infoFilter) FilterAppId(appId AppId) {
+	f.appIds = append(f.appIds, appId)
+}
+
+// FilterAppIds adds multiple AppID filters
+func (f *infoFilter) FilterAppIds(appIds []AppId) {
+	f.appIds = append(f.appIds, appIds...)
+}
+
+// FilterName adds a server name filter
+func (f *infoFilter) FilterName(serverName string) {
+	if serverName != "" && serverName != "*" {
+		f.names = append(f.names, serverName)
+	}
+}
+
+// FilterGameaddr adds an IP address filter
+func (f *infoFilter) FilterGameaddr(serverIP string) {
+	if serverIP != "" {
+		f.gameaddrs = append(f.gameaddrs, serverIP)
+	}
+}
+
+// Whether a listed address passes the address filters.
+func (f *infoFilter) matchAddr(addr *net.TCPAddr) bool {
+	addrPort := CanonicalAddr(addr)
+	for _, gameaddr := range f.gameaddrs {
+		if !matchGameaddr(addrPort, gameaddr) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchInfo reports whether a server's A2S_INFO reply passes the AppID and
+// name filters.
+func (f *infoFilter) MatchInfo(addr *net.TCPAddr, info *ServerInfo) bool {
+	if len(f.appIds) > 0 && !slices.Contains(f.appIds, infoAppId(info)) {
+		return false
+	}
+	for _, name := range f.names {
+		if !matchWildcard(info.Name, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// infoAppId finds the AppID a server runs. Old GoldSrc replies don't carry
+// one; it follows from the game folder.
+func infoAppId(info *ServerInfo) AppId {
+	if info.Ext != nil && info.Ext.AppId != App_Unknown {
+		return info.Ext.AppId
+	}
+	return goldSrcAppIds[info.Folder]
+}
+
+// Match a gameaddr filter value: an IP, or an IP and port.
+func matchGameaddr(addr netip.AddrPort, value string) bool {
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addr == addrPort
+	}
+	if ip, err := netip.ParseAddr(value); err == nil {
+		return addr.Addr() == ip
+	}
+	return false
+}
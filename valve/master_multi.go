@@ -0,0 +1,198 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// InfoFilterer is implemented by queriers whose sources can't filter by AppID
+// or name. Callers query each listed server and keep those MatchInfo accepts.
+type InfoFilterer interface {
+	MatchInfo(addr *net.TCPAddr, info *ServerInfo) bool
+}
+
+// MultiMasterQuerier merges the lists of several named queriers, or sources.
+// Filters are passed to each of them, and they're queried in turn.
+//
+// Addresses are canonicalized, so that IPv4-mapped IPv6 addresses match their
+// IPv4 form, and each server is listed once however many sources list it.
+// Sources tells which sources did. A source that reports game ports, like the
+// Steam Web API, also lets later sources' entries for a server's game address
+// be skipped; other duplicates can only be found once the servers answer.
+type MultiMasterQuerier struct {
+	names    []string
+	queriers []MasterQuerier
+
+	mu sync.Mutex
+
+	// The sources that listed each server, in the order they were added.
+	sources map[netip.AddrPort][]string
+
+	// The listed address of servers known by another, their game address.
+	aliases map[netip.AddrPort]netip.AddrPort
+
+	// Servers listed by queriers that filter themselves, which need no
+	// checking by MatchInfo.
+	filtered map[netip.AddrPort]bool
+}
+
+// NewMultiMasterQuerier creates a querier with no sources yet.
+func NewMultiMasterQuerier() *MultiMasterQuerier {
+	return &MultiMasterQuerier{
+		sources:  map[netip.AddrPort][]string{},
+		aliases:  map[netip.AddrPort]netip.AddrPort{},
+		filtered: map[netip.AddrPort]bool{},
+	}
+}
+
+// Add a source. It's closed when the MultiMasterQuerier is.
+func (q *MultiMasterQuerier) Add(name string, querier MasterQuerier) {
+	q.names = append(q.names, name)
+	q.queriers = append(q.queriers, querier)
+}
+
+// FilterAppId adds an AppID filter
+func (q *MultiMasterQuerier) FilterAppId(appId AppId) {
+	for _, querier := range q.queriers {
+		querier.FilterAppId(appId)
+	}
+}
+
+// FilterAppIds adds multiple AppID filters
+func (q *MultiMasterQuerier) FilterAppIds(appIds []AppId) {
+	for _, querier := range q.queriers {
+		querier.FilterAppIds(appIds)
+	}
+}
+
+// FilterName adds a server name filter
+func (q *MultiMasterQuerier) FilterName(serverName string) {
+	for _, querier := range q.queriers {
+		querier.FilterName(serverName)
+	}
+}
+
+// FilterGameaddr adds an IP address filter
+func (q *MultiMasterQuerier) FilterGameaddr(serverIP string) {
+	for _, querier := range q.queriers {
+		querier.FilterGameaddr(serverIP)
+	}
+}
+
+// Query lists the servers of each querier in turn, skipping those an
+// earlier one listed. It fails if any of them does.
+func (q *MultiMasterQuerier) Query(callback MasterQueryCallback) error {
+	for i, querier := range q.queriers {
+		name := q.names[i]
+		_, needsInfo := querier.(InfoFilterer)
+		add := func(listings []*ServerListing) error {
+			var listed ServerList
+			q.mu.Lock()
+			for _, listing := range listings {
+				key := q.key(listing.Addr)
+				sources := q.sources[key]
+				if len(sources) == 0 {
+					listed = append(listed, net.TCPAddrFromAddrPort(key))
+				}
+				if len(sources) == 0 || sources[len(sources)-1] != name {
+					q.sources[key] = append(sources, name)
+				}
+				if !needsInfo {
+					q.filtered[key] = true
+				}
+
+				// Unless a source listed it first, the game address is the
+				// same server.
+				if listing.GamePort > 0 && listing.GamePort != int(key.Port()) {
+					game := netip.AddrPortFrom(key.Addr(), uint16(listing.GamePort))
+					if _, ok := q.aliases[game]; !ok && len(q.sources[game]) == 0 {
+						q.aliases[game] = key
+					}
+				}
+			}
+			q.mu.Unlock()
+
+			if len(listed) == 0 {
+				return nil
+			}
+			return callback(listed)
+		}
+
+		var err error
+		if lister, ok := querier.(ListingQuerier); ok {
+			err = lister.QueryListings(add)
+		} else {
+			err = querier.Query(func(servers ServerList) error {
+				listings := make([]*ServerListing, len(servers))
+				for i, addr := range servers {
+					listings[i] = &ServerListing{Addr: addr}
+				}
+				return add(listings)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The address a server was listed under. Call with mu held.
+func (q *MultiMasterQuerier) key(addr *net.TCPAddr) netip.AddrPort {
+	key := CanonicalAddr(addr)
+	if alias, ok := q.aliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// Sources returns the names of the sources that listed a server, or nil if
+// none did.
+func (q *MultiMasterQuerier) Sources(addr *net.TCPAddr) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.sources[q.key(addr)]
+}
+
+// CanonicalAddr returns the form of an address used to compare servers:
+// IPv4 addresses are never IPv4-mapped IPv6 ones.
+func CanonicalAddr(addr *net.TCPAddr) netip.AddrPort {
+	addrPort := addr.AddrPort()
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
+
+// NeedsInfo reports whether a server has to be checked by MatchInfo, because
+// only queriers that can't filter listed it.
+func (q *MultiMasterQuerier) NeedsInfo(addr *net.TCPAddr) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := q.key(addr)
+	return len(q.sources[key]) > 0 && !q.filtered[key]
+}
+
+// MatchInfo checks servers listed by queriers that can't filter against
+// their filters. The filters are the same for every querier, so the first
+// such querier decides.
+func (q *MultiMasterQuerier) MatchInfo(addr *net.TCPAddr, info *ServerInfo) bool {
+	q.mu.Lock()
+	filtered := q.filtered[q.key(addr)]
+	q.mu.Unlock()
+	if filtered {
+		return true
+	}
+	for _, querier := range q.queriers {
+		if filterer, ok := querier.(InfoFilterer); ok {
+			return filterer.MatchInfo(addr, info)
+		}
+	}
+	return true
+}
+
+// Close every querier.
+func (q *MultiMasterQuerier) Close() {
+	for _, querier := range q.queriers {
+		querier.Close()
+	}
+}
@@ -0,0 +1,545 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// A game server asking for a heartbeat challenge, and the reply.
+	kMasterChallengeRequest = 'q'
+	kMasterChallengeReply   = 's'
+
+	// A heartbeat carrying the server's info string, and a server shutting
+	// down.
+	kMasterHeartbeat = '0'
+	kMasterShutdown  = 'b'
+
+	// Addresses per 0x31 reply, the end marker included. Fills a packet.
+	kMasterPageSize = (kMaxPacketSize - 6) / 6
+
+	// Servers kept before new ones are turned away.
+	kMaxMasterServers = 65536
+
+	// How often the challenge secret changes. A challenge stays valid until
+	// the secret after next, so for one to two periods.
+	kMasterSecretLifetime = 10 * time.Minute
+
+	// Replies to challenge and list requests allowed per second to one IP,
+	// and their burst. A full registry is about 280 list pages.
+	kMasterReplyRate  = 50
+	kMasterReplyBurst = 300
+)
+
+// A RegisteredServer is a game server that sent a MasterServer a heartbeat,
+// with what it said about itself.
+type RegisteredServer struct {
+	Addr       netip.AddrPort
+	AppId      AppId
+	Name       string
+	Gamedir    string
+	Map        string
+	Version    string
+	Product    string
+	GameType   string
+	Region     MasterRegion
+	Players    int
+	MaxPlayers int
+	Bots       int
+	Type       ServerType
+	OS         ServerOS
+	Password   bool
+	Secure     bool
+	Lan        bool
+
+	// When the last heartbeat arrived.
+	LastHeartbeat time.Time
+}
+
+// Counters for a MasterServer.
+type MasterServerStats struct {
+	// Servers currently registered.
+	Servers int
+
+	// Heartbeats accepted, and those dropped for a wrong challenge or a full
+	// registry.
+	Heartbeats uint64
+	Rejected   uint64
+
+	// Challenge and list requests left unanswered because their source IP
+	// sent too many. Replies are larger than requests, so answering them all
+	// would let spoofed requests flood someone else.
+	Throttled uint64
+
+	// 0x31 list requests answered.
+	Queries uint64
+}
+
+// A MasterServer is a master server for a private fleet. Game servers
+// register with the GoldSrc/Source heartbeat protocol: they ask for a
+// challenge ("q"), then send their info string with it ("0\n"), every few
+// minutes. A server that stops sending heartbeats is forgotten after the
+// expiry, and one that shuts down ("b\n") from the address it registered
+// from is removed at once.
+//
+// The registry is listed over the legacy UDP protocol (0x31), which
+// UdpMasterQuerier speaks, and in-process through NewQuerier.
+type MasterServer struct {
+	conn    net.PacketConn
+	expiry  time.Duration
+	limiter *RateLimiter
+	now     func() time.Time
+	done    chan struct{}
+
+	// The current challenge secret and the one before it.
+	secretMu sync.Mutex
+	secrets  [2][]byte
+	rotated  time.Time
+
+	mu      sync.Mutex
+	servers map[netip.AddrPort]*RegisteredServer
+
+	heartbeats atomic.Uint64
+	rejected   atomic.Uint64
+	queries    atomic.Uint64
+	throttled  atomic.Uint64
+}
+
+// ListenMasterServer starts a master server on a UDP address, such as
+// ":27010". Servers are forgotten if no heartbeat arrives within the expiry.
+func ListenMasterServer(address string, expiry time.Duration) (*MasterServer, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &MasterServer{
+		conn:    conn,
+		expiry:  expiry,
+		limiter: NewRateLimiter(kMasterReplyRate, kMasterReplyBurst),
+		now:     time.Now,
+		done:    make(chan struct{}),
+		servers: map[netip.AddrPort]*RegisteredServer{},
+	}
+	ms.rotated = ms.now()
+	ms.secrets[0] = newMasterSecret()
+	go ms.serve()
+	return ms, nil
+}
+
+// The address the master server is listening on.
+func (ms *MasterServer) Addr() net.Addr {
+	return ms.conn.LocalAddr()
+}
+
+// Stop listening and wait for the server to exit. The registry can still be
+// listed.
+func (ms *MasterServer) Close() {
+	ms.conn.Close()
+	<-ms.done
+}
+
+// Stats returns the server's counters.
+func (ms *MasterServer) Stats() MasterServerStats {
+	ms.mu.Lock()
+	servers := len(ms.servers)
+	ms.mu.Unlock()
+
+	return MasterServerStats{
+		Servers:    servers,
+		Heartbeats: ms.heartbeats.Load(),
+		Rejected:   ms.rejected.Load(),
+		Queries:    ms.queries.Load(),
+		Throttled:  ms.throttled.Load(),
+	}
+}
+
+// Servers returns the live servers matching a filter string and region,
+// ordered by address.
+func (ms *MasterServer) Servers(filter string, region MasterRegion) []*RegisteredServer {
+	terms := parseFilterString(filter)
+
+	ms.mu.Lock()
+	ms.expire()
+	var servers []*RegisteredServer
+	for _, server := range ms.servers {
+		if region != RegionAll && server.Region != region {
+			continue
+		}
+		if server.matches(terms) {
+			copied := *server
+			servers = append(servers, &copied)
+		}
+	}
+	ms.mu.Unlock()
+
+	slices.SortFunc(servers, func(a, b *RegisteredServer) int {
+		return a.Addr.Compare(b.Addr)
+	})
+	return servers
+}
+
+// Forget servers whose last heartbeat is older than the expiry. The caller
+// must hold the lock.
+func (ms *MasterServer) expire() {
+	cutoff := ms.now().Add(-ms.expiry)
+	for addr, server := range ms.servers {
+		if server.LastHeartbeat.Before(cutoff) {
+			delete(ms.servers, addr)
+		}
+	}
+}
+
+func (ms *MasterServer) serve() {
+	defer close(ms.done)
+
+	var buffer [kMaxPacketSize]byte
+	for {
+		n, from, err := ms.conn.ReadFrom(buffer[:])
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		udpAddr, ok := from.(*net.UDPAddr)
+		if !ok || n == 0 {
+			continue
+		}
+		peer := udpAddr.AddrPort()
+		peer = netip.AddrPortFrom(peer.Addr().Unmap(), peer.Port())
+
+		if reply := ms.handle(buffer[:n], peer); reply != nil {
+			ms.conn.WriteTo(reply, udpAddr)
+		}
+	}
+}
+
+// Handle a packet from peer, returning the reply to send, if any.
+func (ms *MasterServer) handle(packet []byte, peer netip.AddrPort) []byte {
+	switch packet[0] {
+	case kMasterChallengeRequest, kMasterQuery:
+		if !ms.limiter.Allow(peer.Addr()) {
+			ms.throttled.Add(1)
+			return nil
+		}
+	}
+
+	switch packet[0] {
+	case kMasterChallengeRequest:
+		var builder PacketBuilder
+		builder.WriteBytes([]byte{0xff, 0xff, 0xff, 0xff, kMasterChallengeReply, '\n'})
+		builder.WriteUint32(ms.challenge(peer))
+		return builder.Bytes()
+	case kMasterHeartbeat:
+		ms.heartbeat(packet[1:], peer)
+	case kMasterShutdown:
+		// Servers send no challenge when shutting down, so only the
+		// address a server registered from can remove it.
+		ms.mu.Lock()
+		delete(ms.servers, peer)
+		ms.mu.Unlock()
+	case kMasterQuery:
+		return ms.listPage(packet)
+	}
+	return nil
+}
+
+// A random challenge secret.
+func newMasterSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// The challenge a server must send back in its heartbeats. Challenges are
+// derived from the server's address, so nothing needs to be kept for servers
+// that ask for one and never follow up.
+func (ms *MasterServer) challenge(peer netip.AddrPort) uint32 {
+	return ms.challenges(peer)[0]
+}
+
+// The challenges peer may send: the one from the current secret, and the one
+// from the previous secret, if there is one yet.
+func (ms *MasterServer) challenges(peer netip.AddrPort) []uint32 {
+	ms.secretMu.Lock()
+	defer ms.secretMu.Unlock()
+
+	if elapsed := ms.now().Sub(ms.rotated); elapsed >= kMasterSecretLifetime {
+		ms.secrets[1] = ms.secrets[0]
+		if elapsed >= 2*kMasterSecretLifetime {
+			ms.secrets[1] = nil
+		}
+		ms.secrets[0] = newMasterSecret()
+		ms.rotated = ms.now()
+	}
+
+	var challenges []uint32
+	for _, secret := range ms.secrets {
+		if secret != nil {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(peer.String()))
+			challenges = append(challenges, binary.LittleEndian.Uint32(mac.Sum(nil)))
+		}
+	}
+	return challenges
+}
+
+// Register or refresh a server from its heartbeat info string, such as
+// \protocol\48\challenge\1234\players\3\max\16\gamedir\cstrike\...
+func (ms *MasterServer) heartbeat(infoString []byte, peer netip.AddrPort) {
+	info := map[string]string{}
+	for _, term := range parseFilterString(strings.TrimSpace(string(infoString))) {
+		info[term.key] = term.value
+	}
+
+	challenge, err := strconv.ParseUint(info["challenge"], 10, 32)
+	if err != nil || !slices.Contains(ms.challenges(peer), uint32(challenge)) {
+		ms.rejected.Add(1)
+		return
+	}
+
+	server := parseHeartbeat(info)
+	server.Addr = peer
+	if ms.Register(server) {
+		ms.heartbeats.Add(1)
+	} else {
+		ms.rejected.Add(1)
+	}
+}
+
+// Register adds or refreshes a server as if it had sent a heartbeat, for
+// servers that can't send their own. It returns false if the registry is
+// full, or if the address isn't IPv4, which the list protocol can't carry.
+func (ms *MasterServer) Register(server *RegisteredServer) bool {
+	copied := *server
+	copied.Addr = netip.AddrPortFrom(server.Addr.Addr().Unmap(), server.Addr.Port())
+	copied.LastHeartbeat = ms.now()
+	if !copied.Addr.Addr().Is4() {
+		return false
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.servers[copied.Addr]; !ok && len(ms.servers) >= kMaxMasterServers {
+		ms.expire()
+		if len(ms.servers) >= kMaxMasterServers {
+			return false
+		}
+	}
+	ms.servers[copied.Addr] = &copied
+	return true
+}
+
+// Read a server's description from the keys of a heartbeat. Missing or
+// malformed values are left empty.
+func parseHeartbeat(info map[string]string) *RegisteredServer {
+	number := func(key string) int {
+		value, _ := strconv.Atoi(info[key])
+		return value
+	}
+
+	server := &RegisteredServer{
+		AppId:      AppId(number("appid")),
+		Name:       info["hostname"],
+		Gamedir:    info["gamedir"],
+		Map:        info["map"],
+		Version:    info["version"],
+		Product:    info["product"],
+		GameType:   info["gametype"],
+		Region:     RegionAll,
+		Players:    number("players"),
+		MaxPlayers: number("max"),
+		Bots:       number("bots"),
+		Password:   info["password"] == "1",
+		Secure:     info["secure"] == "1",
+		Lan:        info["lan"] == "1",
+	}
+	if server.AppId == App_Unknown {
+		server.AppId = goldSrcAppIds[server.Gamedir]
+	}
+	if region, err := strconv.ParseUint(info["region"], 10, 8); err == nil {
+		server.Region = MasterRegion(region)
+	}
+	switch info["type"] {
+	case "d":
+		server.Type = ServerType_Dedicated
+	case "l":
+		server.Type = ServerType_Listen
+	case "p":
+		server.Type = ServerType_HLTV
+	}
+	switch info["os"] {
+	case "w":
+		server.OS = ServerOS_Windows
+	case "l":
+		server.OS = ServerOS_Linux
+	case "m", "o":
+		server.OS = ServerOS_Mac
+	}
+	return server
+}
+
+// Answer a 0x31 request with the page of servers following its seed.
+func (ms *MasterServer) listPage(packet []byte) []byte {
+	reader := NewPacketReader(packet[1:])
+	region := MasterRegion(reader.ReadUint8())
+	seed := reader.ReadString()
+	filter := reader.ReadString()
+	if reader.Err() != nil {
+		return nil
+	}
+	ms.queries.Add(1)
+
+	servers := ms.Servers(filter, region)
+
+	// Start after the seed. Servers are ordered by address, so the page
+	// follows on even if the seed has since expired.
+	start := 0
+	if seedAddr, err := netip.ParseAddrPort(seed); err == nil && seed != kMasterListEnd {
+		start, _ = slices.BinarySearchFunc(servers, seedAddr, func(server *RegisteredServer, addr netip.AddrPort) int {
+			if server.Addr.Compare(addr) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+
+	var builder PacketBuilder
+	builder.WriteBytes(kMasterReplyHeader)
+	end := min(start+kMasterPageSize, len(servers))
+	if end == len(servers) && end-start == kMasterPageSize {
+		// Leave room for the end marker.
+		end--
+	}
+	for _, server := range servers[start:end] {
+		writeAddrPort(&builder, server.Addr)
+	}
+	if end == len(servers) {
+		writeAddrPort(&builder, netip.AddrPortFrom(netip.IPv4Unspecified(), 0))
+	}
+	return builder.Bytes()
+}
+
+// Write an IPv4 address and port, as 6 bytes in network order.
+func writeAddrPort(builder *PacketBuilder, addr netip.AddrPort) {
+	ip := addr.Addr().As4()
+	builder.WriteBytes(ip[:])
+	builder.WriteBytes(binary.BigEndian.AppendUint16(nil, addr.Port()))
+}
+
+// Whether the server matches every term of a filter. Unknown keys are
+// ignored, as master servers do.
+func (rs *RegisteredServer) matches(terms []filterTerm) bool {
+	for _, term := range terms {
+		if !rs.matchesTerm(term) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rs *RegisteredServer) matchesTerm(term filterTerm) bool {
+	set := term.value == "1"
+	switch term.key {
+	case "appid":
+		return strconv.Itoa(int(rs.AppId)) == term.value
+	case "napp":
+		return strconv.Itoa(int(rs.AppId)) != term.value
+	case "gamedir":
+		return strings.EqualFold(rs.Gamedir, term.value)
+	case "map":
+		return strings.EqualFold(rs.Map, term.value)
+	case "name_match":
+		return matchWildcard(rs.Name, term.value)
+	case "gameaddr":
+		return matchGameaddr(rs.Addr, term.value)
+	case "dedicated":
+		return !set || rs.Type == ServerType_Dedicated
+	case "secure":
+		return !set || rs.Secure
+	case "linux":
+		return !set || rs.OS == ServerOS_Linux
+	case "password":
+		return term.value != "0" || !rs.Password
+	case "empty":
+		return !set || rs.Players > 0
+	case "full":
+		return !set || rs.Players < rs.MaxPlayers
+	case "noplayers":
+		return !set || rs.Players == 0
+	}
+	return true
+}
+
+// A LocalMasterQuerier lists the servers registered with a MasterServer in
+// this process. It takes the same filters as the other queriers.
+type LocalMasterQuerier struct {
+	masterFilter
+	master *MasterServer
+	region MasterRegion
+}
+
+// NewQuerier creates a querier for the server's registry.
+func (ms *MasterServer) NewQuerier() *LocalMasterQuerier {
+	return &LocalMasterQuerier{
+		master: ms,
+		region: RegionAll,
+	}
+}
+
+// Only list servers in a region. By default, every region is listed.
+func (q *LocalMasterQuerier) SetRegion(region MasterRegion) {
+	q.region = region
+}
+
+// Query queries the server list
+func (q *LocalMasterQuerier) Query(callback MasterQueryCallback) error {
+	return q.QueryListings(func(listings []*ServerListing) error {
+		servers := make(ServerList, 0, len(listings))
+		for _, listing := range listings {
+			servers = append(servers, listing.Addr)
+		}
+		return callback(servers)
+	})
+}
+
+// QueryListings queries the server list, along with the player counts from
+// each server's last heartbeat
+func (q *LocalMasterQuerier) QueryListings(callback MasterListingCallback) error {
+	servers := q.master.Servers(q.filterString(), q.region)
+	for len(servers) > 0 {
+		page := servers[:min(kMasterPageSize, len(servers))]
+		servers = servers[len(page):]
+
+		listings := make([]*ServerListing, 0, len(page))
+		for _, server := range page {
+			listings = append(listings, &ServerListing{
+				Addr:       net.TCPAddrFromAddrPort(server.Addr),
+				Players:    server.Players,
+				MaxPlayers: server.MaxPlayers,
+				Bots:       server.Bots,
+			})
+		}
+		if err := callback(listings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Nothing to release; the MasterServer outlives its queriers.
+func (q *LocalMasterQuerier) Close() {
+}
@@ -0,0 +1,257 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestMasterServer(t *testing.T) *MasterServer {
+	ms, err := ListenMasterServer("127.0.0.1:0", 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ms.Close)
+	return ms
+}
+
+// Register a server as if it had sent a heartbeat.
+func registerServer(ms *MasterServer, addr string, info string) {
+	peer := netip.MustParseAddrPort(addr)
+	heartbeat := fmt.Sprintf("0\n\\protocol\\7\\challenge\\%d%s\n", ms.challenge(peer), info)
+	ms.handle([]byte(heartbeat), peer)
+}
+
+func listServers(t *testing.T, ms *MasterServer, configure func(q *UdpMasterQuerier)) []string {
+	q, err := NewUdpMasterQuerier(ms.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if configure != nil {
+		configure(q)
+	}
+
+	var servers []string
+	err = q.Query(func(batch ServerList) error {
+		for _, addr := range batch {
+			servers = append(servers, addr.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return servers
+}
+
+func TestMasterServerHeartbeat(t *testing.T) {
+	ms := newTestMasterServer(t)
+
+	conn, err := net.Dial("udp", ms.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	conn.Write([]byte("q"))
+	reply := make([]byte, kMaxPacketSize)
+	n, err := conn.Read(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 || string(reply[:6]) != "\xff\xff\xff\xffs\n" {
+		t.Fatalf("bad challenge reply %q", reply[:n])
+	}
+	challenge := binary.LittleEndian.Uint32(reply[6:])
+
+	// A heartbeat with the wrong challenge is dropped.
+	fmt.Fprintf(conn, "0\n\\protocol\\48\\challenge\\%d\\players\\3\\max\\16\\gamedir\\cstrike\\map\\de_dust2\\type\\d\\os\\l\\secure\\1\\region\\3\n", challenge+1)
+	fmt.Fprintf(conn, "0\n\\protocol\\48\\challenge\\%d\\players\\3\\max\\16\\gamedir\\cstrike\\map\\de_dust2\\type\\d\\os\\l\\secure\\1\\region\\3\n", challenge)
+
+	want := []string{conn.LocalAddr().String()}
+	if servers := listServers(t, ms, nil); !reflect.DeepEqual(servers, want) {
+		t.Fatalf("got %v, want %v", servers, want)
+	}
+
+	servers := ms.Servers("", RegionAll)
+	server := servers[0]
+	if server.AppId != App_CS || server.Map != "de_dust2" || server.Players != 3 || server.MaxPlayers != 16 ||
+		server.Type != ServerType_Dedicated || server.OS != ServerOS_Linux || !server.Secure || server.Region != RegionEurope {
+		t.Errorf("got %+v", server)
+	}
+	if stats := ms.Stats(); stats.Servers != 1 || stats.Heartbeats != 1 || stats.Rejected != 1 || stats.Queries != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	// Shutting down removes the server.
+	conn.Write([]byte("b\n"))
+	deadline := time.Now().Add(time.Second)
+	for len(ms.Servers("", RegionAll)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("server was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMasterServerShutdown(t *testing.T) {
+	ms := newTestMasterServer(t)
+	registerServer(ms, "10.0.0.1:27015", "")
+
+	// Another port on the same host can't remove the server.
+	ms.handle([]byte("b\n"), netip.MustParseAddrPort("10.0.0.1:27016"))
+	if servers := ms.Servers("", RegionAll); len(servers) != 1 {
+		t.Fatalf("got %d servers after a shutdown from another port", len(servers))
+	}
+
+	ms.handle([]byte("b\n"), netip.MustParseAddrPort("10.0.0.1:27015"))
+	if servers := ms.Servers("", RegionAll); len(servers) != 0 {
+		t.Errorf("got %d servers after shutdown", len(servers))
+	}
+}
+
+func TestMasterServerChallengeRotation(t *testing.T) {
+	ms := newTestMasterServer(t)
+	now := time.Now()
+	ms.now = func() time.Time { return now }
+
+	peer := netip.MustParseAddrPort("10.0.0.1:27015")
+	heartbeat := func(challenge uint32) {
+		ms.handle([]byte(fmt.Sprintf("0\n\\protocol\\7\\challenge\\%d\n", challenge)), peer)
+	}
+	challenge := ms.challenge(peer)
+
+	// A challenge outlives one rotation of the secret, but not two.
+	now = now.Add(kMasterSecretLifetime)
+	if ms.challenge(peer) == challenge {
+		t.Fatal("challenge didn't change")
+	}
+	heartbeat(challenge)
+	now = now.Add(kMasterSecretLifetime)
+	heartbeat(challenge)
+	if stats := ms.Stats(); stats.Heartbeats != 1 || stats.Rejected != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+}
+
+func TestMasterServerReplyRateLimit(t *testing.T) {
+	ms := newTestMasterServer(t)
+	peer := netip.MustParseAddrPort("10.0.0.1:27015")
+	other := netip.MustParseAddrPort("10.0.0.2:27015")
+
+	// Spoofed requests can only draw a burst of replies towards one IP.
+	answered := 0
+	for i := 0; i < 2*kMasterReplyBurst; i++ {
+		if ms.handle([]byte("q"), peer) != nil {
+			answered++
+		}
+	}
+	if answered < kMasterReplyBurst || answered > kMasterReplyBurst+5 {
+		t.Errorf("answered %d requests, want about %d", answered, kMasterReplyBurst)
+	}
+	if ms.handle([]byte("1\xff0.0.0.0:0\x00\x00"), peer) != nil {
+		t.Error("list request answered past the limit")
+	}
+	if ms.handle([]byte("q"), other) == nil {
+		t.Error("another IP was limited too")
+	}
+	if stats := ms.Stats(); stats.Throttled < kMasterReplyBurst {
+		t.Errorf("got stats %+v", stats)
+	}
+}
+
+func TestMasterServerPaging(t *testing.T) {
+	ms := newTestMasterServer(t)
+
+	// Two full pages and a bit, with the end marker on the last.
+	var want []string
+	for i := 0; i < 2*kMasterPageSize+5; i++ {
+		addr := fmt.Sprintf("10.0.%d.%d:27015", i/256, i%256)
+		registerServer(ms, addr, "\\appid\\440")
+		want = append(want, addr)
+	}
+
+	if servers := listServers(t, ms, nil); !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %d servers, want %d", len(servers), len(want))
+	}
+	if queries := ms.Stats().Queries; queries != 3 {
+		t.Errorf("got %d requests, want 3", queries)
+	}
+}
+
+func TestMasterServerFilters(t *testing.T) {
+	ms := newTestMasterServer(t)
+	registerServer(ms, "10.0.0.1:27015", "\\appid\\440\\hostname\\Alpha Server\\map\\ctf_2fort\\players\\0\\max\\24\\region\\3")
+	registerServer(ms, "10.0.0.2:27015", "\\appid\\440\\hostname\\Beta\\map\\pl_upward\\players\\24\\max\\24\\password\\1\\region\\1")
+	registerServer(ms, "10.0.0.2:27016", "\\gamedir\\cstrike\\map\\de_dust2\\players\\5\\max\\32\\region\\3")
+
+	tests := []struct {
+		configure func(q *UdpMasterQuerier)
+		want      []string
+	}{
+		{func(q *UdpMasterQuerier) { q.FilterAppId(App_TF2) }, []string{"10.0.0.1:27015", "10.0.0.2:27015"}},
+		{func(q *UdpMasterQuerier) { q.FilterAppId(App_CS) }, []string{"10.0.0.2:27016"}},
+		{func(q *UdpMasterQuerier) { q.FilterName("alpha*") }, []string{"10.0.0.1:27015"}},
+		{func(q *UdpMasterQuerier) { q.FilterGameaddr("10.0.0.2") }, []string{"10.0.0.2:27015", "10.0.0.2:27016"}},
+		{func(q *UdpMasterQuerier) { q.FilterGameaddr("10.0.0.2:27016") }, []string{"10.0.0.2:27016"}},
+		{func(q *UdpMasterQuerier) { q.SetRegion(RegionEurope) }, []string{"10.0.0.1:27015", "10.0.0.2:27016"}},
+		{func(q *UdpMasterQuerier) { q.filters = append(q.filters, "empty\\1", "full\\1") }, []string{"10.0.0.2:27016"}},
+		{func(q *UdpMasterQuerier) { q.filters = append(q.filters, "password\\0", "map\\CTF_2FORT") }, []string{"10.0.0.1:27015"}},
+		{func(q *UdpMasterQuerier) { q.FilterAppId(App_CSGO) }, nil},
+	}
+	for i, test := range tests {
+		if servers := listServers(t, ms, test.configure); !reflect.DeepEqual(servers, test.want) {
+			t.Errorf("%d: got %v, want %v", i, servers, test.want)
+		}
+	}
+}
+
+func TestMasterServerExpiry(t *testing.T) {
+	ms := newTestMasterServer(t)
+	now := time.Now()
+	ms.now = func() time.Time { return now }
+
+	registerServer(ms, "10.0.0.1:27015", "")
+	now = now.Add(10 * time.Minute)
+	registerServer(ms, "10.0.0.2:27015", "")
+	now = now.Add(10 * time.Minute)
+
+	servers := ms.Servers("", RegionAll)
+	if len(servers) != 1 || servers[0].Addr.String() != "10.0.0.2:27015" {
+		t.Errorf("got %v", servers)
+	}
+}
+
+func TestLocalMasterQuerier(t *testing.T) {
+	ms := newTestMasterServer(t)
+	registerServer(ms, "10.0.0.1:27015", "\\appid\\440\\players\\3\\max\\24\\bots\\1")
+	registerServer(ms, "10.0.0.2:27015", "\\appid\\730")
+
+	q := ms.NewQuerier()
+	defer q.Close()
+	q.FilterAppId(App_TF2)
+
+	var listings []*ServerListing
+	err := q.QueryListings(func(batch []*ServerListing) error {
+		listings = append(listings, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listings) != 1 {
+		t.Fatalf("got %d listings", len(listings))
+	}
+	got := *listings[0]
+	want := ServerListing{Addr: got.Addr, Players: 3, MaxPlayers: 24, Bots: 1}
+	if got.Addr.String() != "10.0.0.1:27015" || got != want {
+		t.Errorf("got %+v", got)
+	}
+}
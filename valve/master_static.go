@@ -0,0 +1,215 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Port assumed for listed servers given without one.
+const kDefaultGamePort = "27015"
+
+// Time allowed for a DNS SRV lookup.
+const kSrvLookupTimeout = 10 * time.Second
+
+// Looks up SRV records; replaced in tests.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// StaticMasterQuerier lists a fixed set of servers, such as from
+// configuration. Nothing is known about them until they're queried, so the
+// AppID and name filters are left to MatchInfo.
+type StaticMasterQuerier struct {
+	infoFilter
+	addrs []string
+}
+
+// NewStaticMasterQuerier creates a querier listing the given servers, as
+// host:port. The port defaults to 27015.
+func NewStaticMasterQuerier(addrs []string) *StaticMasterQuerier {
+	return &StaticMasterQuerier{addrs: addrs}
+}
+
+// Query resolves the servers and lists them in one batch.
+func (q *StaticMasterQuerier) Query(callback MasterQueryCallback) error {
+	return q.listAddrs(q.addrs, callback)
+}
+
+// Resolve addresses and list those passing the address filters. Addresses
+// that don't resolve are skipped, unless none do.
+func (f *infoFilter) listAddrs(addrs []string, callback MasterQueryCallback) error {
+	var servers ServerList
+	var firstErr error
+	for _, hostAndPort := range addrs {
+		if _, _, err := net.SplitHostPort(hostAndPort); err != nil {
+			hostAndPort = net.JoinHostPort(hostAndPort, kDefaultGamePort)
+		}
+		addr, err := net.ResolveTCPAddr("tcp", hostAndPort)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if f.matchAddr(addr) {
+			servers = append(servers, addr)
+		}
+	}
+	if len(servers) == 0 {
+		return firstErr
+	}
+	return callback(servers)
+}
+
+// Nothing to release.
+func (q *StaticMasterQuerier) Close() {
+}
+
+// A ServerListFile is a list of servers kept in a file, one host:port per
+// line, reloaded when the file changes. Blank lines and # comments are
+// ignored. If a reload fails, the previous list is kept.
+type ServerListFile struct {
+	path string
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	addrs   []string
+	modTime time.Time
+	size    int64
+}
+
+// WatchServerListFile loads a server list file, then checks it for changes
+// at the given interval.
+func WatchServerListFile(path string, interval time.Duration) (*ServerListFile, error) {
+	f := &ServerListFile{
+		path: path,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	go f.watch(interval)
+	return f, nil
+}
+
+// The servers currently listed.
+func (f *ServerListFile) Addrs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addrs
+}
+
+// NewQuerier creates a querier for the servers currently listed.
+func (f *ServerListFile) NewQuerier() *StaticMasterQuerier {
+	return NewStaticMasterQuerier(f.Addrs())
+}
+
+// Stop watching the file.
+func (f *ServerListFile) Close() {
+	close(f.stop)
+	<-f.done
+}
+
+func (f *ServerListFile) watch(interval time.Duration) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.reload()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Read the file if it changed since it was last read, reporting whether it
+// did.
+func (f *ServerListFile) reload() (bool, error) {
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	unchanged := stat.ModTime().Equal(f.modTime) && stat.Size() == f.size
+	f.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	addrs := parseServerListFile(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addrs = addrs
+	f.modTime = stat.ModTime()
+	f.size = stat.Size()
+	return true, nil
+}
+
+// Read the addresses in a server list file.
+func parseServerListFile(data []byte) []string {
+	var addrs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if fields := strings.Fields(line); len(fields) > 0 {
+			addrs = append(addrs, fields[0])
+		}
+	}
+	return addrs
+}
+
+// SrvMasterQuerier lists the servers named by a DNS SRV record, such as
+// _hlds._udp.servers.example.com. Each target and port is a server. Like
+// StaticMasterQuerier, it leaves the AppID and name filters to MatchInfo.
+type SrvMasterQuerier struct {
+	infoFilter
+	name string
+}
+
+// NewSrvMasterQuerier creates a querier for the SRV record at name.
+func NewSrvMasterQuerier(name string) *SrvMasterQuerier {
+	return &SrvMasterQuerier{name: name}
+}
+
+// Query looks up the record and lists its targets in one batch.
+func (q *SrvMasterQuerier) Query(callback MasterQueryCallback) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kSrvLookupTimeout)
+	defer cancel()
+
+	_, records, err := lookupSRV(ctx, "", "", q.name)
+	if err != nil {
+		return err
+	}
+
+	var addrs []string
+	for _, record := range records {
+		// A target of "." means the service isn't offered.
+		target := strings.TrimSuffix(record.Target, ".")
+		if target == "" {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+	}
+	return q.listAddrs(addrs, callback)
+}
+
+// Nothing to release.
+func (q *SrvMasterQuerier) Close() {
+}
@@ -0,0 +1,248 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listQuerier(t *testing.T, q MasterQuerier) []string {
+	var servers []string
+	err := q.Query(func(batch ServerList) error {
+		for _, addr := range batch {
+			servers = append(servers, addr.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return servers
+}
+
+func TestStaticMasterQuerier(t *testing.T) {
+	q := NewStaticMasterQuerier([]string{"10.0.0.1:27015", "10.0.0.2", "10.0.0.2:27016"})
+	want := []string{"10.0.0.1:27015", "10.0.0.2:27015", "10.0.0.2:27016"}
+	if servers := listQuerier(t, q); !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %v, want %v", servers, want)
+	}
+
+	q.FilterGameaddr("10.0.0.2")
+	want = []string{"10.0.0.2:27015", "10.0.0.2:27016"}
+	if servers := listQuerier(t, q); !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %v, want %v", servers, want)
+	}
+
+	bad := NewStaticMasterQuerier([]string{"not a host:port:x"})
+	if err := bad.Query(func(ServerList) error { return nil }); err == nil {
+		t.Error("expected an error when no address resolves")
+	}
+}
+
+func TestInfoFilterMatchInfo(t *testing.T) {
+	tf2 := &ServerInfo{Name: "Uncletopia | Seattle", Ext: &ExtendedInfo{AppId: App_TF2}}
+	cs := &ServerInfo{Name: "LAN party", Folder: "cstrike"}
+
+	tests := []struct {
+		configure func(f *infoFilter)
+		info      *ServerInfo
+		want      bool
+	}{
+		{func(f *infoFilter) {}, tf2, true},
+		{func(f *infoFilter) { f.FilterAppId(App_TF2) }, tf2, true},
+		{func(f *infoFilter) { f.FilterAppId(App_TF2) }, cs, false},
+		{func(f *infoFilter) { f.FilterAppIds([]AppId{App_TF2, App_CS}) }, cs, true},
+		{func(f *infoFilter) { f.FilterName("uncletopia*") }, tf2, true},
+		{func(f *infoFilter) { f.FilterName("*seattle") }, tf2, true},
+		{func(f *infoFilter) { f.FilterName("*chicago*") }, tf2, false},
+		{func(f *infoFilter) { f.FilterName("*") }, cs, true},
+	}
+	for i, test := range tests {
+		var f infoFilter
+		test.configure(&f)
+		if got := f.MatchInfo(nil, test.info); got != test.want {
+			t.Errorf("%d: got %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestServerListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.txt")
+	if err := os.WriteFile(path, []byte("# LAN\n10.0.0.1:27015\n\n10.0.0.2:27015  # upstairs\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := WatchServerListFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	want := []string{"10.0.0.1:27015", "10.0.0.2:27015"}
+	if addrs := f.Addrs(); !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v, want %v", addrs, want)
+	}
+
+	if err := os.WriteFile(path, []byte("10.0.0.3:27015\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"10.0.0.3:27015"}
+	deadline := time.Now().Add(2 * time.Second)
+	for !reflect.DeepEqual(f.Addrs(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v after the change, want %v", f.Addrs(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A missing file keeps the last list.
+	os.Remove(path)
+	if _, err := f.reload(); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if servers := listQuerier(t, f.NewQuerier()); !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %v, want %v", servers, want)
+	}
+
+	if _, err := WatchServerListFile(path, time.Second); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestSrvMasterQuerier(t *testing.T) {
+	defer func(old func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = old
+	}(lookupSRV)
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_hlds._udp.example.com" {
+			return "", nil, errors.New("no such host")
+		}
+		return name, []*net.SRV{
+			{Target: "10.0.0.1.", Port: 27015},
+			{Target: ".", Port: 0},
+			{Target: "10.0.0.2.", Port: 27016},
+		}, nil
+	}
+
+	want := []string{"10.0.0.1:27015", "10.0.0.2:27016"}
+	if servers := listQuerier(t, NewSrvMasterQuerier("_hlds._udp.example.com")); !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %v, want %v", servers, want)
+	}
+	if err := NewSrvMasterQuerier("_hlds._udp.example.org").Query(func(ServerList) error { return nil }); err == nil {
+		t.Error("expected a lookup error")
+	}
+}
+
+// A querier that filters by itself, like the Steam Web API.
+type fakeFilteringQuerier struct {
+	masterFilter
+	servers ServerList
+}
+
+func (q *fakeFilteringQuerier) Query(callback MasterQueryCallback) error {
+	return callback(q.servers)
+}
+
+func (q *fakeFilteringQuerier) Close() {
+}
+
+func TestMultiMasterQuerier(t *testing.T) {
+	steam := &fakeFilteringQuerier{servers: ServerList{{IP: net.IPv4(10, 0, 0, 1), Port: 27015}}}
+	static := NewStaticMasterQuerier([]string{"10.0.0.2:27015"})
+	other := NewStaticMasterQuerier([]string{"10.0.0.2:27015", "[::ffff:10.0.0.1]:27015", "10.0.0.3:27015"})
+	q := NewMultiMasterQuerier()
+	q.Add("steam", steam)
+	q.Add("static", static)
+	q.Add("other", other)
+	q.FilterAppId(App_TF2)
+
+	// Each server is listed once, by the first source that has it.
+	want := []string{"10.0.0.1:27015", "10.0.0.2:27015", "10.0.0.3:27015"}
+	servers := listQuerier(t, q)
+	if !reflect.DeepEqual(servers, want) {
+		t.Fatalf("got %v, want %v", servers, want)
+	}
+	if len(steam.filters) != 1 || len(static.appIds) != 1 {
+		t.Errorf("filters not passed on: %v, %v", steam.filters, static.appIds)
+	}
+
+	sources := map[string][]string{
+		"10.0.0.1:27015": {"steam", "other"},
+		"10.0.0.2:27015": {"static", "other"},
+		"10.0.0.3:27015": {"other"},
+	}
+	for addr, want := range sources {
+		tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+		if got := q.Sources(tcpAddr); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got sources %v, want %v", addr, got, want)
+		}
+	}
+
+	// Only the static server is checked against the filters.
+	needsInfo := map[string]bool{
+		"10.0.0.1:27015": false,
+		"10.0.0.2:27015": true,
+		"10.0.0.3:27015": true,
+		"10.0.0.4:27015": false,
+	}
+	for addr, want := range needsInfo {
+		tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+		if got := q.NeedsInfo(tcpAddr); got != want {
+			t.Errorf("%s: got NeedsInfo %t, want %t", addr, got, want)
+		}
+	}
+	cs := &ServerInfo{Folder: "cstrike"}
+	if !q.MatchInfo(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 27015}, cs) {
+		t.Error("server listed by a filtering source was rejected")
+	}
+	if q.MatchInfo(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 27015}, cs) {
+		t.Error("static server running another app was accepted")
+	}
+}
+
+type fakeListingQuerier struct {
+	fakeFilteringQuerier
+	listings []*ServerListing
+}
+
+func (q *fakeListingQuerier) QueryListings(callback MasterListingCallback) error {
+	return callback(q.listings)
+}
+
+func TestMultiMasterQuerierGamePorts(t *testing.T) {
+	// Steam lists the query port and reports the game port, which the static
+	// list has. The file list had it first, so it can't be skipped there.
+	steam := &fakeListingQuerier{listings: []*ServerListing{
+		{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 27016}, GamePort: 27015},
+		{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 27016}, GamePort: 27015},
+		{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 27015}, GamePort: 27015},
+	}}
+	q := NewMultiMasterQuerier()
+	q.Add("file", NewStaticMasterQuerier([]string{"10.0.0.2:27015"}))
+	q.Add("steam", steam)
+	q.Add("static", NewStaticMasterQuerier([]string{"10.0.0.1:27015", "10.0.0.3:27015"}))
+
+	want := []string{"10.0.0.2:27015", "10.0.0.1:27016", "10.0.0.2:27016", "10.0.0.3:27015"}
+	if servers := listQuerier(t, q); !reflect.DeepEqual(servers, want) {
+		t.Fatalf("got %v, want %v", servers, want)
+	}
+
+	sources := map[string][]string{
+		"10.0.0.1:27016": {"steam", "static"},
+		"10.0.0.1:27015": {"steam", "static"},
+		"10.0.0.2:27015": {"file"},
+		"10.0.0.3:27015": {"steam", "static"},
+	}
+	for addr, want := range sources {
+		tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+		if got := q.Sources(tcpAddr); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got sources %v, want %v", addr, got, want)
+		}
+	}
+}
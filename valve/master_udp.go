@@ -0,0 +1,217 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// A region of the world a master server can be asked about.
+type MasterRegion uint8
+
+const (
+	RegionUSEast       MasterRegion = 0x00
+	RegionUSWest       MasterRegion = 0x01
+	RegionSouthAmerica MasterRegion = 0x02
+	RegionEurope       MasterRegion = 0x03
+	RegionAsia         MasterRegion = 0x04
+	RegionAustralia    MasterRegion = 0x05
+	RegionMiddleEast   MasterRegion = 0x06
+	RegionAfrica       MasterRegion = 0x07
+	RegionAll          MasterRegion = 0xFF
+)
+
+var masterRegionNames = map[MasterRegion]string{
+	RegionUSEast:       "us-east",
+	RegionUSWest:       "us-west",
+	RegionSouthAmerica: "south-america",
+	RegionEurope:       "europe",
+	RegionAsia:         "asia",
+	RegionAustralia:    "australia",
+	RegionMiddleEast:   "middle-east",
+	RegionAfrica:       "africa",
+	RegionAll:          "all",
+}
+
+func (mr MasterRegion) String() string {
+	if name, ok := masterRegionNames[mr]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(mr))
+}
+
+// Parse a region name, such as "europe", as returned by String.
+func ParseMasterRegion(name string) (MasterRegion, error) {
+	for region, regionName := range masterRegionNames {
+		if strings.EqualFold(name, regionName) {
+			return region, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown master server region %q", name)
+}
+
+var (
+	ErrBadMasterReply     = errors.New("bad master server reply")
+	ErrMasterNotPaging    = errors.New("master server repeated a page")
+	ErrTooManyMasterPages = errors.New("master server sent too many pages")
+)
+
+const (
+	// The 0x31 request, and the header of its replies.
+	kMasterQuery = 0x31
+
+	// Pages fetched before giving up on a master that never ends its list.
+	kMaxMasterPages = 10000
+)
+
+var kMasterReplyHeader = []byte{0xff, 0xff, 0xff, 0xff, 0x66, 0x0a}
+
+// The seed for the first page, and the address that ends the list.
+const kMasterListEnd = "0.0.0.0:0"
+
+// UdpMasterQuerier lists servers from a master server speaking the legacy UDP
+// protocol (0x31), such as community GoldSrc masters. It needs no API key.
+//
+// Each request asks for the page of servers following the last address
+// seen; the master marks the end of the list with 0.0.0.0:0.
+type UdpMasterQuerier struct {
+	masterFilter
+	socket  *UdpSocket
+	region  MasterRegion
+	retries int
+}
+
+// NewUdpMasterQuerier creates a querier for the master server at host:port.
+// Each page must arrive within the timeout.
+func NewUdpMasterQuerier(hostAndPort string, timeout time.Duration) (*UdpMasterQuerier, error) {
+	socket, err := NewUdpSocket(hostAndPort, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &UdpMasterQuerier{
+		socket:  socket,
+		region:  RegionAll,
+		retries: 2,
+	}, nil
+}
+
+// Only list servers in a region. By default, every region is listed.
+func (q *UdpMasterQuerier) SetRegion(region MasterRegion) {
+	q.region = region
+}
+
+// Set how many times a request for a page is resent if no reply arrives.
+func (q *UdpMasterQuerier) SetRetries(retries int) {
+	q.retries = retries
+}
+
+// Query queries the server list, calling the callback once per page.
+func (q *UdpMasterQuerier) Query(callback MasterQueryCallback) error {
+	seed := kMasterListEnd
+	var previous []byte
+	for page := 0; page < kMaxMasterPages; page++ {
+		reply, err := q.exchange(q.request(seed), previous)
+		if err != nil {
+			return err
+		}
+		previous = reply
+		servers, done, err := parseMasterReply(reply)
+		if err != nil {
+			return err
+		}
+
+		// Some masters start each page with the seed.
+		if len(servers) > 0 && servers[0].String() == seed {
+			servers = servers[1:]
+		}
+		if len(servers) > 0 {
+			if err := callback(servers); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
+		}
+		if len(servers) == 0 {
+			return ErrMasterNotPaging
+		}
+		seed = servers[len(servers)-1].String()
+	}
+	return ErrTooManyMasterPages
+}
+
+// Build the request for the page following seed.
+func (q *UdpMasterQuerier) request(seed string) []byte {
+	var builder PacketBuilder
+	builder.WriteUint8(kMasterQuery)
+	builder.WriteUint8(uint8(q.region))
+	builder.WriteCString(seed)
+	builder.WriteCString(q.filterString())
+	return builder.Bytes()
+}
+
+// Send a request, resending it if the reply is lost. A late copy of the
+// previous page, answering a resent request, is skipped.
+func (q *UdpMasterQuerier) exchange(request []byte, previous []byte) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt <= q.retries; attempt++ {
+		if err = q.socket.Send(request); err != nil {
+			return nil, err
+		}
+		var reply []byte
+		for {
+			reply, err = q.socket.Recv()
+			if err != nil || !bytes.Equal(reply, previous) {
+				break
+			}
+		}
+		if err == nil {
+			return reply, nil
+		}
+		if !IsTimeout(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// parseMasterReply reads the addresses in a page, and whether the page ends
+// the list.
+func parseMasterReply(reply []byte) (ServerList, bool, error) {
+	if !bytes.HasPrefix(reply, kMasterReplyHeader) {
+		return nil, false, ErrBadMasterReply
+	}
+
+	reader := NewPacketReader(reply[len(kMasterReplyHeader):])
+	var servers ServerList
+	for reader.More() {
+		ip, err := reader.ReadIPv4()
+		if err != nil {
+			return nil, false, err
+		}
+		port, err := reader.ReadPort()
+		if err != nil {
+			return nil, false, err
+		}
+
+		addr := &net.TCPAddr{
+			// The reader's buffer is the packet; keep a copy.
+			IP:   net.IPv4(ip[0], ip[1], ip[2], ip[3]),
+			Port: int(port),
+		}
+		if addr.String() == kMasterListEnd {
+			return servers, true, nil
+		}
+		servers = append(servers, addr)
+	}
+	return servers, false, nil
+}
+
+// Close the socket used to query.
+func (q *UdpMasterQuerier) Close() {
+	q.socket.Close()
+}
@@ -0,0 +1,166 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/mastertest"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+func newTestMaster(t *testing.T, configure func(s *mastertest.Server), entries ...webapitest.Entry) *mastertest.Server {
+	master := mastertest.NewUnstartedServer(entries...)
+	if configure != nil {
+		configure(master)
+	}
+	master.Start()
+	t.Cleanup(master.Close)
+	return master
+}
+
+func queryMaster(t *testing.T, master *mastertest.Server, configure func(q *valve.UdpMasterQuerier)) ([]string, error) {
+	q, err := valve.NewUdpMasterQuerier(master.Addr(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if configure != nil {
+		configure(q)
+	}
+
+	var servers []string
+	err = q.Query(func(batch valve.ServerList) error {
+		for _, addr := range batch {
+			servers = append(servers, addr.String())
+		}
+		return nil
+	})
+	return servers, err
+}
+
+func masterEntries(count int) ([]webapitest.Entry, []string) {
+	var entries []webapitest.Entry
+	var addrs []string
+	for i := 1; i <= count; i++ {
+		addr := fmt.Sprintf("10.0.0.%d:27015", i)
+		entries = append(entries, webapitest.Entry{Addr: addr, Appid: 440, Region: int(valve.RegionEurope)})
+		addrs = append(addrs, addr)
+	}
+	return entries, addrs
+}
+
+func TestUdpMasterQuerierPaging(t *testing.T) {
+	for _, repeatSeed := range []bool{false, true} {
+		entries, want := masterEntries(5)
+		master := newTestMaster(t, func(s *mastertest.Server) {
+			s.PageSize = 2
+			s.RepeatSeed = repeatSeed
+		}, entries...)
+
+		servers, err := queryMaster(t, master, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(servers, want) {
+			t.Errorf("repeatSeed=%v: got %v, want %v", repeatSeed, servers, want)
+		}
+
+		var seeds []string
+		for _, request := range master.Requests() {
+			seeds = append(seeds, request.Seed)
+		}
+		wantSeeds := []string{"0.0.0.0:0", "10.0.0.2:27015", "10.0.0.4:27015"}
+		if !reflect.DeepEqual(seeds, wantSeeds) {
+			t.Errorf("repeatSeed=%v: got seeds %v, want %v", repeatSeed, seeds, wantSeeds)
+		}
+	}
+}
+
+func TestUdpMasterQuerierFilters(t *testing.T) {
+	master := newTestMaster(t, nil,
+		webapitest.Entry{Addr: "10.0.0.1:27015", Name: "Uncletopia | Seattle", Appid: 440, Region: int(valve.RegionUSWest)},
+		webapitest.Entry{Addr: "10.0.0.2:27015", Name: "Uncletopia | London", Appid: 440, Region: int(valve.RegionEurope)},
+		webapitest.Entry{Addr: "10.0.0.3:27015", Name: "Some other server", Appid: 440, Region: int(valve.RegionEurope)},
+		webapitest.Entry{Addr: "10.0.0.4:27015", Name: "Uncletopia CS", Appid: 730, Region: int(valve.RegionEurope)},
+	)
+
+	servers, err := queryMaster(t, master, func(q *valve.UdpMasterQuerier) {
+		q.FilterAppId(valve.App_TF2)
+		q.FilterName("uncletopia*")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1:27015", "10.0.0.2:27015"}; !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %v, want %v", servers, want)
+	}
+
+	servers, err = queryMaster(t, master, func(q *valve.UdpMasterQuerier) {
+		q.SetRegion(valve.RegionEurope)
+		q.FilterAppId(valve.App_TF2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.2:27015", "10.0.0.3:27015"}; !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %v, want %v", servers, want)
+	}
+
+	request := master.Requests()[1]
+	wantFilters := []webapitest.Filter{{Key: "appid", Value: "440"}}
+	if request.Region != valve.RegionEurope || !reflect.DeepEqual(request.Filters, wantFilters) {
+		t.Errorf("got request %+v", request)
+	}
+}
+
+func TestUdpMasterQuerierEmptyList(t *testing.T) {
+	master := newTestMaster(t, nil)
+
+	servers, err := queryMaster(t, master, nil)
+	if err != nil || len(servers) != 0 {
+		t.Fatalf("got %v, %v", servers, err)
+	}
+}
+
+func TestUdpMasterQuerierRetries(t *testing.T) {
+	entries, want := masterEntries(3)
+	master := newTestMaster(t, func(s *mastertest.Server) {
+		s.PageSize = 2
+		s.DropRequests = 2
+	}, entries...)
+
+	servers, err := queryMaster(t, master, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %v, want %v", servers, want)
+	}
+
+	// Without retries, the lost request fails the query.
+	master = newTestMaster(t, func(s *mastertest.Server) {
+		s.DropRequests = 1
+	}, entries...)
+	_, err = queryMaster(t, master, func(q *valve.UdpMasterQuerier) {
+		q.SetRetries(0)
+	})
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
+
+func TestParseMasterRegion(t *testing.T) {
+	for _, region := range []valve.MasterRegion{valve.RegionUSEast, valve.RegionEurope, valve.RegionAll} {
+		parsed, err := valve.ParseMasterRegion(region.String())
+		if err != nil || parsed != region {
+			t.Errorf("%s: got %v, %v", region, parsed, err)
+		}
+	}
+	if _, err := valve.ParseMasterRegion("mars"); err == nil {
+		t.Error("expected an error")
+	}
+}
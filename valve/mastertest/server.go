@@ -0,0 +1,193 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Package mastertest runs a fake master server speaking the legacy UDP
+// protocol (0x31) on a local port, for testing code built on
+// UdpMasterQuerier. It serves the same entries and filters as webapitest.
+package mastertest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+// The address that starts and ends a server list.
+const listEnd = "0.0.0.0:0"
+
+// A Request records what a client asked for.
+type Request struct {
+	Region  valve.MasterRegion
+	Seed    string
+	Filters []webapitest.Filter
+}
+
+// A Server is a fake master server. The option fields must be set before
+// Start; the served entries can be changed at any time.
+type Server struct {
+	// Addresses sent per page.
+	PageSize int
+
+	// Start each page after the first with the seed address, like some
+	// masters do.
+	RepeatSeed bool
+
+	// Ignore this many requests before answering any, as if they were lost.
+	DropRequests int
+
+	conn net.PacketConn
+	done chan struct{}
+
+	mu       sync.Mutex
+	entries  []webapitest.Entry
+	requests []Request
+}
+
+// Create a server that is not listening yet. Entries are listed in order;
+// their Region is matched against the requested region.
+func NewUnstartedServer(entries ...webapitest.Entry) *Server {
+	return &Server{
+		PageSize: 231,
+		entries:  entries,
+	}
+}
+
+// Create and start a server with default options.
+func NewServer(entries ...webapitest.Entry) *Server {
+	server := NewUnstartedServer(entries...)
+	server.Start()
+	return server
+}
+
+// Start listening on a local port.
+func (s *Server) Start() {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic("mastertest: failed to listen: " + err.Error())
+	}
+	s.conn = conn
+	s.done = make(chan struct{})
+	go s.serve()
+}
+
+// The address the server is listening on, as host:port.
+func (s *Server) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Stop the server and wait for it to exit.
+func (s *Server) Close() {
+	s.conn.Close()
+	<-s.done
+}
+
+// Replace the listed servers.
+func (s *Server) SetEntries(entries ...webapitest.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+}
+
+// All requests received so far, dropped ones included.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serve() {
+	defer close(s.done)
+
+	buffer := make([]byte, 1400)
+	for {
+		n, addr, err := s.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		request, ok := parseRequest(buffer[:n])
+		if !ok {
+			continue
+		}
+		if reply := s.handle(request); reply != nil {
+			s.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// Decode a 0x31 request: region, seed address and filter string.
+func parseRequest(packet []byte) (Request, bool) {
+	if len(packet) < 2 || packet[0] != 0x31 {
+		return Request{}, false
+	}
+	fields := bytes.SplitN(packet[2:], []byte{0}, 3)
+	if len(fields) < 3 {
+		return Request{}, false
+	}
+	return Request{
+		Region:  valve.MasterRegion(packet[1]),
+		Seed:    string(fields[0]),
+		Filters: webapitest.ParseFilter(string(fields[1])),
+	}, true
+}
+
+func (s *Server) handle(request Request) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, request)
+	if s.DropRequests > 0 {
+		s.DropRequests--
+		return nil
+	}
+
+	var listed []string
+	for i := range s.entries {
+		entry := &s.entries[i]
+		if request.Region != valve.RegionAll && valve.MasterRegion(entry.Region) != request.Region {
+			continue
+		}
+		if webapitest.Matches(entry, request.Filters) {
+			listed = append(listed, entry.Addr)
+		}
+	}
+
+	// The page starts after the seed.
+	start := 0
+	for i, addr := range listed {
+		if addr == request.Seed {
+			start = i + 1
+			break
+		}
+	}
+	end := min(start+s.PageSize, len(listed))
+
+	var page []string
+	if s.RepeatSeed && request.Seed != listEnd {
+		page = append(page, request.Seed)
+	}
+	page = append(page, listed[start:end]...)
+	if end == len(listed) {
+		page = append(page, listEnd)
+	}
+
+	reply := []byte{0xff, 0xff, 0xff, 0xff, 0x66, 0x0a}
+	for _, addr := range page {
+		reply = append(reply, encodeAddr(addr)...)
+	}
+	return reply
+}
+
+// Encode an IPv4 address and port, as 6 bytes in network order.
+func encodeAddr(hostAndPort string) []byte {
+	out := make([]byte, 6)
+	addr, err := net.ResolveUDPAddr("udp4", hostAndPort)
+	if err != nil {
+		return out
+	}
+	copy(out, addr.IP.To4())
+	binary.BigEndian.PutUint16(out[4:], uint16(addr.Port))
+	return out
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
 	"net"
 	"time"
@@ -27,9 +28,68 @@ func (pb *PacketBuilder) WriteBytes(bytes []byte) {
 	pb.Write(bytes)
 }
 
+func (pb *PacketBuilder) WriteUint8(u8 uint8) {
+	pb.WriteByte(u8)
+}
+
+func (pb *PacketBuilder) WriteUint16(u16 uint16) {
+	pb.Write(binary.LittleEndian.AppendUint16(nil, u16))
+}
+
+func (pb *PacketBuilder) WriteUint32(u32 uint32) {
+	pb.Write(binary.LittleEndian.AppendUint32(nil, u32))
+}
+
+func (pb *PacketBuilder) WriteInt32(i32 int32) {
+	pb.WriteUint32(uint32(i32))
+}
+
+func (pb *PacketBuilder) WriteUint64(u64 uint64) {
+	pb.Write(binary.LittleEndian.AppendUint64(nil, u64))
+}
+
+func (pb *PacketBuilder) WriteFloat32(f32 float32) {
+	pb.WriteUint32(math.Float32bits(f32))
+}
+
+// A PacketError describes a read past the end of a packet.
+type PacketError struct {
+	// Name of the field being read, or the type if the field was not named.
+	Field string
+
+	// Offset of the field within the packet.
+	Offset int
+
+	// Number of bytes the field needed, or -1 for a string that was not
+	// null terminated.
+	Size int
+
+	// Number of bytes that were left in the packet.
+	Remaining int
+}
+
+func (pe *PacketError) Error() string {
+	if pe.Size < 0 {
+		return fmt.Sprintf("unterminated string %s at offset %d (%d bytes left)",
+			pe.Field, pe.Offset, pe.Remaining)
+	}
+	return fmt.Sprintf("truncated %s at offset %d: need %d bytes, %d left",
+		pe.Field, pe.Offset, pe.Size, pe.Remaining)
+}
+
+func (pe *PacketError) Unwrap() error {
+	return ErrOutOfBounds
+}
+
+// A PacketReader decodes little-endian fields from a packet. Reads never
+// panic: the first read that runs past the end of the packet records an
+// error, and it and all subsequent reads return zero values. Check Err()
+// once the fields of interest have been read.
 type PacketReader struct {
 	buffer []byte
 	pos    int
+	field  string
+	err    error
 }
 
 func NewPacketReader(packet []byte) *PacketReader {
@@ -39,15 +99,52 @@ func NewPacketReader(packet []byte) *PacketReader {
 	}
 }
 
-func (pr *PacketReader) canRead(size int) error {
-	if size+pr.pos > len(pr.buffer) {
-		return ErrOutOfBounds
+// Name the next field to be read, for error reporting.
+func (pr *PacketReader) Field(name string) *PacketReader {
+	pr.field = name
+	return pr
+}
+
+// The first error encountered while reading, if any.
+func (pr *PacketReader) Err() error {
+	return pr.err
+}
+
+func (pr *PacketReader) fail(kind string, size int) {
+	if pr.err != nil {
+		return
+	}
+
+	field := pr.field
+	if field == "" {
+		field = kind
+	}
+	pr.err = &PacketError{
+		Field:     field,
+		Offset:    pr.pos,
+		Size:      size,
+		Remaining: len(pr.buffer) - pr.pos,
+	}
+}
+
+// Check whether |size| bytes can be read. This consumes the field name.
+func (pr *PacketReader) canRead(kind string, size int) bool {
+	defer func() {
+		pr.field = ""
+	}()
+
+	if pr.err != nil {
+		return false
+	}
+	if size < 0 || size > len(pr.buffer)-pr.pos {
+		pr.fail(kind, size)
+		return false
 	}
-	return nil
+	return true
 }
 
 func (pr *PacketReader) Slice(count int) []byte {
-	if pr.canRead(count) != nil {
+	if !pr.canRead("bytes", count) {
 		return nil
 	}
 	bytes := pr.buffer[pr.pos : pr.pos+count]
@@ -59,9 +156,14 @@ func (pr *PacketReader) Pos() int {
 	return pr.pos
 }
 
+// The unread portion of the packet.
+func (pr *PacketReader) Remaining() []byte {
+	return pr.buffer[pr.pos:]
+}
+
 func (pr *PacketReader) ReadIPv4() (net.IP, error) {
-	if err := pr.canRead(net.IPv4len); err != nil {
-		return nil, err
+	if !pr.canRead("ipv4", net.IPv4len) {
+		return nil, pr.err
 	}
 
 	ip := net.IP(pr.buffer[pr.pos : pr.pos+net.IPv4len])
@@ -70,8 +172,8 @@ func (pr *PacketReader) ReadIPv4() (net.IP, error) {
 }
 
 func (pr *PacketReader) ReadPort() (uint16, error) {
-	if err := pr.canRead(2); err != nil {
-		return 0, err
+	if !pr.canRead("port", 2) {
+		return 0, pr.err
 	}
 
 	port := binary.BigEndian.Uint16(pr.buffer[pr.pos:])
@@ -80,67 +182,100 @@ func (pr *PacketReader) ReadPort() (uint16, error) {
 }
 
 func (pr *PacketReader) ReadUint8() uint8 {
+	if !pr.canRead("uint8", 1) {
+		return 0
+	}
 	b := pr.buffer[pr.pos]
 	pr.pos++
 	return b
 }
 
 func (pr *PacketReader) ReadUint16() uint16 {
+	if !pr.canRead("uint16", 2) {
+		return 0
+	}
 	u16 := binary.LittleEndian.Uint16(pr.buffer[pr.pos:])
 	pr.pos += 2
 	return u16
 }
 
 func (pr *PacketReader) ReadUint32() uint32 {
+	if !pr.canRead("uint32", 4) {
+		return 0
+	}
 	u32 := binary.LittleEndian.Uint32(pr.buffer[pr.pos:])
 	pr.pos += 4
 	return u32
 }
 
 func (pr *PacketReader) ReadInt32() int32 {
-	return int32(pr.ReadUint32())
+	if !pr.canRead("int32", 4) {
+		return 0
+	}
+	i32 := int32(binary.LittleEndian.Uint32(pr.buffer[pr.pos:]))
+	pr.pos += 4
+	return i32
 }
 
 func (pr *PacketReader) ReadUint64() uint64 {
+	if !pr.canRead("uint64", 8) {
+		return 0
+	}
 	u64 := binary.LittleEndian.Uint64(pr.buffer[pr.pos:])
 	pr.pos += 8
 	return u64
 }
 
 func (pr *PacketReader) ReadFloat32() float32 {
-	bits := pr.ReadUint32()
-
+	if !pr.canRead("float32", 4) {
+		return 0
+	}
+	bits := binary.LittleEndian.Uint32(pr.buffer[pr.pos:])
+	pr.pos += 4
 	return math.Float32frombits(bits)
 }
 
-func (pr *PacketReader) TryReadString() (string, bool) {
-	start := pr.pos
-	for pr.pos < len(pr.buffer) {
-		if pr.buffer[pr.pos] == 0 {
-			pr.pos++
-			return string(pr.buffer[start : pr.pos-1]), true
-		}
-		pr.pos++
+func (pr *PacketReader) readCString() (string, bool) {
+	if pr.err != nil {
+		return "", false
+	}
+
+	end := bytes.IndexByte(pr.buffer[pr.pos:], 0)
+	if end < 0 {
+		return "", false
 	}
-	return "", false
+	str := string(pr.buffer[pr.pos : pr.pos+end])
+	pr.pos += end + 1
+	return str, true
+}
+
+// Read a null terminated string. Unlike ReadString, a missing terminator is
+// not an error; the reader is left where it was.
+func (pr *PacketReader) TryReadString() (string, bool) {
+	pr.field = ""
+	return pr.readCString()
 }
 
 func (pr *PacketReader) ReadString() string {
-	start := pr.pos
-	for {
-		// Note: it's intended that we panic for strings that are not null
-		// terminated.
-		if pr.buffer[pr.pos] == 0 {
-			pr.pos++
-			break
-		}
-		pr.pos++
+	str, ok := pr.readCString()
+	if !ok {
+		pr.fail("string", -1)
 	}
-	return string(pr.buffer[start : pr.pos-1])
+	pr.field = ""
+	return str
 }
 
 func (pr *PacketReader) More() bool {
-	return pr.pos < len(pr.buffer)
+	return pr.err == nil && pr.pos < len(pr.buffer)
+}
+
+// A PacketConn exchanges datagrams with a single server.
+type PacketConn interface {
+	Send(bytes []byte) error
+	Recv() ([]byte, error)
+	SetTimeout(timeout time.Duration)
+	RemoteAddr() net.Addr
+	Close()
 }
 
 type UdpSocket struct {
@@ -197,6 +332,9 @@ func (us *UdpSocket) setNextQueryTime() {
 }
 
 func (us *UdpSocket) Send(bytes []byte) error {
+	if err := waitForRateLimit(us.cn.RemoteAddr(), us.timeout); err != nil {
+		return err
+	}
 	us.enforceRateLimit()
 	defer us.setNextQueryTime()
 
@@ -0,0 +1,138 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPacketReaderStickyError(t *testing.T) {
+	reader := NewPacketReader([]byte{0x01, 0x02, 0x03})
+	if v := reader.Field("a").ReadUint16(); v != 0x0201 {
+		t.Fatalf("got %#x", v)
+	}
+	if v := reader.Field("b").ReadUint32(); v != 0 {
+		t.Fatalf("got %#x, want 0 after a failed read", v)
+	}
+
+	// Later reads must not replace the first error, even if they would fit.
+	if v := reader.Field("c").ReadUint8(); v != 0 {
+		t.Fatalf("got %#x, want 0 after an error", v)
+	}
+	if reader.More() {
+		t.Fatal("More() should be false after an error")
+	}
+
+	var pe *PacketError
+	if !errors.As(reader.Err(), &pe) {
+		t.Fatalf("got %v, want a *PacketError", reader.Err())
+	}
+	if pe.Field != "b" || pe.Offset != 2 || pe.Size != 4 || pe.Remaining != 1 {
+		t.Fatalf("got %+v", pe)
+	}
+	if !errors.Is(reader.Err(), ErrOutOfBounds) {
+		t.Fatal("PacketError should wrap ErrOutOfBounds")
+	}
+}
+
+func TestPacketReaderUnnamedField(t *testing.T) {
+	reader := NewPacketReader(nil)
+	reader.ReadUint64()
+
+	var pe *PacketError
+	if !errors.As(reader.Err(), &pe) || pe.Field != "uint64" || pe.Size != 8 {
+		t.Fatalf("got %v", reader.Err())
+	}
+}
+
+func TestPacketReaderStrings(t *testing.T) {
+	reader := NewPacketReader([]byte("abc\x00de"))
+	if s := reader.Field("first").ReadString(); s != "abc" {
+		t.Fatalf("got %q", s)
+	}
+	if _, ok := reader.TryReadString(); ok {
+		t.Fatal("TryReadString should fail on an unterminated string")
+	}
+	if reader.Err() != nil {
+		t.Fatalf("TryReadString should not set an error, got %v", reader.Err())
+	}
+
+	reader.Field("second").ReadString()
+	var pe *PacketError
+	if !errors.As(reader.Err(), &pe) {
+		t.Fatalf("got %v", reader.Err())
+	}
+	if pe.Field != "second" || pe.Offset != 4 || pe.Size != -1 || pe.Remaining != 2 {
+		t.Fatalf("got %+v", pe)
+	}
+	if got, want := pe.Error(), "unterminated string second at offset 4 (2 bytes left)"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseTruncatedInfo(t *testing.T) {
+	var packet PacketBuilder
+	packet.WriteInt32(-1)
+	packet.WriteUint8(S2A_INFO_SOURCE)
+	packet.WriteUint8(17)
+	packet.WriteCString("name")
+	packet.WriteCString("map")
+	packet.WriteCString("folder")
+	packet.WriteCString("game")
+	packet.WriteUint16(uint16(App_TF2))
+	packet.WriteUint8(1)
+	full := packet.Bytes()
+
+	sq := &ServerQuerier{}
+	err := sq.parse_a2s_info_reply(&ServerInfo{}, full)
+
+	var pe *PacketError
+	if !errors.As(err, &pe) || pe.Field != "max_players" || pe.Offset != len(full) {
+		t.Fatalf("got %v", err)
+	}
+
+	err = sq.parse_a2s_info_reply(&ServerInfo{}, full[:13])
+	if !errors.As(err, &pe) || pe.Field != "map" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestParseTruncatedPlayers(t *testing.T) {
+	var packet PacketBuilder
+	packet.WriteInt32(-1)
+	packet.WriteUint8(S2A_PLAYER)
+	packet.WriteUint8(2)
+	packet.WriteUint8(0)
+	packet.WriteCString("alice")
+	packet.WriteUint32(10)
+	packet.WriteFloat32(1.5)
+	packet.WriteUint8(1)
+	packet.WriteCString("bob")
+	packet.WriteUint16(0)
+
+	sq := &ServerQuerier{}
+	_, err := sq.processPlayers(packet.Bytes(), false)
+
+	var pe *PacketError
+	if !errors.As(err, &pe) || pe.Field != "player[1].score" || pe.Size != 4 || pe.Remaining != 2 {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestDecodeMultiPacketHeaderErrors(t *testing.T) {
+	sq := &ServerQuerier{}
+	if _, err := sq.decodeMultiPacketHeader([]byte{0xfe, 0xff, 0xff, 0xff, 0x01}); err != ErrUnknownGameEngine {
+		t.Fatalf("got %v, want ErrUnknownGameEngine", err)
+	}
+
+	sq.info = &ServerInfo{InfoVersion: S2A_INFO_GOLDSRC}
+	if _, err := sq.decodeMultiPacketHeader([]byte{0xff, 0xff, 0xff, 0xff}); err != ErrBadPacketHeader {
+		t.Fatalf("got %v, want ErrBadPacketHeader", err)
+	}
+
+	var pe *PacketError
+	_, err := sq.decodeMultiPacketHeader([]byte{0xfe, 0xff, 0xff, 0xff, 0x01, 0x00})
+	if !errors.As(err, &pe) || pe.Field != "id" {
+		t.Fatalf("got %v", err)
+	}
+}
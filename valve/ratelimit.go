@@ -0,0 +1,192 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRateLimited = errors.New("too many queries to this address")
+
+// Rate limiter applied to every A2S packet sent by UdpSocket and UdpMux. If
+// nil, packets are not limited.
+var DefaultRateLimiter *RateLimiter
+
+// How often idle buckets are swept from a RateLimiter.
+const kRateLimitSweepInterval = time.Minute
+
+// A RateLimiter is a token bucket per destination IP. Servers rate limit A2S
+// by source address, and hosts often run many servers on one address, so the
+// limit is shared by all ports on an IP and by all requests in the process.
+type RateLimiter struct {
+	rate  float64 // Tokens per second.
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[netip.Addr]*rateBucket
+	lastSweep time.Time
+
+	throttled atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Counters for a RateLimiter.
+type RateLimiterStats struct {
+	// Packets that had to wait for their destination's bucket to refill.
+	Throttled uint64
+
+	// Packets that were not sent because the wait would have exceeded the
+	// query timeout, or that Allow turned away.
+	Rejected uint64
+
+	// Destinations currently tracked.
+	Destinations int
+}
+
+// Create a limiter allowing |qps| packets per second to each destination IP,
+// with bursts of up to |burst| packets. A |qps| of zero or less disables the
+// limit.
+func NewRateLimiter(qps float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:      qps,
+		burst:     float64(burst),
+		buckets:   map[netip.Addr]*rateBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve a packet to |addr|, and return how long to wait before sending it.
+// If the wait would exceed |maxWait|, nothing is reserved and this returns
+// ErrRateLimited. A |maxWait| of zero means no limit.
+func (rl *RateLimiter) Reserve(addr netip.Addr, maxWait time.Duration) (time.Duration, error) {
+	if rl.rate <= 0 {
+		return 0, nil
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	bucket := rl.refill(addr)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, nil
+	}
+
+	// Tokens go negative for reservations that are waiting.
+	wait := time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	if maxWait > 0 && wait > maxWait {
+		rl.rejected.Add(1)
+		return 0, ErrRateLimited
+	}
+	bucket.tokens--
+	rl.throttled.Add(1)
+	return wait, nil
+}
+
+// Take a packet to |addr| if it can be sent right away. Packets turned away
+// count as rejected.
+func (rl *RateLimiter) Allow(addr netip.Addr) bool {
+	if rl.rate <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	bucket := rl.refill(addr)
+	if bucket.tokens < 1 {
+		rl.rejected.Add(1)
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// The bucket for |addr|, topped up for the time since it was last used. The
+// caller holds mu.
+func (rl *RateLimiter) refill(addr netip.Addr) *rateBucket {
+	addr = addr.Unmap()
+	now := time.Now()
+	rl.sweep(now)
+
+	bucket, ok := rl.buckets[addr]
+	if !ok {
+		bucket = &rateBucket{tokens: rl.burst, last: now}
+		rl.buckets[addr] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rl.rate
+	if bucket.tokens > rl.burst {
+		bucket.tokens = rl.burst
+	}
+	bucket.last = now
+	return bucket
+}
+
+// Block until a packet may be sent to |addr|. See Reserve.
+func (rl *RateLimiter) Wait(addr netip.Addr, maxWait time.Duration) error {
+	wait, err := rl.Reserve(addr, maxWait)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return nil
+}
+
+func (rl *RateLimiter) Stats() RateLimiterStats {
+	rl.mu.Lock()
+	destinations := len(rl.buckets)
+	rl.mu.Unlock()
+
+	return RateLimiterStats{
+		Throttled:    rl.throttled.Load(),
+		Rejected:     rl.rejected.Load(),
+		Destinations: destinations,
+	}
+}
+
+// Forget buckets that have refilled, since they'd be recreated identically.
+// The caller holds mu.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < kRateLimitSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+
+	for addr, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, addr)
+		}
+	}
+}
+
+// Apply DefaultRateLimiter to a packet bound for |addr|.
+func waitForRateLimit(addr net.Addr, maxWait time.Duration) error {
+	limiter := DefaultRateLimiter
+	if limiter == nil {
+		return nil
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	ip, ok := netip.AddrFromSlice(udpAddr.IP)
+	if !ok {
+		return nil
+	}
+	return limiter.Wait(ip, maxWait)
+}
@@ -0,0 +1,71 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+)
+
+func TestRateLimiterBurstAndRate(t *testing.T) {
+	limiter := valve.NewRateLimiter(10, 2)
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	for i := 0; i < 2; i++ {
+		if wait, err := limiter.Reserve(ip, 0); wait != 0 || err != nil {
+			t.Fatalf("packet %d: got wait %v, err %v", i, wait, err)
+		}
+	}
+
+	// The third packet has to wait about 100ms for a token.
+	if _, err := limiter.Reserve(ip, 50*time.Millisecond); err != valve.ErrRateLimited {
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+	wait, err := limiter.Reserve(ip, time.Second)
+	if err != nil || wait < 50*time.Millisecond || wait > 100*time.Millisecond {
+		t.Fatalf("got wait %v, err %v", wait, err)
+	}
+
+	// Other addresses have their own bucket, but an IPv4-mapped IPv6 address
+	// is the same destination.
+	if wait, _ := limiter.Reserve(netip.MustParseAddr("192.0.2.2"), 0); wait != 0 {
+		t.Fatalf("got wait %v for another IP", wait)
+	}
+	if wait, _ := limiter.Reserve(netip.MustParseAddr("::ffff:192.0.2.1"), time.Second); wait == 0 {
+		t.Fatal("IPv4-mapped address should share the IPv4 bucket")
+	}
+
+	stats := limiter.Stats()
+	if stats.Throttled != 2 || stats.Rejected != 1 || stats.Destinations != 2 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestRateLimiterAppliesToQueries(t *testing.T) {
+	server := a2stest.NewServer(a2stest.SourceInfo())
+	defer server.Close()
+
+	old := valve.DefaultRateLimiter
+	valve.DefaultRateLimiter = valve.NewRateLimiter(1, 1)
+	defer func() { valve.DefaultRateLimiter = old }()
+
+	sq, err := valve.NewServerQuerier(server.Addr(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sq.Close()
+
+	if _, err := sq.QueryInfo(); err != nil {
+		t.Fatal(err)
+	}
+	// The next packet would have to wait a second.
+	if _, err := sq.QueryPlayers(); err != valve.ErrRateLimited {
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+	if stats := valve.DefaultRateLimiter.Stats(); stats.Rejected != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+}
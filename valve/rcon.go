@@ -0,0 +1,263 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRconAuthFailed = errors.New("rcon authentication failed")
+var ErrRconNotAuthenticated = errors.New("rcon client is not authenticated")
+var ErrRconBadPacket = errors.New("bad rcon packet")
+var ErrRconPacketTooLarge = errors.New("rcon packet is too large")
+
+// Source RCON packet types. Note that SERVERDATA_EXECCOMMAND and
+// SERVERDATA_AUTH_RESPONSE share the same value; which one is meant depends on
+// the direction of the packet.
+const (
+	SERVERDATA_RESPONSE_VALUE int32 = 0
+	SERVERDATA_EXECCOMMAND    int32 = 2
+	SERVERDATA_AUTH_RESPONSE  int32 = 2
+	SERVERDATA_AUTH           int32 = 3
+)
+
+// The maximum size of a single RCON packet, as enforced by SRCDS. The size
+// field does not count itself.
+const kMaxRconPacketSize = 4096
+
+// The smallest possible RCON packet: id, type and two null terminators.
+const kMinRconPacketSize = 10
+
+// An RconPacket is a single Source RCON packet.
+type RconPacket struct {
+	Id   int32
+	Type int32
+	Body string
+}
+
+// An RconSession executes commands against a server, regardless of which RCON
+// protocol it speaks.
+type RconSession interface {
+	Authenticate(password string) error
+	Execute(command string) (string, error)
+	Close()
+}
+
+// An RconClient issues commands to a server using the Source RCON protocol,
+// which runs over TCP on the game port.
+type RconClient struct {
+	cn      net.Conn
+	timeout time.Duration
+	mu      sync.Mutex
+	nextId  int32
+	authed  bool
+}
+
+// Create a new RCON client connected to the given server.
+func NewRconClient(hostAndPort string, timeout time.Duration) (*RconClient, error) {
+	cn, err := net.DialTimeout("tcp", hostAndPort, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &RconClient{
+		cn:      cn,
+		timeout: timeout,
+		nextId:  1,
+	}, nil
+}
+
+// Create an RCON session using the protocol that matches the server's engine.
+// The info must come from a prior A2S_INFO query.
+func NewRconSession(info *ServerInfo, hostAndPort string, timeout time.Duration) (RconSession, error) {
+	return DialRcon(hostAndPort, info.GameEngine(), timeout)
+}
+
+// Create an RCON session using the protocol of the given engine. If the
+// engine is zero, it is detected by querying the server's info first, which
+// fails if A2S queries don't get through.
+func DialRcon(hostAndPort string, engine GameEngine, timeout time.Duration) (RconSession, error) {
+	if engine == 0 {
+		query, err := NewServerQuerier(hostAndPort, timeout)
+		if err != nil {
+			return nil, err
+		}
+		defer query.Close()
+
+		info, err := query.QueryInfo()
+		if err != nil {
+			return nil, err
+		}
+		engine = info.GameEngine()
+	}
+
+	if engine == GOLDSRC {
+		return NewGoldSrcRconClient(hostAndPort, timeout)
+	}
+	return NewRconClient(hostAndPort, timeout)
+}
+
+// Close the connection to the server.
+func (rc *RconClient) Close() {
+	rc.cn.Close()
+}
+
+// Authenticate with the server. This must be called before Execute.
+func (rc *RconClient) Authenticate(password string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	id := rc.allocId()
+	if err := rc.writePacket(&RconPacket{Id: id, Type: SERVERDATA_AUTH, Body: password}); err != nil {
+		return err
+	}
+
+	// SRCDS sends an empty SERVERDATA_RESPONSE_VALUE before the actual auth
+	// response. Other implementations do not, so skip anything that is not an
+	// auth response.
+	for {
+		packet, err := rc.readPacket()
+		if err != nil {
+			return err
+		}
+		if packet.Type != SERVERDATA_AUTH_RESPONSE {
+			continue
+		}
+		if packet.Id == -1 || packet.Id != id {
+			return ErrRconAuthFailed
+		}
+		rc.authed = true
+		return nil
+	}
+}
+
+// Execute a command and return its full output.
+//
+// Responses larger than a single packet are split by the server with no
+// indication of where they end. To find the end, we send an empty
+// SERVERDATA_RESPONSE_VALUE right after the command. The server processes
+// packets in order and mirrors the empty packet back, so everything received
+// before the mirror belongs to the command.
+func (rc *RconClient) Execute(command string) (string, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if !rc.authed {
+		return "", ErrRconNotAuthenticated
+	}
+
+	commandId := rc.allocId()
+	terminatorId := rc.allocId()
+
+	if err := rc.writePacket(&RconPacket{Id: commandId, Type: SERVERDATA_EXECCOMMAND, Body: command}); err != nil {
+		return "", err
+	}
+	if err := rc.writePacket(&RconPacket{Id: terminatorId, Type: SERVERDATA_RESPONSE_VALUE}); err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	for {
+		packet, err := rc.readPacket()
+		if err != nil {
+			return "", err
+		}
+
+		switch packet.Id {
+		case commandId:
+			output.WriteString(packet.Body)
+		case terminatorId:
+			return output.String(), nil
+		default:
+			// Stale packets, such as the trailing 0x01 reply SRCDS sends after
+			// mirroring a previous terminator. Ignore them.
+		}
+	}
+}
+
+func (rc *RconClient) allocId() int32 {
+	id := rc.nextId
+	rc.nextId++
+	if rc.nextId <= 0 {
+		rc.nextId = 1
+	}
+	return id
+}
+
+func (rc *RconClient) deadline() time.Time {
+	if rc.timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(rc.timeout)
+}
+
+func (rc *RconClient) writePacket(packet *RconPacket) error {
+	data, err := EncodeRconPacket(packet)
+	if err != nil {
+		return err
+	}
+
+	rc.cn.SetWriteDeadline(rc.deadline())
+	_, err = rc.cn.Write(data)
+	return err
+}
+
+func (rc *RconClient) readPacket() (*RconPacket, error) {
+	rc.cn.SetReadDeadline(rc.deadline())
+	return ReadRconPacket(rc.cn)
+}
+
+// Encode an RCON packet, including its leading size field.
+func EncodeRconPacket(packet *RconPacket) ([]byte, error) {
+	size := 4 + 4 + len(packet.Body) + 2
+	if size > kMaxRconPacketSize {
+		return nil, ErrRconPacketTooLarge
+	}
+
+	var builder PacketBuilder
+	builder.WriteInt32(int32(size))
+	builder.WriteInt32(packet.Id)
+	builder.WriteInt32(packet.Type)
+	builder.WriteCString(packet.Body)
+	builder.WriteByte(0)
+	return builder.Bytes(), nil
+}
+
+// Read a single RCON packet from a stream.
+func ReadRconPacket(r io.Reader) (*RconPacket, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := int32(binary.LittleEndian.Uint32(header[:]))
+	if size < kMinRconPacketSize {
+		return nil, ErrRconBadPacket
+	}
+	if size > kMaxRconPacketSize {
+		return nil, ErrRconPacketTooLarge
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	reader := NewPacketReader(data)
+	packet := &RconPacket{
+		Id:   reader.ReadInt32(),
+		Type: reader.ReadInt32(),
+	}
+
+	// The body is null terminated, followed by an empty string.
+	body, ok := reader.TryReadString()
+	if !ok {
+		return nil, ErrRconBadPacket
+	}
+	packet.Body = body
+	return packet, nil
+}
@@ -0,0 +1,186 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrRconBadChallenge = errors.New("rcon challenge was rejected")
+var ErrRconReplyTooLarge = errors.New("rcon reply is too large")
+var ErrRconInvalidPassword = errors.New("rcon password contains quotes or line breaks")
+
+// Upper bound on the output of a single GoldSrc RCON command. Without it, a
+// server that keeps sending packets would keep us reading forever.
+const kMaxGoldSrcRconOutput = 1024 * 1024
+
+// A GoldSrcRconClient issues commands to a Half-Life 1 server using the UDP
+// "challenge rcon" protocol.
+type GoldSrcRconClient struct {
+	// Split replies use the same framing as A2S replies, so we reuse the
+	// querier's socket and multi-packet handling.
+	sq        *ServerQuerier
+	password  string
+	challenge string
+}
+
+// Create a new GoldSrc RCON client for the given server.
+func NewGoldSrcRconClient(hostAndPort string, timeout time.Duration) (*GoldSrcRconClient, error) {
+	socket, err := NewUdpSocket(hostAndPort, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &GoldSrcRconClient{
+		sq: &ServerQuerier{
+			socket:  socket,
+			timeout: timeout,
+			info:    &ServerInfo{InfoVersion: S2A_INFO_GOLDSRC},
+		},
+	}, nil
+}
+
+// Close the socket used for RCON.
+func (gc *GoldSrcRconClient) Close() {
+	gc.sq.Close()
+}
+
+// Request a challenge and remember the password for subsequent commands.
+// GoldSrc has no separate login step, so a wrong password is only detected
+// when a command is executed. The password is sent in quotes, so passwords
+// containing quotes or line breaks are rejected.
+func (gc *GoldSrcRconClient) Authenticate(password string) error {
+	if strings.ContainsAny(password, "\"\r\n") {
+		return ErrRconInvalidPassword
+	}
+	gc.password = password
+	return Try(gc.requestChallenge)
+}
+
+// Execute a command and return its full output.
+func (gc *GoldSrcRconClient) Execute(command string) (string, error) {
+	if gc.challenge == "" {
+		return "", ErrRconNotAuthenticated
+	}
+
+	var output string
+	var err error
+
+	// Note: must assign |err| in case there's a panic.
+	err = Try(func() error {
+		output, err = gc.execute(command)
+		if err == ErrRconBadChallenge {
+			// Challenges expire; get a new one and try once more.
+			if err = gc.requestChallenge(); err != nil {
+				return err
+			}
+			output, err = gc.execute(command)
+		}
+		return err
+	})
+
+	return output, err
+}
+
+func (gc *GoldSrcRconClient) requestChallenge() error {
+	var packet PacketBuilder
+	packet.WriteBytes([]byte{0xff, 0xff, 0xff, 0xff})
+	packet.WriteString("challenge rcon\n")
+	if err := gc.sq.socket.Send(packet.Bytes()); err != nil {
+		return err
+	}
+
+	data, err := gc.sq.socket.Recv()
+	if err != nil {
+		return err
+	}
+
+	challenge, err := parseRconChallenge(data)
+	if err != nil {
+		return err
+	}
+	gc.challenge = challenge
+	return nil
+}
+
+// Parse a "challenge rcon <number>" reply.
+func parseRconChallenge(data []byte) (string, error) {
+	reader := NewPacketReader(data)
+	if reader.ReadInt32() != -1 {
+		return "", ErrBadPacketHeader
+	}
+	reply, _ := reader.TryReadString()
+	fields := strings.Fields(reply)
+	if len(fields) != 3 || fields[0] != "challenge" || fields[1] != "rcon" {
+		return "", ErrBadChallengeResponse
+	}
+	return fields[2], nil
+}
+
+func (gc *GoldSrcRconClient) execute(command string) (string, error) {
+	var packet PacketBuilder
+	packet.WriteBytes([]byte{0xff, 0xff, 0xff, 0xff})
+	packet.WriteString("rcon " + gc.challenge + " \"" + gc.password + "\" " + command + "\n")
+	if err := gc.sq.socket.Send(packet.Bytes()); err != nil {
+		return "", err
+	}
+
+	data, err := gc.sq.socket.Recv()
+	if err != nil {
+		return "", err
+	}
+
+	// Long output comes as a split reply, whose header says how many
+	// packets to wait for.
+	output, err := gc.readReply(data)
+	if err != nil {
+		return "", err
+	}
+
+	result := string(output)
+	switch strings.TrimSpace(result) {
+	case "Bad rcon_password.":
+		return "", ErrRconAuthFailed
+	case "Bad challenge.":
+		return "", ErrRconBadChallenge
+	}
+	return result, nil
+}
+
+func (gc *GoldSrcRconClient) readReply(data []byte) ([]byte, error) {
+	if len(data) >= 4 && int32(binary.LittleEndian.Uint32(data)) == -2 {
+		full, _, err := gc.sq.waitForMultiPacketReply(data)
+		if err != nil {
+			return nil, err
+		}
+		data = full
+	}
+
+	text, err := parseGoldSrcRconReply(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(text) > kMaxGoldSrcRconOutput {
+		return nil, ErrRconReplyTooLarge
+	}
+	return text, nil
+}
+
+// Extract the text of a single (reassembled) S2A_RCON packet.
+func parseGoldSrcRconReply(data []byte) ([]byte, error) {
+	reader := NewPacketReader(data)
+	header := reader.Field("header").ReadInt32()
+	kind := reader.Field("type").ReadUint8()
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	if header != -1 {
+		return nil, ErrBadPacketHeader
+	}
+	if kind != S2A_RCON {
+		return nil, ErrRconBadPacket
+	}
+	return bytes.TrimRight(reader.Remaining(), "\x00"), nil
+}
@@ -0,0 +1,126 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+)
+
+func newGoldSrcRconServer(t *testing.T) *a2stest.Server {
+	server := a2stest.NewUnstartedServer(a2stest.GoldSrcInfo())
+	server.RconPassword = "hunter2"
+	server.MaxPacketSize = 200
+	server.SetRconOutput("status", "hostname: a2stest GoldSrc server\n")
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialGoldSrcRcon(t *testing.T, server *a2stest.Server) *valve.GoldSrcRconClient {
+	client, err := valve.NewGoldSrcRconClient(server.Addr(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestGoldSrcRconChallenge(t *testing.T) {
+	server := newGoldSrcRconServer(t)
+
+	client := dialGoldSrcRcon(t, server)
+	if _, err := client.Execute("status"); !errors.Is(err, valve.ErrRconNotAuthenticated) {
+		t.Fatalf("got %v before authenticating", err)
+	}
+	if err := client.Authenticate("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := client.Execute("status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "hostname: a2stest GoldSrc server\n" {
+		t.Errorf("got %q", output)
+	}
+	if queries := server.Queries(); queries != 2 {
+		t.Errorf("got %d packets, want a challenge request and a command", queries)
+	}
+}
+
+func TestGoldSrcRconSplitReply(t *testing.T) {
+	server := newGoldSrcRconServer(t)
+	long := strings.Repeat("cvar \"value\"\n", 100)
+	server.SetRconOutput("cvarlist", long)
+
+	client := dialGoldSrcRcon(t, server)
+	if err := client.Authenticate("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := client.Execute("cvarlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != long {
+		t.Errorf("got %d bytes, want %d", len(output), len(long))
+	}
+
+	// The next reply isn't mixed up with the split one.
+	if output, err := client.Execute("status"); err != nil || !strings.HasPrefix(output, "hostname:") {
+		t.Errorf("got %q, %v", output, err)
+	}
+}
+
+func TestGoldSrcRconBadPassword(t *testing.T) {
+	server := newGoldSrcRconServer(t)
+
+	// The password is only checked once a command runs.
+	client := dialGoldSrcRcon(t, server)
+	if err := client.Authenticate("wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Execute("status"); !errors.Is(err, valve.ErrRconAuthFailed) {
+		t.Fatalf("got %v, want %v", err, valve.ErrRconAuthFailed)
+	}
+
+	for _, password := range []string{"a\" quit \"", "a\nquit", "a\rquit"} {
+		client := dialGoldSrcRcon(t, server)
+		if err := client.Authenticate(password); !errors.Is(err, valve.ErrRconInvalidPassword) {
+			t.Errorf("%q: got %v, want %v", password, err, valve.ErrRconInvalidPassword)
+		}
+	}
+}
+
+func TestDialRconEngine(t *testing.T) {
+	server := newGoldSrcRconServer(t)
+
+	// With the engine given, the server isn't queried first.
+	session, err := valve.DialRcon(server.Addr(), valve.GOLDSRC, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if _, ok := session.(*valve.GoldSrcRconClient); !ok {
+		t.Fatalf("got %T", session)
+	}
+	if server.Queries() != 0 {
+		t.Errorf("got %d queries before authenticating", server.Queries())
+	}
+
+	// Without it, A2S_INFO picks the protocol.
+	session, err = valve.DialRcon(server.Addr(), 0, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if _, ok := session.(*valve.GoldSrcRconClient); !ok {
+		t.Fatalf("got %T", session)
+	}
+	if server.Queries() == 0 {
+		t.Error("server wasn't queried")
+	}
+}
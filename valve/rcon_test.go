@@ -0,0 +1,149 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+)
+
+// A fakeRconServer speaks Source RCON the way SRCDS does: an empty response
+// before each auth response, long output split over several packets, and
+// a trailing 0x01 packet after mirroring an empty terminator.
+type fakeRconServer struct {
+	listener net.Listener
+	password string
+
+	// Output of each command, split into one packet per element.
+	outputs map[string][]string
+}
+
+func newFakeRconServer(t *testing.T, password string, outputs map[string][]string) *fakeRconServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRconServer{listener: listener, password: password, outputs: outputs}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeRconServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRconServer) serve() {
+	for {
+		cn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(cn)
+	}
+}
+
+func (s *fakeRconServer) handle(cn net.Conn) {
+	defer cn.Close()
+
+	send := func(packet *valve.RconPacket) {
+		data, _ := valve.EncodeRconPacket(packet)
+		cn.Write(data)
+	}
+
+	authed := false
+	for {
+		packet, err := valve.ReadRconPacket(cn)
+		if err != nil {
+			return
+		}
+		switch packet.Type {
+		case valve.SERVERDATA_AUTH:
+			send(&valve.RconPacket{Id: packet.Id, Type: valve.SERVERDATA_RESPONSE_VALUE})
+			id := packet.Id
+			if packet.Body != s.password {
+				id = -1
+			}
+			send(&valve.RconPacket{Id: id, Type: valve.SERVERDATA_AUTH_RESPONSE})
+			authed = id != -1
+		case valve.SERVERDATA_EXECCOMMAND:
+			if !authed {
+				return
+			}
+			for _, body := range s.outputs[packet.Body] {
+				send(&valve.RconPacket{Id: packet.Id, Type: valve.SERVERDATA_RESPONSE_VALUE, Body: body})
+			}
+		case valve.SERVERDATA_RESPONSE_VALUE:
+			send(&valve.RconPacket{Id: packet.Id, Type: valve.SERVERDATA_RESPONSE_VALUE})
+			send(&valve.RconPacket{Id: packet.Id, Type: valve.SERVERDATA_RESPONSE_VALUE, Body: "\x00\x01\x00\x00"})
+		}
+	}
+}
+
+func dialRcon(t *testing.T, server *fakeRconServer) *valve.RconClient {
+	client, err := valve.NewRconClient(server.Addr(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestRconAuthenticate(t *testing.T) {
+	server := newFakeRconServer(t, "hunter2", nil)
+
+	client := dialRcon(t, server)
+	if _, err := client.Execute("status"); !errors.Is(err, valve.ErrRconNotAuthenticated) {
+		t.Fatalf("got %v before authenticating", err)
+	}
+	if err := client.Authenticate("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	client = dialRcon(t, server)
+	if err := client.Authenticate("wrong"); !errors.Is(err, valve.ErrRconAuthFailed) {
+		t.Fatalf("got %v, want %v", err, valve.ErrRconAuthFailed)
+	}
+	if _, err := client.Execute("status"); !errors.Is(err, valve.ErrRconNotAuthenticated) {
+		t.Fatalf("got %v after failing to authenticate", err)
+	}
+}
+
+func TestRconExecuteMultiPacket(t *testing.T) {
+	long := []string{strings.Repeat("a", 4000), strings.Repeat("b", 4000), "end\n"}
+	server := newFakeRconServer(t, "hunter2", map[string][]string{
+		"cvarlist": long,
+		"echo hi":  {"hi\n"},
+		"noop":     nil,
+	})
+
+	client := dialRcon(t, server)
+	if err := client.Authenticate("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each command is read up to its terminator, so the packets trailing
+	// one command's mirror don't leak into the next.
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"cvarlist", strings.Join(long, "")},
+		{"echo hi", "hi\n"},
+		{"noop", ""},
+		{"echo hi", "hi\n"},
+	}
+	for _, test := range tests {
+		output, err := client.Execute(test.command)
+		if err != nil {
+			t.Fatalf("%s: %v", test.command, err)
+		}
+		if output != test.want {
+			t.Errorf("%s: got %d bytes, want %d", test.command, len(output), len(test.want))
+		}
+	}
+}
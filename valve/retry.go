@@ -0,0 +1,149 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"time"
+)
+
+// A RetryPolicy controls how a ServerQuerier resends requests whose replies
+// were lost. The querier's timeout still bounds each exchange as a whole;
+// retransmissions only split that time into shorter attempts.
+type RetryPolicy struct {
+	// Number of times a request is resent after the first attempt.
+	Retries int
+
+	// How long to wait for a reply to the first attempt. Each retransmission
+	// waits twice as long as the one before it. If zero, the first wait is
+	// chosen so that the doubling waits add up to the querier's timeout.
+	InitialTimeout time.Duration
+}
+
+// Per-querier counters, for callers that tune concurrency to the network.
+type QueryStats struct {
+	// Number of requests that received a reply.
+	Replies int
+
+	// Number of times a request had to be resent.
+	Retransmits int
+
+	// Number of requests that got no reply at all.
+	Timeouts int
+
+	// Total round trip time of answered requests, measured from the last
+	// send.
+	Rtt time.Duration
+}
+
+// Average round trip time of answered requests.
+func (qs QueryStats) AverageRtt() time.Duration {
+	if qs.Replies == 0 {
+		return 0
+	}
+	return qs.Rtt / time.Duration(qs.Replies)
+}
+
+// IsTimeout reports whether an error is a network timeout, such as a server
+// not answering a query in time.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Set how requests are retransmitted. By default they are sent once.
+func (sq *ServerQuerier) SetRetryPolicy(policy RetryPolicy) {
+	sq.retry = policy
+}
+
+// Counters for all exchanges made by this querier so far.
+func (sq *ServerQuerier) Stats() QueryStats {
+	return sq.stats
+}
+
+// The wait for each attempt of an exchange, following the retry policy.
+func (sq *ServerQuerier) attemptTimeouts() []time.Duration {
+	attempts := sq.retry.Retries + 1
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	waits := make([]time.Duration, attempts)
+	wait := sq.retry.InitialTimeout
+	if wait <= 0 && sq.timeout > 0 {
+		// w + 2w + 4w + ... for n attempts is w * (2^n - 1).
+		wait = max(sq.timeout/time.Duration(1<<min(attempts, 32)-1), time.Millisecond)
+	}
+	for i := range waits {
+		waits[i] = wait
+		wait *= 2
+	}
+	return waits
+}
+
+// Send a request and wait for the first packet of the reply, resending the
+// request if nothing arrives in time.
+//
+// A reply to an earlier attempt may still show up after we've moved on. Such
+// a copy is identical to the reply we used, so the next exchange discards up
+// to one copy per retransmission.
+func (sq *ServerQuerier) exchange(request []byte) ([]byte, error) {
+	defer sq.socket.SetTimeout(sq.timeout)
+
+	var deadline time.Time
+	if sq.timeout > 0 {
+		deadline = time.Now().Add(sq.timeout)
+	}
+
+	var lastErr error
+	waits := sq.attemptTimeouts()
+	for i, wait := range waits {
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+			// The last attempt gets whatever time is left.
+			if wait > remaining || i == len(waits)-1 {
+				wait = remaining
+			}
+		}
+
+		if i > 0 {
+			sq.stats.Retransmits++
+		}
+		data, err := sq.sendAndRecv(request, wait)
+		if !IsTimeout(err) {
+			if err == nil && i > 0 {
+				sq.stale = data
+				sq.staleCopies = i
+			}
+			return data, err
+		}
+		lastErr = err
+	}
+
+	sq.stats.Timeouts++
+	return nil, lastErr
+}
+
+func (sq *ServerQuerier) sendAndRecv(request []byte, wait time.Duration) ([]byte, error) {
+	sq.socket.SetTimeout(wait)
+	start := time.Now()
+	if err := sq.socket.Send(request); err != nil {
+		return nil, err
+	}
+
+	data, err := sq.socket.Recv()
+	for err == nil && sq.staleCopies > 0 && bytes.Equal(data, sq.stale) {
+		sq.staleCopies--
+		data, err = sq.socket.Recv()
+	}
+	if err != nil {
+		return nil, err
+	}
+	sq.stats.Replies++
+	sq.stats.Rtt += time.Since(start)
+	return data, nil
+}
@@ -0,0 +1,127 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve_test
+
+import (
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+)
+
+func newRetryingQuerier(t *testing.T, server *a2stest.Server, timeout time.Duration) *valve.ServerQuerier {
+	sq, err := valve.NewServerQuerier(server.Addr(), timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sq.Close)
+	sq.SetRetryPolicy(valve.RetryPolicy{Retries: 3, InitialTimeout: 50 * time.Millisecond})
+	return sq
+}
+
+func TestRetransmitLostRequests(t *testing.T) {
+	server := a2stest.NewUnstartedServer(a2stest.SourceInfo())
+	server.DropRequests = 2
+	server.Start()
+	defer server.Close()
+
+	sq := newRetryingQuerier(t, server, 2*time.Second)
+	start := time.Now()
+	if _, err := sq.QueryInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("query took %v", elapsed)
+	}
+
+	stats := sq.Stats()
+	if stats.Retransmits != 2 || stats.Replies != 1 || stats.Timeouts != 0 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestRetransmitSlowReplies(t *testing.T) {
+	// Replies arrive after the first retransmission, so every exchange gets
+	// a late duplicate that must not be mistaken for the next reply.
+	server := a2stest.NewUnstartedServer(a2stest.SourceInfo())
+	server.ReplyDelay = 80 * time.Millisecond
+	server.InfoChallenge = true
+	server.MaxPacketSize = 200
+	server.SetRules(map[string]string{"sv_tags": "slow", "mp_timelimit": "30"})
+	server.SetPlayers([]*valve.Player{{Name: "alice"}})
+	server.Start()
+	defer server.Close()
+
+	sq := newRetryingQuerier(t, server, 2*time.Second)
+	if _, err := sq.QueryInfo(); err != nil {
+		t.Fatal(err)
+	}
+	players, err := sq.QueryPlayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 1 || players[0].Name != "alice" {
+		t.Fatalf("got %+v", players)
+	}
+	rules, err := sq.QueryRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules["sv_tags"] != "slow" {
+		t.Fatalf("got %v", rules)
+	}
+	if sq.Stats().Retransmits == 0 {
+		t.Fatal("expected retransmissions")
+	}
+}
+
+func TestRetransmitGivesUp(t *testing.T) {
+	server := a2stest.NewUnstartedServer(a2stest.SourceInfo())
+	server.DropRequests = 100
+	server.Start()
+	defer server.Close()
+
+	// Attempts wait 50ms, 100ms, 200ms, then whatever is left of the timeout.
+	sq := newRetryingQuerier(t, server, 500*time.Millisecond)
+	start := time.Now()
+	_, err := sq.QueryInfo()
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Fatalf("gave up after %v, want about 500ms", elapsed)
+	}
+	if got := server.Queries(); got != 4 {
+		t.Fatalf("server saw %d requests, want 4", got)
+	}
+	if stats := sq.Stats(); stats.Timeouts != 1 || stats.Retransmits != 3 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestRetransmitSplitsTimeout(t *testing.T) {
+	server := a2stest.NewUnstartedServer(a2stest.SourceInfo())
+	server.DropRequests = 2
+	server.Start()
+	defer server.Close()
+
+	// Without an initial timeout, attempts wait 100ms, 200ms and 400ms, so
+	// the last one is sent well before the 700ms timeout runs out.
+	sq, err := valve.NewServerQuerier(server.Addr(), 700*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sq.Close()
+	sq.SetRetryPolicy(valve.RetryPolicy{Retries: 2})
+
+	start := time.Now()
+	if _, err := sq.QueryInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 600*time.Millisecond {
+		t.Fatalf("query took %v, want about 300ms", elapsed)
+	}
+	if got := server.Queries(); got != 3 {
+		t.Fatalf("server saw %d requests, want 3", got)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"compress/bzip2"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash/crc32"
+	"io"
 	"time"
 )
 
@@ -23,12 +25,24 @@ var ErrBadRulesReply = errors.New("bad rules reply")
 var ErrBadPlayersReply = errors.New("bad players reply")
 var ErrWrongBz2Size = errors.New("bad bz2 decompression size")
 var ErrWrongBz2Checksum = errors.New("bad bz2 checksum")
+var ErrMismatchedPacketId = errors.New("packet belongs to a different reply")
+
+// Limits on compressed replies.
+const kMaxDecompressedSize = 1024 * 1024
+const kMaxCompressionRatio = 64
 
 // A ServerQuerier is used to issue A2S queries against an HL1/HL2 server.
 type ServerQuerier struct {
-	socket  *UdpSocket
+	socket  PacketConn
 	timeout time.Duration
 	info    *ServerInfo
+	retry   RetryPolicy
+	stats   QueryStats
+
+	// Late copies of the last reply that may still arrive after a
+	// retransmission.
+	stale       []byte
+	staleCopies int
 }
 
 type Player struct {
@@ -54,10 +68,21 @@ func NewServerQuerier(hostAndPort string, timeout time.Duration) (*ServerQuerier
 	if err != nil {
 		return nil, err
 	}
+	return NewServerQuerierConn(socket, timeout), nil
+}
+
+// Create a server querying object on top of an existing connection.
+func NewServerQuerierConn(socket PacketConn, timeout time.Duration) *ServerQuerier {
 	return &ServerQuerier{
 		socket:  socket,
 		timeout: timeout,
-	}, nil
+	}
+}
+
+// Use info from an earlier A2S_INFO query, such as a cached one, instead of
+// calling QueryInfo. Split replies can't be decoded without it.
+func (sq *ServerQuerier) SetInfo(info *ServerInfo) {
+	sq.info = info
 }
 
 // Close the socket used to query.
@@ -121,28 +146,17 @@ func (sq *ServerQuerier) a2s_info(info *ServerInfo) error {
 	var packet PacketBuilder
 	packet.WriteBytes([]byte{0xff, 0xff, 0xff, 0xff, A2S_INFO})
 	packet.WriteCString("Source Engine Query")
-	if err := sq.socket.Send(packet.Bytes()); err != nil {
-		return err
-	}
-
-	data, err := sq.socket.Recv()
+	data, err := sq.exchange(packet.Bytes())
 	if err != nil {
 		return err
 	}
 
-	switch data[4] {
-	case S2C_CHALLENGE:
+	if len(data) >= 9 && data[4] == S2C_CHALLENGE {
 		// The newer protocol requires A2S_INFO requests to contain a challenge,
 		// servers that expected a challenge will have sent us a S2C_CHALLENGE response instead.
 		// Re-send the query with the challenge we received.
-		packet.WriteBytes([]byte{
-			data[5], data[6], data[7], data[8],
-		})
-		if err := sq.socket.Send(packet.Bytes()); err != nil {
-			return err
-		}
-
-		data, err = sq.socket.Recv()
+		packet.WriteBytes(data[5:9])
+		data, err = sq.exchange(packet.Bytes())
 		if err != nil {
 			return err
 		}
@@ -153,11 +167,15 @@ func (sq *ServerQuerier) a2s_info(info *ServerInfo) error {
 
 func (sq *ServerQuerier) parse_a2s_info_reply(info *ServerInfo, data []byte) error {
 	reader := NewPacketReader(data)
-	if reader.ReadInt32() != -1 {
+	if reader.Field("header").ReadInt32() != -1 {
 		return ErrBadPacketHeader
 	}
 
-	info.InfoVersion = reader.ReadUint8()
+	info.InfoVersion = reader.Field("type").ReadUint8()
+	if err := reader.Err(); err != nil {
+		return err
+	}
+
 	switch info.InfoVersion {
 	case S2A_PLAYER:
 		// Non-steam servers seem to reply with a corrupted packet. If we send
@@ -165,30 +183,29 @@ func (sq *ServerQuerier) parse_a2s_info_reply(info *ServerInfo, data []byte) err
 		// error up.
 		return ErrMistakenReply
 	case S2A_INFO_SOURCE:
-		sq.parseNewInfo(reader, info)
+		return sq.parseNewInfo(reader, info)
 	case S2A_INFO_GOLDSRC:
-		sq.parseOldInfo(reader, info)
+		return sq.parseOldInfo(reader, info)
 	default:
 		return ErrUnknownInfoVersion
 	}
-	return nil
 }
 
-func (sq *ServerQuerier) parseNewInfo(reader *PacketReader, info *ServerInfo) {
-	info.Protocol = reader.ReadUint8()
-	info.Name = reader.ReadString()
-	info.MapName = reader.ReadString()
-	info.Folder = reader.ReadString()
-	info.Game = reader.ReadString()
+func (sq *ServerQuerier) parseNewInfo(reader *PacketReader, info *ServerInfo) error {
+	info.Protocol = reader.Field("protocol").ReadUint8()
+	info.Name = reader.Field("name").ReadString()
+	info.MapName = reader.Field("map").ReadString()
+	info.Folder = reader.Field("folder").ReadString()
+	info.Game = reader.Field("game").ReadString()
 
 	// This gets extended later, potentially.
-	appId := AppId(reader.ReadUint16())
+	appId := AppId(reader.Field("appid").ReadUint16())
 
-	info.Players = reader.ReadUint8()
-	info.MaxPlayers = reader.ReadUint8()
-	info.Bots = reader.ReadUint8()
+	info.Players = reader.Field("players").ReadUint8()
+	info.MaxPlayers = reader.Field("max_players").ReadUint8()
+	info.Bots = reader.Field("bots").ReadUint8()
 
-	serverType := reader.ReadUint8()
+	serverType := reader.Field("server_type").ReadUint8()
 	switch serverType {
 	case uint8('l'):
 		info.Type = ServerType_Listen
@@ -198,7 +215,7 @@ func (sq *ServerQuerier) parseNewInfo(reader *PacketReader, info *ServerInfo) {
 		info.Type = ServerType_Unknown
 	}
 
-	serverOS := reader.ReadUint8()
+	serverOS := reader.Field("environment").ReadUint8()
 	switch serverOS {
 	case uint8('l'):
 		info.OS = ServerOS_Linux
@@ -210,15 +227,15 @@ func (sq *ServerQuerier) parseNewInfo(reader *PacketReader, info *ServerInfo) {
 		info.OS = ServerOS_Unknown
 	}
 
-	info.Visibility = reader.ReadUint8()
-	info.Vac = reader.ReadUint8()
+	info.Visibility = reader.Field("visibility").ReadUint8()
+	info.Vac = reader.Field("vac").ReadUint8()
 
 	// Read TheShip information.
 	if AppId(appId) == App_TheShip {
 		info.TheShip = &TheShipInfo{}
-		info.TheShip.Mode = reader.ReadUint8()
-		info.TheShip.Witnesses = reader.ReadUint8()
-		info.TheShip.Duration = reader.ReadUint8()
+		info.TheShip.Mode = reader.Field("ship.mode").ReadUint8()
+		info.TheShip.Witnesses = reader.Field("ship.witnesses").ReadUint8()
+		info.TheShip.Duration = reader.Field("ship.duration").ReadUint8()
 	}
 
 	info.Ext = &ExtendedInfo{
@@ -226,28 +243,28 @@ func (sq *ServerQuerier) parseNewInfo(reader *PacketReader, info *ServerInfo) {
 	}
 
 	// Start reading extended information.
-	info.Ext.GameVersion = reader.ReadString()
+	info.Ext.GameVersion = reader.Field("version").ReadString()
 	if !reader.More() {
-		return
+		return reader.Err()
 	}
 
-	edf := reader.ReadUint8()
+	edf := reader.Field("edf").ReadUint8()
 	if (edf & 0x80) != 0 {
-		info.Ext.Port = reader.ReadUint16()
+		info.Ext.Port = reader.Field("edf.port").ReadUint16()
 	}
 	if (edf & 0x10) != 0 {
-		info.Ext.SteamId = reader.ReadUint64()
+		info.Ext.SteamId = reader.Field("edf.steamid").ReadUint64()
 	}
 	if (edf & 0x40) != 0 {
 		info.SpecTv = &SpecTvInfo{}
-		info.SpecTv.Port = reader.ReadUint16()
-		info.SpecTv.Name = reader.ReadString()
+		info.SpecTv.Port = reader.Field("edf.spectv_port").ReadUint16()
+		info.SpecTv.Name = reader.Field("edf.spectv_name").ReadString()
 	}
 	if (edf & 0x20) != 0 {
-		info.Ext.GameModeDescription = reader.ReadString()
+		info.Ext.GameModeDescription = reader.Field("edf.keywords").ReadString()
 	}
 	if (edf & 0x01) != 0 {
-		gameId := reader.ReadUint64()
+		gameId := reader.Field("edf.gameid").ReadUint64()
 
 		// bits 0-23: true app id (original could be truncated)
 		// bits 24-31: type
@@ -255,19 +272,20 @@ func (sq *ServerQuerier) parseNewInfo(reader *PacketReader, info *ServerInfo) {
 		info.Ext.AppId = AppId(gameId & uint64(0xffffffff))
 		info.Ext.GameId = gameId
 	}
+	return reader.Err()
 }
 
-func (sq *ServerQuerier) parseOldInfo(reader *PacketReader, info *ServerInfo) {
-	info.Address = reader.ReadString()
-	info.Name = reader.ReadString()
-	info.MapName = reader.ReadString()
-	info.Folder = reader.ReadString()
-	info.Game = reader.ReadString()
-	info.Players = reader.ReadUint8()
-	info.MaxPlayers = reader.ReadUint8()
-	info.Protocol = reader.ReadUint8()
-
-	serverType := reader.ReadUint8()
+func (sq *ServerQuerier) parseOldInfo(reader *PacketReader, info *ServerInfo) error {
+	info.Address = reader.Field("address").ReadString()
+	info.Name = reader.Field("name").ReadString()
+	info.MapName = reader.Field("map").ReadString()
+	info.Folder = reader.Field("folder").ReadString()
+	info.Game = reader.Field("game").ReadString()
+	info.Players = reader.Field("players").ReadUint8()
+	info.MaxPlayers = reader.Field("max_players").ReadUint8()
+	info.Protocol = reader.Field("protocol").ReadUint8()
+
+	serverType := reader.Field("server_type").ReadUint8()
 	switch serverType {
 	case uint8('l'):
 		info.Type = ServerType_Listen
@@ -277,7 +295,7 @@ func (sq *ServerQuerier) parseOldInfo(reader *PacketReader, info *ServerInfo) {
 		info.Type = ServerType_Unknown
 	}
 
-	serverOS := reader.ReadUint8()
+	serverOS := reader.Field("environment").ReadUint8()
 	switch serverOS {
 	case uint8('l'):
 		info.OS = ServerOS_Linux
@@ -287,22 +305,23 @@ func (sq *ServerQuerier) parseOldInfo(reader *PacketReader, info *ServerInfo) {
 		info.OS = ServerOS_Unknown
 	}
 
-	info.Visibility = reader.ReadUint8()
+	info.Visibility = reader.Field("visibility").ReadUint8()
 
-	isMod := reader.ReadUint8()
+	isMod := reader.Field("mod").ReadUint8()
 	if isMod == 1 {
 		info.Mod = &ModInfo{}
-		info.Mod.Url = reader.ReadString()
-		info.Mod.DwlUrl = reader.ReadString()
-		reader.ReadUint8() // Ignore a null byte.
-		info.Mod.Version = reader.ReadUint32()
-		info.Mod.Size = reader.ReadUint32()
-		info.Mod.Type = reader.ReadUint8()
-		info.Mod.Dll = reader.ReadUint8()
-	}
-
-	info.Vac = reader.ReadUint8()
-	info.Bots = reader.ReadUint8()
+		info.Mod.Url = reader.Field("mod.link").ReadString()
+		info.Mod.DwlUrl = reader.Field("mod.download_link").ReadString()
+		reader.Field("mod.null").ReadUint8() // Ignore a null byte.
+		info.Mod.Version = reader.Field("mod.version").ReadUint32()
+		info.Mod.Size = reader.Field("mod.size").ReadUint32()
+		info.Mod.Type = reader.Field("mod.type").ReadUint8()
+		info.Mod.Dll = reader.Field("mod.dll").ReadUint8()
+	}
+
+	info.Vac = reader.Field("vac").ReadUint8()
+	info.Bots = reader.Field("bots").ReadUint8()
+	return reader.Err()
 }
 
 // Send an A2S_RULES query to the server. This returns a mapping of cvar names
@@ -334,6 +353,10 @@ func (sq *ServerQuerier) queryRules() (map[string]string, error) {
 		return nil, err
 	}
 
+	if len(data) < 4 {
+		return nil, ErrBadPacketHeader
+	}
+
 	switch int32(binary.LittleEndian.Uint32(data)) {
 	case -1:
 		return sq.processRules(data, false)
@@ -354,13 +377,13 @@ func (sq *ServerQuerier) a2s_rules() ([]byte, error) {
 		A2S_RULES,
 		0xff, 0xff, 0xff, 0xff,
 	}
-	if err := sq.socket.Send(data); err != nil {
+	data, err := sq.exchange(data)
+	if err != nil {
 		return nil, err
 	}
 
-	data, err := sq.socket.Recv()
-	if err != nil {
-		return nil, err
+	if len(data) < 5 {
+		return nil, ErrBadPacketHeader
 	}
 
 	switch int32(binary.LittleEndian.Uint32(data[0:4])) {
@@ -372,7 +395,7 @@ func (sq *ServerQuerier) a2s_rules() ([]byte, error) {
 	case -1:
 		// Ok, continue.
 	default:
-		panic(ErrBadPacketHeader)
+		return nil, ErrBadPacketHeader
 	}
 
 	switch data[4] {
@@ -387,7 +410,11 @@ func (sq *ServerQuerier) a2s_rules() ([]byte, error) {
 	case S2C_CHALLENGE:
 		// Ok, continue.
 	default:
-		panic(ErrBadChallengeResponse)
+		return nil, ErrBadChallengeResponse
+	}
+
+	if len(data) < 9 {
+		return nil, ErrBadChallengeResponse
 	}
 
 	// Send the rules query now that we've got a challenge sequence.
@@ -396,10 +423,7 @@ func (sq *ServerQuerier) a2s_rules() ([]byte, error) {
 		A2S_RULES,
 		data[5], data[6], data[7], data[8],
 	}
-	if err := sq.socket.Send(reply); err != nil {
-		return nil, err
-	}
-	return sq.socket.Recv()
+	return sq.exchange(reply)
 }
 
 // Send an A2S_PLAYER query to the server. This returns a mapping of cvar names
@@ -431,6 +455,10 @@ func (sq *ServerQuerier) queryPlayers() ([]*Player, error) {
 		return nil, err
 	}
 
+	if len(data) < 4 {
+		return nil, ErrBadPacketHeader
+	}
+
 	switch int32(binary.LittleEndian.Uint32(data)) {
 	case -1:
 		return sq.processPlayers(data, false)
@@ -451,13 +479,13 @@ func (sq *ServerQuerier) a2s_players() ([]byte, error) {
 		0x55,
 		0xff, 0xff, 0xff, 0xff,
 	}
-	if err := sq.socket.Send(data); err != nil {
+	data, err := sq.exchange(data)
+	if err != nil {
 		return nil, err
 	}
 
-	data, err := sq.socket.Recv()
-	if err != nil {
-		return nil, err
+	if len(data) < 5 {
+		return nil, ErrBadPacketHeader
 	}
 
 	switch int32(binary.LittleEndian.Uint32(data[0:4])) {
@@ -469,7 +497,7 @@ func (sq *ServerQuerier) a2s_players() ([]byte, error) {
 	case -1:
 		// Ok, continue.
 	default:
-		panic(ErrBadPacketHeader)
+		return nil, ErrBadPacketHeader
 	}
 
 	switch data[4] {
@@ -484,7 +512,11 @@ func (sq *ServerQuerier) a2s_players() ([]byte, error) {
 	case 0x41:
 		// Ok, continue.
 	default:
-		panic(ErrBadChallengeResponse)
+		return nil, ErrBadChallengeResponse
+	}
+
+	if len(data) < 9 {
+		return nil, ErrBadChallengeResponse
 	}
 
 	// Send the rules query now that we've got a challenge sequence.
@@ -493,10 +525,7 @@ func (sq *ServerQuerier) a2s_players() ([]byte, error) {
 		0x55,
 		data[5], data[6], data[7], data[8],
 	}
-	if err := sq.socket.Send(reply); err != nil {
-		return nil, err
-	}
-	return sq.socket.Recv()
+	return sq.exchange(reply)
 }
 
 type MultiPacketHeader struct {
@@ -521,119 +550,175 @@ type MultiPacketHeader struct {
 	Payload []byte
 }
 
-func (sq *ServerQuerier) decodeMultiPacketHeader(data []byte) *MultiPacketHeader {
+func (sq *ServerQuerier) decodeMultiPacketHeader(data []byte) (*MultiPacketHeader, error) {
 	reader := NewPacketReader(data)
-	if reader.ReadInt32() != -2 {
-		panic(ErrBadPacketHeader)
+	if reader.Field("header").ReadInt32() != -2 {
+		if err := reader.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrBadPacketHeader
 	}
 	if sq.info == nil {
-		panic(ErrUnknownGameEngine)
+		return nil, ErrUnknownGameEngine
 	}
 
 	header := &MultiPacketHeader{}
-	header.Id = reader.ReadUint32()
+	header.Id = reader.Field("id").ReadUint32()
 
 	switch sq.info.GameEngine() {
 	case GOLDSRC:
-		pkt := reader.ReadUint8()
+		pkt := reader.Field("packet_number").ReadUint8()
 		header.PacketNumber = (pkt >> 4) & 0xf
 		header.TotalPackets = (pkt & 0xf)
 
 	case SOURCE:
 		header.Compressed = (header.Id & uint32(0x80000000)) != 0
-		header.TotalPackets = reader.ReadUint8()
-		header.PacketNumber = reader.ReadUint8()
+		header.TotalPackets = reader.Field("total_packets").ReadUint8()
+		header.PacketNumber = reader.Field("packet_number").ReadUint8()
 		if !sq.info.IsPreOrangeBox() {
-			header.PacketSize = reader.ReadUint16()
+			header.PacketSize = reader.Field("packet_size").ReadUint16()
 		}
 
 	default:
-		panic(ErrUnknownGameEngine)
+		return nil, ErrUnknownGameEngine
+	}
+
+	if err := reader.Err(); err != nil {
+		return nil, err
 	}
 
 	header.Size = reader.Pos()
 	header.Payload = data[header.Size:]
-	return header
+	return header, nil
 }
 
 func (sq *ServerQuerier) waitForMultiPacketReply(data []byte) ([]byte, bool, error) {
-	header := sq.decodeMultiPacketHeader(data)
-	packets := make([]*MultiPacketHeader, header.TotalPackets)
-	received := 0
-	fullSize := 0
-
+	var reply multiPacketReply
 	for {
-		if int(header.PacketNumber) >= len(packets) {
-			panic(ErrBadPacketNumber)
-		}
-		if packets[header.PacketNumber] != nil {
-			panic(ErrDuplicatePacket)
+		header, err := sq.decodeMultiPacketHeader(data)
+		if err != nil {
+			return nil, false, err
 		}
 
-		packets[header.PacketNumber] = header
-		fullSize += len(header.Payload)
-		received++
-
-		if received == len(packets) {
+		done, err := reply.add(header)
+		if err != nil {
+			return nil, false, err
+		}
+		if done {
 			break
 		}
 
-		data, err := sq.socket.Recv()
+		data, err = sq.socket.Recv()
 		if err != nil {
 			return nil, false, err
 		}
+	}
+
+	payload, compressed := reply.payload()
+	return payload, compressed, nil
+}
 
-		header = sq.decodeMultiPacketHeader(data)
+// A multiPacketReply collects the fragments of a split reply.
+type multiPacketReply struct {
+	id       uint32
+	packets  []*MultiPacketHeader
+	received int
+	size     int
+}
+
+// Add a fragment. This returns true once every fragment has arrived.
+func (mp *multiPacketReply) add(header *MultiPacketHeader) (bool, error) {
+	if mp.packets == nil {
+		mp.id = header.Id
+		mp.packets = make([]*MultiPacketHeader, header.TotalPackets)
+	} else if header.Id != mp.id {
+		return false, ErrMismatchedPacketId
 	}
 
-	payload := make([]byte, fullSize)
-	cursor := 0
-	for _, header := range packets {
-		copy(payload[cursor:cursor+len(header.Payload)], header.Payload)
-		cursor += len(header.Payload)
+	if int(header.PacketNumber) >= len(mp.packets) {
+		return false, ErrBadPacketNumber
+	}
+	if prev := mp.packets[header.PacketNumber]; prev != nil {
+		// A retransmitted request can get the whole reply twice. Identical
+		// copies are harmless; anything else means the reply is corrupt.
+		if bytes.Equal(prev.Payload, header.Payload) {
+			return false, nil
+		}
+		return false, ErrDuplicatePacket
 	}
 
-	return payload, packets[0].Compressed, nil
+	mp.packets[header.PacketNumber] = header
+	mp.size += len(header.Payload)
+	mp.received++
+	return mp.received == len(mp.packets), nil
 }
 
-func (sq *ServerQuerier) processRules(data []byte, compressed bool) (map[string]string, error) {
+// Join the payloads of all fragments, and report whether they are compressed.
+func (mp *multiPacketReply) payload() ([]byte, bool) {
+	payload := make([]byte, 0, mp.size)
+	for _, header := range mp.packets {
+		payload = append(payload, header.Payload...)
+	}
+	return payload, mp.packets[0].Compressed
+}
+
+// Decompress a bzip2-compressed multi-packet payload.
+func decompressPayload(data []byte) ([]byte, error) {
 	reader := NewPacketReader(data)
+	decompressedSize := reader.Field("decompressed_size").ReadUint32()
+	checksum := reader.Field("checksum").ReadUint32()
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
 
-	if compressed {
-		decompressedSize := reader.ReadUint32()
-		checksum := reader.ReadUint32()
+	// Sanity check so we don't allocate and zero 3GB of memory by accident.
+	// bzip2 can shrink runs of bytes enormously, so also bound the size by the
+	// compressed length: a tiny reply claiming a huge size is not believable.
+	compressed := reader.Remaining()
+	if decompressedSize > kMaxDecompressedSize ||
+		uint64(decompressedSize) > uint64(len(compressed))*kMaxCompressionRatio {
+		return nil, ErrWrongBz2Size
+	}
 
-		// Sanity check so we don't allocate and zero 3GB of memory by accident.
-		if decompressedSize > uint32(1024*1024) {
-			return nil, ErrWrongBz2Size
-		}
+	// Only allocate as much as actually decompresses.
+	bz2Reader := bzip2.NewReader(bytes.NewReader(compressed))
+	decompressed, err := io.ReadAll(io.LimitReader(bz2Reader, int64(decompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) != int(decompressedSize) {
+		return nil, ErrWrongBz2Size
+	}
+	if crc32.ChecksumIEEE(decompressed) != checksum {
+		return nil, ErrWrongBz2Checksum
+	}
+	return decompressed, nil
+}
 
-		decompressed := make([]byte, decompressedSize)
-		bz2Reader := bzip2.NewReader(bytes.NewReader(data[reader.Pos():]))
-		n, err := bz2Reader.Read(decompressed)
+func (sq *ServerQuerier) processRules(data []byte, compressed bool) (map[string]string, error) {
+	if compressed {
+		decompressed, err := decompressPayload(data)
 		if err != nil {
 			return nil, err
 		}
-		if n != int(decompressedSize) {
-			return nil, ErrWrongBz2Size
-		}
-		if crc32.ChecksumIEEE(decompressed) != checksum {
-			return nil, ErrWrongBz2Checksum
-		}
 
 		// Switch to the decompressed stream.
 		data = decompressed
-		reader = NewPacketReader(data)
 	}
 
-	if reader.ReadInt32() != -1 {
-		panic(ErrBadPacketHeader)
+	reader := NewPacketReader(data)
+	header := reader.Field("header").ReadInt32()
+	kind := reader.Field("type").ReadUint8()
+	count := int(reader.Field("count").ReadUint16())
+	if err := reader.Err(); err != nil {
+		return nil, err
 	}
-	if reader.ReadUint8() != 0x45 {
-		panic(ErrBadRulesReply)
+	if header != -1 {
+		return nil, ErrBadPacketHeader
+	}
+	if kind != S2A_RULES {
+		return nil, ErrBadRulesReply
 	}
-
-	count := int(reader.ReadUint16())
 
 	rules := map[string]string{}
 	for i := 0; i < count; i++ {
@@ -652,54 +737,46 @@ func (sq *ServerQuerier) processRules(data []byte, compressed bool) (map[string]
 }
 
 func (sq *ServerQuerier) processPlayers(data []byte, compressed bool) ([]*Player, error) {
-	reader := NewPacketReader(data)
-
 	if compressed {
-		decompressedSize := reader.ReadUint32()
-		checksum := reader.ReadUint32()
-
-		// Sanity check so we don't allocate and zero 3GB of memory by accident.
-		if decompressedSize > uint32(1024*1024) {
-			return nil, ErrWrongBz2Size
-		}
-
-		decompressed := make([]byte, decompressedSize)
-		bz2Reader := bzip2.NewReader(bytes.NewReader(data[reader.Pos():]))
-		n, err := bz2Reader.Read(decompressed)
+		decompressed, err := decompressPayload(data)
 		if err != nil {
 			return nil, err
 		}
-		if n != int(decompressedSize) {
-			return nil, ErrWrongBz2Size
-		}
-		if crc32.ChecksumIEEE(decompressed) != checksum {
-			return nil, ErrWrongBz2Checksum
-		}
 
 		// Switch to the decompressed stream.
 		data = decompressed
-		reader = NewPacketReader(data)
 	}
 
-	if reader.ReadInt32() != -1 {
-		panic(ErrBadPacketHeader)
+	reader := NewPacketReader(data)
+	header := reader.Field("header").ReadInt32()
+	kind := reader.Field("type").ReadUint8()
+	count := reader.Field("count").ReadUint8()
+	if err := reader.Err(); err != nil {
+		return nil, err
 	}
-	if reader.ReadUint8() != 0x44 {
-		panic(ErrBadPlayersReply)
+	if header != -1 {
+		return nil, ErrBadPacketHeader
+	}
+	if kind != S2A_PLAYER {
+		return nil, ErrBadPlayersReply
 	}
-
-	var count = reader.ReadUint8()
-	var player *Player
 
 	players := []*Player{}
 
 	for i := 0; i < int(count); i++ {
-		player = &Player{}
-
-		_ = reader.ReadUint8()
-		player.Name = reader.ReadString()
-		player.Score = reader.ReadUint32()
-		player.Duration = reader.ReadFloat32()
+		player := &Player{}
+
+		reader.Field("index").ReadUint8()
+		player.Name = reader.Field("name").ReadString()
+		player.Score = reader.Field("score").ReadUint32()
+		player.Duration = reader.Field("duration").ReadFloat32()
+		if err := reader.Err(); err != nil {
+			// Only pay for formatting the field name on failure.
+			if pe, ok := err.(*PacketError); ok {
+				pe.Field = fmt.Sprintf("player[%d].%s", i, pe.Field)
+			}
+			return nil, err
+		}
 
 		players = append(players, player)
 	}
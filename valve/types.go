@@ -3,6 +3,7 @@ package valve
 
 import (
 	"net"
+	"strings"
 )
 
 // ServerList is a list of IP addresses and ports.
@@ -13,7 +14,8 @@ type ServerList []*net.TCPAddr
 type MasterQueryCallback func(batch ServerList) error
 
 // MasterQuerier defines the common interface for querying server lists.
-// Currently implemented by Steam Web API.
+// Implemented by SteamWebAPIQuerier, UdpMasterQuerier, LocalMasterQuerier,
+// StaticMasterQuerier, SrvMasterQuerier and MultiMasterQuerier.
 type MasterQuerier interface {
 	FilterAppId(appId AppId)
 	FilterAppIds(appIds []AppId)
@@ -23,6 +25,30 @@ type MasterQuerier interface {
 	Close()
 }
 
+// A ServerListing is a listed server, with the player counts the master
+// server last heard from it.
+type ServerListing struct {
+	Addr       *net.TCPAddr
+	Players    int
+	MaxPlayers int
+	Bots       int
+
+	// The port players connect to, if the master reports it. It can differ
+	// from Addr's, which is the query port.
+	GamePort int
+}
+
+// MasterListingCallback processes batches of listings received from the master
+// server.
+type MasterListingCallback func(batch []*ServerListing) error
+
+// ListingQuerier is a MasterQuerier that can also report what the master knows
+// about each server.
+type ListingQuerier interface {
+	MasterQuerier
+	QueryListings(callback MasterListingCallback) error
+}
+
 // Implements Batch.Len().
 func (sl ServerList) Len() int {
 	return len(sl)
@@ -41,6 +67,17 @@ const (
 	SOURCE  GameEngine = GameEngine(2)
 )
 
+// Parse an engine name, either "goldsrc" or "source".
+func ParseGameEngine(name string) (GameEngine, bool) {
+	switch strings.ToLower(name) {
+	case "goldsrc":
+		return GOLDSRC, true
+	case "source":
+		return SOURCE, true
+	}
+	return 0, false
+}
+
 // The server type (either dedicated or listen).
 type ServerType int
 
@@ -91,6 +128,7 @@ func (so ServerOS) String() string {
 
 // OOB request packet types.
 const A2S_INFO uint8 = 0x54
+const A2S_PLAYER uint8 = 0x55
 const A2S_RULES uint8 = 0x56
 
 // Official versions of the A2S_INFO reply.
@@ -102,6 +140,9 @@ const S2C_CHALLENGE uint8 = 0x41
 const S2A_PLAYER uint8 = 0x44
 const S2A_RULES uint8 = 0x45
 
+// Reply to a GoldSrc "rcon" command.
+const S2A_RCON uint8 = 0x6c
+
 // Optional mod information returned by S2A_INFO_GOLDSRC.
 type ModInfo struct {
 	Url     string `json:"url"`
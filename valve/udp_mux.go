@@ -0,0 +1,502 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var ErrMuxClosed = errors.New("udp multiplexer is closed")
+
+// Number of unread packets buffered per connection, and of fragments held
+// back per server. A2S exchanges are strictly request/reply, so this only
+// needs to hold a split reply in flight.
+const kMuxInboxSize = 32
+
+// A muxTimeoutError is returned by MuxConn.Recv when no reply arrives in time.
+// It mirrors the error a connected socket reports, so callers that look for
+// "timeout" keep working.
+type muxTimeoutError struct{}
+
+func (muxTimeoutError) Error() string   { return "i/o timeout" }
+func (muxTimeoutError) Timeout() bool   { return true }
+func (muxTimeoutError) Temporary() bool { return true }
+
+// A UdpMux sends A2S queries for any number of servers from a small, fixed
+// set of unconnected UDP sockets, and routes each reply to the querier that
+// is waiting for it.
+//
+// Replies are routed by source address and query type: S2A_INFO goes to the
+// querier with an A2S_INFO in flight to that server, S2A_PLAYER to the one
+// with an A2S_PLAYER, and so on, so one server can answer an info, a players
+// and a rules query at the same time. Challenges and split replies don't say
+// which query they answer:
+//
+//   - A server hands out one challenge per client address, whatever the
+//     query, so a challenge goes to the query that has waited longest.
+//   - The first fragment of a split reply holds the start of the reply, with
+//     its type. Fragments are routed by split id once the first one has
+//     arrived, and held back until then, unless only one query is waiting.
+//     Compressed replies hide their type, so they also go to the query that
+//     has waited longest.
+//
+// Only one query of each type per server can be in flight. A querier sending
+// a query of a type that is already in flight to the same server waits for
+// the other querier to move on to another query, or to close.
+type UdpMux struct {
+	sockets []*net.UDPConn
+	seed    maphash.Seed
+
+	mu     sync.Mutex
+	peers  map[netip.AddrPort]*muxPeer
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// The routing state for one server.
+type muxPeer struct {
+	socket *net.UDPConn
+	conns  map[*MuxConn]struct{}
+
+	// The querier with a query of each type (A2S_INFO, ...) in flight.
+	queries map[byte]*MuxConn
+
+	// Split replies by id, and fragments that arrived before their first one.
+	splits  map[uint32]*MuxConn
+	orphans map[uint32][][]byte
+
+	// Orders queries by when they were sent.
+	sends uint64
+
+	// Closed and replaced whenever a query type is released.
+	released chan struct{}
+}
+
+// Create a multiplexer with the given number of sockets.
+func NewUdpMux(sockets int) (*UdpMux, error) {
+	if sockets < 1 {
+		sockets = 1
+	}
+
+	mux := &UdpMux{
+		seed:  maphash.MakeSeed(),
+		peers: map[netip.AddrPort]*muxPeer{},
+	}
+	for i := 0; i < sockets; i++ {
+		cn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			mux.Close()
+			return nil, err
+		}
+		mux.sockets = append(mux.sockets, cn)
+	}
+
+	for _, cn := range mux.sockets {
+		mux.wg.Add(1)
+		go mux.readLoop(cn)
+	}
+	return mux, nil
+}
+
+// Close all sockets. Outstanding connections fail their next Recv.
+func (mux *UdpMux) Close() {
+	mux.mu.Lock()
+	if mux.closed {
+		mux.mu.Unlock()
+		return
+	}
+	mux.closed = true
+	peers := mux.peers
+	mux.peers = map[netip.AddrPort]*muxPeer{}
+	mux.mu.Unlock()
+
+	for _, cn := range mux.sockets {
+		cn.Close()
+	}
+	mux.wg.Wait()
+
+	for _, peer := range peers {
+		for conn := range peer.conns {
+			conn.release()
+		}
+	}
+}
+
+// Open a virtual connection to a server. Any number of connections to one
+// server can be open; their queries are only serialized by type, when sent.
+func (mux *UdpMux) Dial(hostAndPort string, timeout time.Duration) (*MuxConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", hostAndPort)
+	if err != nil {
+		return nil, err
+	}
+	addr := udpAddr.AddrPort()
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if mux.closed {
+		return nil, ErrMuxClosed
+	}
+
+	peer, ok := mux.peers[addr]
+	if !ok {
+		peer = &muxPeer{
+			socket:   mux.socketFor(addr),
+			conns:    map[*MuxConn]struct{}{},
+			queries:  map[byte]*MuxConn{},
+			splits:   map[uint32]*MuxConn{},
+			orphans:  map[uint32][][]byte{},
+			released: make(chan struct{}),
+		}
+		mux.peers[addr] = peer
+	}
+
+	conn := &MuxConn{
+		mux:     mux,
+		peer:    peer,
+		addr:    addr,
+		timeout: timeout,
+		inbox:   make(chan []byte, kMuxInboxSize),
+		done:    make(chan struct{}),
+	}
+	peer.conns[conn] = struct{}{}
+	return conn, nil
+}
+
+// Create a ServerQuerier whose traffic goes through the multiplexer.
+func (mux *UdpMux) NewServerQuerier(hostAndPort string, timeout time.Duration) (*ServerQuerier, error) {
+	conn, err := mux.Dial(hostAndPort, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewServerQuerierConn(conn, timeout), nil
+}
+
+// Pick a socket for a peer. A peer always uses the same socket, so servers
+// that only answer the port they were queried from still reach us.
+func (mux *UdpMux) socketFor(peer netip.AddrPort) *net.UDPConn {
+	var h maphash.Hash
+	h.SetSeed(mux.seed)
+	addr := peer.Addr().As16()
+	h.Write(addr[:])
+	h.WriteByte(byte(peer.Port()))
+	h.WriteByte(byte(peer.Port() >> 8))
+	return mux.sockets[h.Sum64()%uint64(len(mux.sockets))]
+}
+
+func (mux *UdpMux) readLoop(cn *net.UDPConn) {
+	defer mux.wg.Done()
+
+	var buffer [kMaxPacketSize]byte
+	for {
+		n, from, err := cn.ReadFromUDPAddrPort(buffer[:])
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Other errors, such as ICMP unreachable on some platforms, are
+			// not fatal to the socket.
+			continue
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+		packet := make([]byte, n)
+		copy(packet, buffer[:n])
+
+		mux.mu.Lock()
+		var conn *MuxConn
+		var packets [][]byte
+		if peer := mux.peers[from]; peer != nil {
+			conn, packets = peer.route(packet)
+		}
+		mux.mu.Unlock()
+		if conn == nil {
+			// Late or unsolicited reply, or a fragment held back.
+			continue
+		}
+
+		for _, packet := range packets {
+			select {
+			case conn.inbox <- packet:
+			default:
+				// The querier isn't keeping up; drop like the kernel would.
+			}
+		}
+	}
+}
+
+// Find the connection a packet from this peer is for. This returns the
+// packets to deliver, which include any fragments held back for it.
+func (peer *muxPeer) route(packet []byte) (*MuxConn, [][]byte) {
+	if len(packet) < 5 {
+		return nil, nil
+	}
+
+	switch int32(binary.LittleEndian.Uint32(packet)) {
+	case -1:
+		conn := peer.forReply(packet[4])
+		if conn != nil {
+			conn.waiting = false
+		}
+		return conn, [][]byte{packet}
+
+	case -2:
+		if len(packet) < 9 {
+			return nil, nil
+		}
+		id := binary.LittleEndian.Uint32(packet[4:])
+		if conn := peer.splits[id]; conn != nil {
+			return conn, [][]byte{packet}
+		}
+
+		conn := peer.forSplit(packet, id)
+		if conn == nil {
+			held := 0
+			for _, fragments := range peer.orphans {
+				held += len(fragments)
+			}
+			if held < kMuxInboxSize {
+				peer.orphans[id] = append(peer.orphans[id], packet)
+			}
+			return nil, nil
+		}
+		peer.splits[id] = conn
+		conn.waiting = false
+		packets := append(peer.orphans[id], packet)
+		delete(peer.orphans, id)
+		return conn, packets
+	}
+	return nil, nil
+}
+
+// Find the query a single-packet reply of the given type answers.
+func (peer *muxPeer) forReply(kind uint8) *MuxConn {
+	var conn *MuxConn
+	switch kind {
+	case S2A_INFO_SOURCE, S2A_INFO_GOLDSRC:
+		conn = peer.queries[A2S_INFO]
+	case S2A_PLAYER:
+		conn = peer.queries[A2S_PLAYER]
+		if conn == nil {
+			// Half-Life 1 servers can answer A2S_INFO with S2A_PLAYER.
+			conn = peer.queries[A2S_INFO]
+		}
+	case S2A_RULES:
+		conn = peer.queries[A2S_RULES]
+	case S2C_CHALLENGE:
+		return peer.longestWaiting()
+	}
+	if conn == nil && peer.waitingCount() == 1 {
+		// Some servers answer with the wrong type, which the querier knows
+		// how to handle. With several queries waiting, there's no telling
+		// whose it is; it may be a late copy of an earlier reply.
+		conn = peer.longestWaiting()
+	}
+	return conn
+}
+
+// Find the query the first fragment of a split reply answers. The start of
+// the reply is after the split header, which is 9 bytes for GoldSrc, 10 for
+// pre-Orange Box Source and 12 for Source.
+func (peer *muxPeer) forSplit(packet []byte, id uint32) *MuxConn {
+	for _, offset := range []int{9, 10, 12} {
+		if len(packet) > offset+4 && int32(binary.LittleEndian.Uint32(packet[offset:])) == -1 {
+			switch packet[offset+4] {
+			case S2A_INFO_SOURCE, S2A_INFO_GOLDSRC, S2A_PLAYER, S2A_RULES:
+				return peer.forReply(packet[offset+4])
+			}
+		}
+	}
+
+	// Only Source replies are compressed, flagged by the top bit of the id.
+	compressed := id&0x80000000 != 0
+	if waiting := peer.waitingCount(); compressed || waiting == 1 {
+		return peer.longestWaiting()
+	}
+	return nil
+}
+
+// The query sent longest ago that hasn't had a reply yet.
+func (peer *muxPeer) longestWaiting() *MuxConn {
+	var oldest *MuxConn
+	for _, conn := range peer.queries {
+		if conn.waiting && (oldest == nil || conn.sent < oldest.sent) {
+			oldest = conn
+		}
+	}
+	return oldest
+}
+
+func (peer *muxPeer) waitingCount() int {
+	n := 0
+	for _, conn := range peer.queries {
+		if conn.waiting {
+			n++
+		}
+	}
+	return n
+}
+
+// A MuxConn is a virtual connection to one server through a UdpMux. It
+// implements PacketConn.
+type MuxConn struct {
+	mux     *UdpMux
+	peer    *muxPeer
+	addr    netip.AddrPort
+	timeout time.Duration
+	inbox   chan []byte
+
+	// Guarded by mux.mu: the type of the query in flight (zero if none),
+	// when it was sent, and whether it still awaits a reply.
+	query   byte
+	sent    uint64
+	waiting bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (mc *MuxConn) Send(bytes []byte) error {
+	select {
+	case <-mc.done:
+		return ErrMuxClosed
+	default:
+	}
+
+	var query byte
+	if len(bytes) >= 5 {
+		query = bytes[4]
+	}
+	if err := mc.startQuery(query); err != nil {
+		return err
+	}
+
+	if err := waitForRateLimit(mc.RemoteAddr(), mc.timeout); err != nil {
+		return err
+	}
+
+	// The socket is shared, so no write deadline: UDP sends don't block for
+	// long anyway.
+	_, err := mc.peer.socket.WriteToUDPAddrPort(bytes, mc.addr)
+	return err
+}
+
+// Claim a query type for this connection, waiting while another connection
+// to the same server has a query of that type in flight. Resending the
+// current query keeps it.
+func (mc *MuxConn) startQuery(query byte) error {
+	var deadline <-chan time.Time
+	if mc.timeout > 0 {
+		timer := time.NewTimer(mc.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	mux := mc.mux
+	for {
+		mux.mu.Lock()
+		if mux.closed {
+			mux.mu.Unlock()
+			return ErrMuxClosed
+		}
+
+		peer := mc.peer
+		if mc.query != query {
+			// Let go of the previous query first, so two connections can't
+			// each hold what the other is waiting for.
+			mc.finishQuery()
+		}
+		if owner := peer.queries[query]; owner == nil || owner == mc {
+			peer.queries[query] = mc
+			peer.sends++
+			mc.query = query
+			mc.sent = peer.sends
+			mc.waiting = true
+			mux.mu.Unlock()
+			return nil
+		}
+		released := peer.released
+		mux.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-mc.done:
+			return ErrMuxClosed
+		case <-deadline:
+			return muxTimeoutError{}
+		}
+	}
+}
+
+// Release the query type this connection holds. Called with mux.mu held.
+func (mc *MuxConn) finishQuery() {
+	peer := mc.peer
+	if peer.queries[mc.query] != mc {
+		return
+	}
+	delete(peer.queries, mc.query)
+	for id, conn := range peer.splits {
+		if conn == mc {
+			delete(peer.splits, id)
+		}
+	}
+	if len(peer.queries) == 0 {
+		clear(peer.orphans)
+	}
+	mc.query = 0
+	mc.waiting = false
+
+	close(peer.released)
+	peer.released = make(chan struct{})
+}
+
+func (mc *MuxConn) Recv() ([]byte, error) {
+	var deadline <-chan time.Time
+	if mc.timeout > 0 {
+		timer := time.NewTimer(mc.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	select {
+	case packet := <-mc.inbox:
+		return packet, nil
+	case <-mc.done:
+		return nil, ErrMuxClosed
+	case <-deadline:
+		return nil, muxTimeoutError{}
+	}
+}
+
+func (mc *MuxConn) SetTimeout(timeout time.Duration) {
+	mc.timeout = timeout
+}
+
+func (mc *MuxConn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(mc.addr)
+}
+
+// Release the connection's query type so another querier can use it.
+func (mc *MuxConn) Close() {
+	mux := mc.mux
+	mux.mu.Lock()
+	mc.finishQuery()
+	delete(mc.peer.conns, mc)
+	if len(mc.peer.conns) == 0 && mux.peers[mc.addr] == mc.peer {
+		delete(mux.peers, mc.addr)
+	}
+	mux.mu.Unlock()
+
+	mc.release()
+}
+
+func (mc *MuxConn) release() {
+	mc.closeOnce.Do(func() {
+		close(mc.done)
+	})
+}
@@ -0,0 +1,209 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/a2stest"
+)
+
+func TestUdpMuxManyServers(t *testing.T) {
+	mux, err := valve.NewUdpMux(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	var servers []*a2stest.Server
+	for i := 0; i < 16; i++ {
+		server := a2stest.NewUnstartedServer(a2stest.SourceInfo())
+		server.InfoChallenge = i%2 == 0
+		server.MaxPacketSize = 200
+		server.SetRules(map[string]string{"sv_tags": "mux", "index": string(rune('a' + i))})
+		server.Start()
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+
+			sq, err := mux.NewServerQuerier(addr, 2*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer sq.Close()
+
+			if _, err := sq.QueryInfo(); err != nil {
+				t.Errorf("%s: %v", addr, err)
+				return
+			}
+			rules, err := sq.QueryRules()
+			if err != nil {
+				t.Errorf("%s: %v", addr, err)
+				return
+			}
+			if want := string(rune('a' + i)); rules["index"] != want {
+				t.Errorf("%s: got reply for %q, want %q", addr, rules["index"], want)
+			}
+		}(i, server.Addr())
+	}
+	wg.Wait()
+}
+
+func TestUdpMuxConcurrentQueryTypes(t *testing.T) {
+	mux, err := valve.NewUdpMux(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	// Players and rules come back split, and every query needs a challenge.
+	var players []*valve.Player
+	for i := 0; i < 20; i++ {
+		players = append(players, &valve.Player{Name: "player " + string(rune('a'+i))})
+	}
+	rules := map[string]string{}
+	for i := 0; i < 20; i++ {
+		rules["rule_"+string(rune('a'+i))] = "value"
+	}
+	server := a2stest.NewUnstartedServer(a2stest.SourceInfo())
+	server.InfoChallenge = true
+	server.MaxPacketSize = 200
+	server.SetPlayers(players)
+	server.SetRules(rules)
+	server.Start()
+	defer server.Close()
+
+	queries := []func(sq *valve.ServerQuerier) error{
+		func(sq *valve.ServerQuerier) error {
+			_, err := sq.QueryInfo()
+			return err
+		},
+		func(sq *valve.ServerQuerier) error {
+			sq.SetInfo(a2stest.SourceInfo())
+			got, err := sq.QueryPlayers()
+			if err == nil && len(got) != len(players) {
+				t.Errorf("got %d players, want %d", len(got), len(players))
+			}
+			return err
+		},
+		func(sq *valve.ServerQuerier) error {
+			sq.SetInfo(a2stest.SourceInfo())
+			got, err := sq.QueryRules()
+			if err == nil && len(got) != len(rules) {
+				t.Errorf("got %d rules, want %d", len(got), len(rules))
+			}
+			return err
+		},
+	}
+
+	// One querier per query type, all talking to the server at once.
+	var wg sync.WaitGroup
+	for _, query := range queries {
+		wg.Add(1)
+		go func(query func(sq *valve.ServerQuerier) error) {
+			defer wg.Done()
+
+			sq, err := mux.NewServerQuerier(server.Addr(), 2*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer sq.Close()
+			for i := 0; i < 20; i++ {
+				if err := query(sq); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(query)
+	}
+	wg.Wait()
+}
+
+func TestUdpMuxOneQueryPerType(t *testing.T) {
+	mux, err := valve.NewUdpMux(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	server := a2stest.NewServer(a2stest.SourceInfo())
+	defer server.Close()
+
+	info := append([]byte{0xff, 0xff, 0xff, 0xff, valve.A2S_INFO}, "Source Engine Query\x00"...)
+	players := []byte{0xff, 0xff, 0xff, 0xff, valve.A2S_PLAYER, 0xff, 0xff, 0xff, 0xff}
+
+	first, err := mux.Dial(server.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Send(info); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second connection can query players, but not info, while the first
+	// has an info query in flight.
+	second, err := mux.Dial(server.Addr(), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := second.Send(info); err == nil {
+		t.Fatal("second info query should wait for the first")
+	}
+	if err := second.Send(players); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := first.Recv(); err != nil || data[4] != valve.S2A_INFO_SOURCE {
+		t.Fatalf("first got %q, %v", data, err)
+	}
+	if data, err := second.Recv(); err != nil || data[4] != valve.S2C_CHALLENGE {
+		t.Fatalf("second got %q, %v", data, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		first.Close()
+	}()
+	second.SetTimeout(time.Second)
+	if err := second.Send(info); err != nil {
+		t.Fatalf("info query after Close: %v", err)
+	}
+	if data, err := second.Recv(); err != nil || data[4] != valve.S2A_INFO_SOURCE {
+		t.Fatalf("second got %q, %v", data, err)
+	}
+}
+
+func TestUdpMuxTimeout(t *testing.T) {
+	mux, err := valve.NewUdpMux(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	conn, err := mux.Dial("127.0.0.1:9", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Send([]byte("ping"))
+	if _, err := conn.Recv(); err == nil || err.Error() != "i/o timeout" {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+
+	mux.Close()
+	if _, err := conn.Recv(); err != valve.ErrMuxClosed {
+		t.Fatalf("got %v, want ErrMuxClosed", err)
+	}
+}
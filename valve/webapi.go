@@ -14,9 +14,9 @@ import (
 
 // SteamWebAPIQuerier queries server lists using Steam Web API
 type SteamWebAPIQuerier struct {
-	apiKey  string
-	client  *http.Client
-	filters []string
+	masterFilter
+	apiKey string
+	client *http.Client
 }
 
 // steamWebAPIResponse is the response structure from Steam Web API
@@ -58,36 +58,22 @@ func NewSteamWebAPIQuerier(apiKey string) (*SteamWebAPIQuerier, error) {
 	}, nil
 }
 
-// FilterAppId adds an AppID filter
-func (q *SteamWebAPIQuerier) FilterAppId(appId AppId) {
-	q.filters = append(q.filters, fmt.Sprintf("appid\\%d", appId))
-}
-
-// FilterAppIds adds multiple AppID filters
-func (q *SteamWebAPIQuerier) FilterAppIds(appIds []AppId) {
-	for _, appId := range appIds {
-		q.FilterAppId(appId)
-	}
-}
-
-// FilterName adds a server name filter
-func (q *SteamWebAPIQuerier) FilterName(serverName string) {
-	if serverName != "" && serverName != "*" {
-		q.filters = append(q.filters, fmt.Sprintf("name_match\\%s", serverName))
-	}
-}
-
-// FilterGameaddr adds an IP address filter
-func (q *SteamWebAPIQuerier) FilterGameaddr(serverIP string) {
-	if serverIP != "" {
-		q.filters = append(q.filters, fmt.Sprintf("gameaddr\\%s", serverIP))
-	}
-}
-
 // Query queries the server list
 func (q *SteamWebAPIQuerier) Query(callback MasterQueryCallback) error {
+	return q.QueryListings(func(listings []*ServerListing) error {
+		servers := make(ServerList, 0, len(listings))
+		for _, listing := range listings {
+			servers = append(servers, listing.Addr)
+		}
+		return callback(servers)
+	})
+}
+
+// QueryListings queries the server list, along with each server's player
+// counts
+func (q *SteamWebAPIQuerier) QueryListings(callback MasterListingCallback) error {
 	// Build filter string
-	filterStr := q.buildFilterString()
+	filterStr := q.filterString()
 
 	// Build API URL
 	apiURL := fmt.Sprintf(
@@ -134,8 +120,8 @@ func (q *SteamWebAPIQuerier) Query(callback MasterQueryCallback) error {
 		return fmt.Errorf("failed to decode Steam Web API response: invalid JSON format")
 	}
 
-	// Convert to ServerList format
-	servers := make(ServerList, 0, len(result.Response.Servers))
+	// Convert to listings
+	servers := make([]*ServerListing, 0, len(result.Response.Servers))
 	for _, srv := range result.Response.Servers {
 		// Parse server address
 		addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
@@ -152,7 +138,13 @@ func (q *SteamWebAPIQuerier) Query(callback MasterQueryCallback) error {
 				continue // Skip invalid addresses
 			}
 		}
-		servers = append(servers, addr)
+		servers = append(servers, &ServerListing{
+			Addr:       addr,
+			Players:    srv.Players,
+			MaxPlayers: srv.MaxPlayers,
+			Bots:       srv.Bots,
+			GamePort:   srv.Gameport,
+		})
 	}
 
 	// Call callback function
@@ -163,23 +155,6 @@ func (q *SteamWebAPIQuerier) Query(callback MasterQueryCallback) error {
 	return nil
 }
 
-// buildFilterString builds the filter string
-func (q *SteamWebAPIQuerier) buildFilterString() string {
-	if len(q.filters) == 0 {
-		return ""
-	}
-
-	// Combine all filters into a single string
-	// Format: \appid\730\name_match\test
-	var builder strings.Builder
-	for _, filter := range q.filters {
-		builder.WriteString("\\")
-		builder.WriteString(filter)
-	}
-
-	return builder.String()
-}
-
 // Close closes the connection (Web API doesn't need to close, kept for interface compatibility)
 func (q *SteamWebAPIQuerier) Close() {
 	// HTTP client manages connection pool automatically
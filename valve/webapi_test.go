@@ -0,0 +1,214 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve_test
+
+import (
+	"strings"
+	"testing"
+
+	valve "github.com/cyxc1124/Mastersteam/valve"
+	"github.com/cyxc1124/Mastersteam/valve/webapitest"
+)
+
+func newTestWebAPI(t *testing.T, entries ...webapitest.Entry) *webapitest.Server {
+	api := webapitest.NewServer(entries...)
+	t.Cleanup(api.Close)
+
+	oldURL := valve.SteamWebAPIURL
+	valve.SteamWebAPIURL = api.ServerListURL()
+	t.Cleanup(func() { valve.SteamWebAPIURL = oldURL })
+	return api
+}
+
+func queryAll(t *testing.T, configure func(q *valve.SteamWebAPIQuerier)) (valve.ServerList, error) {
+	q, err := valve.NewSteamWebAPIQuerier("test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if configure != nil {
+		configure(q)
+	}
+
+	var servers valve.ServerList
+	err = q.Query(func(batch valve.ServerList) error {
+		servers = append(servers, batch...)
+		return nil
+	})
+	return servers, err
+}
+
+func TestSteamWebAPIQuerierRequiresKey(t *testing.T) {
+	if _, err := valve.NewSteamWebAPIQuerier(""); err == nil {
+		t.Fatal("expected an error for an empty key")
+	}
+}
+
+func TestSteamWebAPIQuerierFilters(t *testing.T) {
+	api := newTestWebAPI(t,
+		webapitest.Entry{Addr: "10.0.0.1:27015", Name: "Uncletopia | Seattle", Appid: 440},
+		webapitest.Entry{Addr: "10.0.0.2:27015", Name: "Uncletopia | Chicago", Appid: 440},
+		webapitest.Entry{Addr: "10.0.0.3:27015", Name: "Some other server", Appid: 440},
+		webapitest.Entry{Addr: "10.0.0.4:27015", Name: "Uncletopia CS", Appid: 730},
+	)
+
+	servers, err := queryAll(t, func(q *valve.SteamWebAPIQuerier) {
+		q.FilterAppId(valve.App_TF2)
+		q.FilterName("uncletopia*")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("got %d servers, want 2", len(servers))
+	}
+
+	requests := api.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	want := []webapitest.Filter{{Key: "appid", Value: "440"}, {Key: "name_match", Value: "uncletopia*"}}
+	got := requests[0].Filters
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got filters %v, want %v", got, want)
+	}
+	if requests[0].Key != "test-key" {
+		t.Fatalf("got key %q, want test-key", requests[0].Key)
+	}
+}
+
+func TestSteamWebAPIQuerierWildcardName(t *testing.T) {
+	api := newTestWebAPI(t, webapitest.Entry{Addr: "10.0.0.1:27015", Name: "a", Appid: 440})
+
+	servers, err := queryAll(t, func(q *valve.SteamWebAPIQuerier) {
+		q.FilterAppId(valve.App_TF2)
+		q.FilterName("*")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 {
+		t.Fatalf("got %d servers, want 1", len(servers))
+	}
+	if filters := api.Requests()[0].Filters; len(filters) != 1 {
+		t.Fatalf("wildcard name should not be sent, got %v", filters)
+	}
+}
+
+func TestSteamWebAPIQuerierBadAddresses(t *testing.T) {
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: "10.0.0.1:27015"},
+		webapitest.Entry{Addr: "not an address"},
+		webapitest.Entry{Addr: "10.0.0.2:x", Gameport: 27016},
+		webapitest.Entry{Addr: "10.0.0.3:27017"},
+	)
+
+	servers, err := queryAll(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unparseable ports fall back to the game port.
+	want := []string{"10.0.0.1:27015", "10.0.0.2:27016", "10.0.0.3:27017"}
+	if len(servers) != len(want) {
+		t.Fatalf("got %v, want %v", servers, want)
+	}
+	for i := range want {
+		if servers[i].String() != want[i] {
+			t.Fatalf("got %v, want %v", servers, want)
+		}
+	}
+}
+
+func TestSteamWebAPIQuerierEmptyList(t *testing.T) {
+	newTestWebAPI(t)
+
+	called := false
+	q, _ := valve.NewSteamWebAPIQuerier("test-key")
+	err := q.Query(func(batch valve.ServerList) error {
+		called = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Fatal("callback should not run for an empty list")
+	}
+}
+
+func TestSteamWebAPIQuerierListings(t *testing.T) {
+	newTestWebAPI(t,
+		webapitest.Entry{Addr: "10.0.0.1:27015", Players: 12, MaxPlayers: 24, Bots: 2},
+		webapitest.Entry{Addr: "10.0.0.2:27015", MaxPlayers: 32},
+	)
+
+	q, _ := valve.NewSteamWebAPIQuerier("test-key")
+	var listings []*valve.ServerListing
+	err := q.QueryListings(func(batch []*valve.ServerListing) error {
+		listings = append(listings, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listings) != 2 {
+		t.Fatalf("got %d listings", len(listings))
+	}
+	got := *listings[0]
+	if got.Addr.String() != "10.0.0.1:27015" || got.Players != 12 || got.MaxPlayers != 24 || got.Bots != 2 {
+		t.Errorf("got %+v", got)
+	}
+	if listings[1].Players != 0 || listings[1].MaxPlayers != 32 {
+		t.Errorf("got %+v", *listings[1])
+	}
+}
+
+func TestSteamWebAPIQuerierFailures(t *testing.T) {
+	tests := []struct {
+		failure webapitest.Failure
+		want    string
+	}{
+		{webapitest.FailUnauthorized, "invalid API key (status 401)"},
+		{webapitest.FailForbidden, "invalid API key (status 403)"},
+		{webapitest.FailRateLimited, "rate limit exceeded (status 429)"},
+		{webapitest.FailInternalError, "steam API service error (status 500)"},
+		{webapitest.FailBadGateway, "steam API service error (status 502)"},
+		{webapitest.FailServiceUnavailable, "steam API service error (status 503)"},
+		{webapitest.FailMalformedJSON, "invalid JSON format"},
+	}
+
+	api := newTestWebAPI(t, webapitest.Entry{Addr: "10.0.0.1:27015"})
+	for _, test := range tests {
+		api.Fail(test.failure)
+
+		_, err := queryAll(t, nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("failure %d: got %v, want %q", test.failure, err, test.want)
+		}
+		if err != nil && strings.Contains(err.Error(), "test-key") {
+			t.Errorf("failure %d: error leaks the API key: %v", test.failure, err)
+		}
+	}
+}
+
+func TestSteamWebAPIQuerierWrongKey(t *testing.T) {
+	api := newTestWebAPI(t, webapitest.Entry{Addr: "10.0.0.1:27015"})
+	api.Key = "another-key"
+
+	_, err := queryAll(t, nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("got %v, want a 403 error", err)
+	}
+}
+
+func TestSteamWebAPIQuerierConnectionError(t *testing.T) {
+	api := newTestWebAPI(t)
+	api.Close()
+
+	_, err := queryAll(t, nil)
+	if err == nil || !strings.Contains(err.Error(), "connection error") {
+		t.Fatalf("got %v, want a connection error", err)
+	}
+}
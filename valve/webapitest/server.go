@@ -0,0 +1,234 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Package webapitest provides a fake IGameServersService/GetServerList
+// endpoint for testing code built on SteamWebAPIQuerier.
+package webapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The path SteamWebAPIQuerier requests, relative to the API host.
+const ServerListPath = "/IGameServersService/GetServerList/v1/"
+
+// A Failure makes the fake API fail in a specific way.
+type Failure int
+
+const (
+	FailNone Failure = iota
+	FailUnauthorized
+	FailForbidden
+	FailRateLimited
+	FailInternalError
+	FailBadGateway
+	FailServiceUnavailable
+	FailMalformedJSON
+)
+
+// An Entry is a single server in a GetServerList response.
+type Entry struct {
+	Addr       string `json:"addr"`
+	Gameport   int    `json:"gameport"`
+	Steamid    string `json:"steamid"`
+	Name       string `json:"name"`
+	Appid      int    `json:"appid"`
+	Gamedir    string `json:"gamedir"`
+	Version    string `json:"version"`
+	Product    string `json:"product"`
+	Region     int    `json:"region"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"`
+	Bots       int    `json:"bots"`
+	Map        string `json:"map"`
+	Secure     bool   `json:"secure"`
+	Dedicated  bool   `json:"dedicated"`
+	Os         string `json:"os"`
+	GameType   string `json:"gametype"`
+}
+
+// A Filter is a single key/value pair from a master server filter string.
+type Filter struct {
+	Key   string
+	Value string
+}
+
+// A Request records what a client asked for.
+type Request struct {
+	Key     string
+	Limit   int
+	Filters []Filter
+}
+
+// A Server is a fake Steam Web API. Requests to unknown paths return 404.
+type Server struct {
+	*httptest.Server
+
+	// If set, requests with a different key are rejected with 403.
+	Key string
+
+	mu       sync.Mutex
+	entries  []Entry
+	failure  Failure
+	requests []Request
+}
+
+// Start a fake API serving the given entries.
+func NewServer(entries ...Entry) *Server {
+	server := &Server{
+		entries: entries,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ServerListPath, server.handleServerList)
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+// The URL to use in place of valve.SteamWebAPIURL.
+func (s *Server) ServerListURL() string {
+	return s.URL + ServerListPath
+}
+
+// Replace the servers returned by the API.
+func (s *Server) SetEntries(entries ...Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+}
+
+// Make every subsequent request fail. FailNone restores normal behavior.
+func (s *Server) Fail(failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = failure
+}
+
+// All requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Parse a filter string such as \appid\730\name_match\foo*.
+func ParseFilter(filter string) []Filter {
+	parts := strings.Split(strings.TrimPrefix(filter, "\\"), "\\")
+	var filters []Filter
+	for i := 0; i+1 < len(parts); i += 2 {
+		filters = append(filters, Filter{Key: parts[i], Value: parts[i+1]})
+	}
+	return filters
+}
+
+// Reports whether an entry passes every filter the fake understands: appid,
+// name_match, gameaddr and gamedir. Other keys are accepted and ignored.
+func Matches(entry *Entry, filters []Filter) bool {
+	for _, filter := range filters {
+		switch filter.Key {
+		case "appid":
+			if strconv.Itoa(entry.Appid) != filter.Value {
+				return false
+			}
+		case "name_match":
+			if !matchName(entry.Name, filter.Value) {
+				return false
+			}
+		case "gameaddr":
+			if !matchAddr(entry, filter.Value) {
+				return false
+			}
+		case "gamedir":
+			if entry.Gamedir != filter.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// name_match is case-insensitive and supports * as a wildcard.
+func matchName(name string, pattern string) bool {
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	re, err := regexp.Compile("(?is)^" + expr + "$")
+	return err == nil && re.MatchString(name)
+}
+
+func matchAddr(entry *Entry, addr string) bool {
+	if entry.Addr == addr {
+		return true
+	}
+
+	// Without a port, match any server on the host.
+	host, _, err := net.SplitHostPort(entry.Addr)
+	return err == nil && host == addr
+}
+
+func (s *Server) handleServerList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	request := Request{
+		Key:     query.Get("key"),
+		Filters: ParseFilter(query.Get("filter")),
+	}
+	request.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	failure := s.failure
+	var entries []Entry
+	for i := range s.entries {
+		if Matches(&s.entries[i], request.Filters) {
+			entries = append(entries, s.entries[i])
+		}
+	}
+	s.mu.Unlock()
+
+	if s.Key != "" && request.Key != s.Key {
+		failure = FailForbidden
+	}
+	if request.Limit > 0 && len(entries) > request.Limit {
+		entries = entries[:request.Limit]
+	}
+
+	switch failure {
+	case FailUnauthorized:
+		http.Error(w, "<html><body>Unauthorized</body></html>", http.StatusUnauthorized)
+		return
+	case FailForbidden:
+		http.Error(w, "<html><body>Forbidden</body></html>", http.StatusForbidden)
+		return
+	case FailRateLimited:
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	case FailInternalError:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	case FailBadGateway:
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	case FailServiceUnavailable:
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	case FailMalformedJSON:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"response":{"servers":[{"addr":`)
+		return
+	}
+
+	var response struct {
+		Response struct {
+			Servers []Entry `json:"servers"`
+		} `json:"response"`
+	}
+	response.Response.Servers = entries
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(&response)
+}